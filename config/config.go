@@ -267,6 +267,7 @@ var ConfigGetters = map[string]ConfigGetter{defaultNamespace: DefaultConfigGette
 
 var (
 	simpleURLKeysPattern    = regexp.MustCompile(`\{([a-zA-Z\-_0-9]+)\}`)
+	backendURLKeysPattern   = regexp.MustCompile(`\{([a-zA-Z\-_0-9\.]+)\}`)
 	sequentialParamsPattern = regexp.MustCompile(`^resp[\d]+_.*$`)
 	debugPattern            = "^[^/]|/__debug(/.*)?$"
 	errInvalidHost          = errors.New("invalid host")
@@ -351,6 +352,10 @@ func (s *ServiceConfig) paramExtractionPattern() *regexp.Regexp {
 }
 
 func (s *ServiceConfig) extractPlaceHoldersFromURLTemplate(subject string, pattern *regexp.Regexp) []string {
+	return extractPlaceHolders(subject, pattern)
+}
+
+func extractPlaceHolders(subject string, pattern *regexp.Regexp) []string {
 	matches := pattern.FindAllStringSubmatch(subject, -1)
 	keys := make([]string, len(matches))
 	for k, v := range matches {
@@ -385,50 +390,120 @@ func (s *ServiceConfig) initEndpointDefaults(e int) {
 func (s *ServiceConfig) initBackendDefaults(e, b int) {
 	endpoint := s.Endpoints[e]
 	backend := endpoint.Backend[b]
+	setBackendDefaults(s.uriParser, backend, s.Host, endpoint.Method)
+	backend.Timeout = endpoint.Timeout
+	backend.ConcurrentCalls = endpoint.ConcurrentCalls
+	backend.Decoder = encoding.GetWithConfig(strings.ToLower(backend.Encoding), backend.ExtraConfig)(backend.IsCollection)
+}
+
+func (s *ServiceConfig) initBackendURLMappings(e, b int, inputParams map[string]interface{}) error {
+	return parseBackendURLPattern(s.uriParser, s.Endpoints[e].Backend[b], inputParams)
+}
+
+// setBackendDefaults sanitizes the hosts of the backend and applies the default hosts and method
+func setBackendDefaults(uriParser URIParser, backend *Backend, hosts []string, method string) {
 	if len(backend.Host) == 0 {
-		backend.Host = s.Host
+		backend.Host = hosts
 	} else if !backend.HostSanitizationDisabled {
-		backend.Host = s.uriParser.CleanHosts(backend.Host)
+		backend.Host = uriParser.CleanHosts(backend.Host)
 	}
 	if backend.Method == "" {
-		backend.Method = endpoint.Method
+		backend.Method = method
 	}
-	backend.Timeout = endpoint.Timeout
-	backend.ConcurrentCalls = endpoint.ConcurrentCalls
-	backend.Decoder = encoding.Get(strings.ToLower(backend.Encoding))(backend.IsCollection)
 }
 
-func (s *ServiceConfig) initBackendURLMappings(e, b int, inputParams map[string]interface{}) error {
-	backend := s.Endpoints[e].Backend[b]
-
-	backend.URLPattern = s.uriParser.CleanPath(backend.URLPattern)
+// parseBackendURLPattern replaces the params of the url pattern of the backend with the keys of
+// the template. If the input params are nil, the params are not validated.
+func parseBackendURLPattern(uriParser URIParser, backend *Backend, inputParams map[string]interface{}) error {
+	backend.URLPattern = uriParser.CleanPath(backend.URLPattern)
 
-	outputParams := s.extractPlaceHoldersFromURLTemplate(backend.URLPattern, simpleURLKeysPattern)
+	outputParams := extractPlaceHolders(backend.URLPattern, backendURLKeysPattern)
 
 	outputSet := map[string]interface{}{}
 	for op := range outputParams {
 		outputSet[outputParams[op]] = nil
 	}
 
-	if len(outputSet) > len(inputParams) {
+	if inputParams != nil && len(outputSet) > len(inputParams) {
 		return fmt.Errorf("Too many output params! input: %v, output: %v\n", outputSet, outputParams)
 	}
 
 	tmp := backend.URLPattern
 	backend.URLKeys = make([]string, len(outputParams))
 	for o := range outputParams {
-		if !sequentialParamsPattern.MatchString(outputParams[o]) {
+		if inputParams != nil && !sequentialParamsPattern.MatchString(outputParams[o]) {
 			if _, ok := inputParams[outputParams[o]]; !ok {
 				return fmt.Errorf("Undefined output param [%s]! input: %v, output: %v\n", outputParams[o], inputParams, outputParams)
 			}
 		}
-		tmp = strings.Replace(tmp, "{"+outputParams[o]+"}", "{{."+strings.Title(outputParams[o])+"}}", -1)
-		backend.URLKeys = append(backend.URLKeys, strings.Title(outputParams[o]))
+		key := strings.Title(outputParams[o])
+		if i := strings.Index(outputParams[o], "."); i > 0 {
+			// nested paths of the sequential params keep the case of the fields
+			key = strings.Title(outputParams[o][:i]) + outputParams[o][i:]
+		}
+		tmp = strings.Replace(tmp, "{"+outputParams[o]+"}", "{{."+key+"}}", -1)
+		backend.URLKeys = append(backend.URLKeys, key)
 	}
 	backend.URLPattern = tmp
 	return nil
 }
 
+// NewBackend creates a backend from a definition embedded in an extra config, like the fallback
+// backends of the proxy package. The definition is decoded like the backends of the config file
+// and initialized with the rules of Init. The fields not declared are taken from the parent
+// backend, except the extra config. As the endpoint is unknown, the params of the url pattern are
+// not validated.
+func NewBackend(parent *Backend, definition map[string]interface{}) (*Backend, error) {
+	data, err := json.Marshal(definition)
+	if err != nil {
+		return nil, err
+	}
+	p := parseableBackend{
+		Group:                    parent.Group,
+		Method:                   parent.Method,
+		Host:                     append([]string{}, parent.Host...),
+		HostSanitizationDisabled: parent.HostSanitizationDisabled,
+		Blacklist:                append([]string{}, parent.Blacklist...),
+		Whitelist:                append([]string{}, parent.Whitelist...),
+		Mapping:                  map[string]string{},
+		Encoding:                 parent.Encoding,
+		IsCollection:             parent.IsCollection,
+		Target:                   parent.Target,
+		SD:                       parent.SD,
+	}
+	for k, v := range parent.Mapping {
+		p.Mapping[k] = v
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	backend := p.normalize()
+	backend.Timeout = parent.Timeout
+	backend.ConcurrentCalls = parent.ConcurrentCalls
+	backend.ExtraConfig.sanitize()
+
+	uriParser := NewURIParser()
+	setBackendDefaults(uriParser, backend, parent.Host, parent.Method)
+
+	if _, ok := definition["url_pattern"]; ok {
+		if err := parseBackendURLPattern(uriParser, backend, nil); err != nil {
+			return nil, err
+		}
+	} else {
+		backend.URLPattern = parent.URLPattern
+		backend.URLKeys = parent.URLKeys
+	}
+
+	_, hasEncoding := definition["encoding"]
+	_, hasCollection := definition["is_collection"]
+	if hasEncoding || hasCollection {
+		backend.Decoder = encoding.GetWithConfig(strings.ToLower(backend.Encoding), backend.ExtraConfig)(backend.IsCollection)
+	} else {
+		backend.Decoder = parent.Decoder
+	}
+	return backend, nil
+}
+
 func (e *EndpointConfig) validate() error {
 	matched, err := regexp.MatchString(debugPattern, e.Endpoint)
 	if err != nil {
@@ -39,6 +39,7 @@ func TestConfig_initBackendURLMappings_ok(t *testing.T) {
 		"supu/{tupu_56}/{supu-5t6}?a={foo}&b={foo}",
 		"supu/{tupu_56}{supu-5t6}?a={foo}&b={foo}",
 		"supu/tupu{supu-5t6}?a={foo}&b={foo}",
+		"supu/{tupu}/{resp0_user.id}?a={resp1_items.0.name}",
 	}
 
 	expected := []string{
@@ -48,6 +49,7 @@ func TestConfig_initBackendURLMappings_ok(t *testing.T) {
 		"/supu/{{.Tupu_56}}/{{.Supu-5t6}}?a={{.Foo}}&b={{.Foo}}",
 		"/supu/{{.Tupu_56}}{{.Supu-5t6}}?a={{.Foo}}&b={{.Foo}}",
 		"/supu/tupu{{.Supu-5t6}}?a={{.Foo}}&b={{.Foo}}",
+		"/supu/{{.Tupu}}/{{.Resp0_user.id}}?a={{.Resp1_items.0.name}}",
 	}
 
 	backend := Backend{}
@@ -105,6 +107,60 @@ func TestConfig_initBackendURLMappings_undefinedOutput(t *testing.T) {
 	}
 }
 
+func TestNewBackend(t *testing.T) {
+	parent := Backend{
+		Group:      "users",
+		Method:     "POST",
+		Host:       []string{"http://primary"},
+		URLPattern: "/primary/{{.Id}}",
+		URLKeys:    []string{"Id"},
+		Mapping:    map[string]string{"a": "b"},
+		Timeout:    time.Second,
+		ExtraConfig: ExtraConfig{
+			"namespace": map[string]interface{}{"key": 1},
+		},
+	}
+
+	backend, err := NewBackend(&parent, map[string]interface{}{
+		"host":        []interface{}{"replica:8080"},
+		"url_pattern": "users/{id}/{resp0_user.id}",
+		"mapping":     map[string]interface{}{"c": "d"},
+		"encoding":    "xml",
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if backend.URLPattern != "/users/{{.Id}}/{{.Resp0_user.id}}" {
+		t.Errorf("unexpected url pattern: %s", backend.URLPattern)
+	}
+	if len(backend.Host) != 1 || backend.Host[0] != "http://replica:8080" {
+		t.Errorf("unexpected hosts: %v", backend.Host)
+	}
+	if backend.Method != "POST" || backend.Group != "users" || backend.Timeout != time.Second || len(backend.ExtraConfig) != 0 {
+		t.Errorf("unexpected backend: %+v", backend)
+	}
+	if len(backend.Mapping) != 2 || backend.Encoding != "xml" || backend.Decoder == nil {
+		t.Errorf("unexpected backend: %+v", backend)
+	}
+	if len(parent.Mapping) != 1 || parent.Host[0] != "http://primary" {
+		t.Errorf("the parent has been modified: %+v", parent)
+	}
+
+	backend, err = NewBackend(&parent, map[string]interface{}{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if backend.URLPattern != parent.URLPattern || len(backend.URLKeys) != 1 || backend.Host[0] != "http://primary" {
+		t.Errorf("unexpected backend: %+v", backend)
+	}
+
+	if _, err := NewBackend(&parent, map[string]interface{}{"host": "replica"}); err == nil {
+		t.Error("expecting an error")
+	}
+}
+
 func TestConfig_init(t *testing.T) {
 	supuBackend := Backend{
 		URLPattern: "/__debug/supu",
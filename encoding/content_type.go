@@ -0,0 +1,179 @@
+package encoding
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/vm-affekt/krakend/register"
+)
+
+const (
+	// AUTO is the key for the encoding selecting the decoder with the Content-Type of the response
+	AUTO = "auto"
+	// SAFE is the key for the encoding selecting the decoder with the Content-Type of the response
+	// and decoding the body with the fallback decoder if the selected one fails
+	SAFE = "safe"
+)
+
+// A HeaderAwareDecoder is a function that reads from the reader and decodes it into a map of
+// interfaces, using the headers of the response
+type HeaderAwareDecoder func(http.Header, io.Reader, *map[string]interface{}) error
+
+// RegisterMediaType makes the auto and safe encodings decode the responses with the media type
+// with the decoder registered under the name. The media types starting with a '+' match the
+// structured syntax suffixes, like application/problem+json. The media types registered after the
+// creation of a decoder are ignored by it (see NewContentTypeDecoder).
+func RegisterMediaType(mediaType, name string) {
+	mediaTypes.Register(strings.ToLower(mediaType), name)
+}
+
+var (
+	mediaTypes        = initMediaTypes()
+	defaultMediaTypes = map[string]string{
+		"application/json":                  JSON,
+		"text/json":                         JSON,
+		"+json":                             JSON,
+		"application/xml":                   XML,
+		"text/xml":                          XML,
+		"+xml":                              XML,
+		"application/yaml":                  YAML,
+		"application/x-yaml":                YAML,
+		"text/yaml":                         YAML,
+		"text/x-yaml":                       YAML,
+		"+yaml":                             YAML,
+		"application/x-www-form-urlencoded": FORM,
+		"text/csv":                          CSV,
+		"text/plain":                        STRING,
+		"text/html":                         STRING,
+	}
+)
+
+func initMediaTypes() register.Untyped {
+	r := register.NewUntyped()
+	for k, v := range defaultMediaTypes {
+		r.Register(k, v)
+	}
+	return r
+}
+
+// NewContentTypeDecoder returns a HeaderAwareDecoder selecting the decoder with the Content-Type of
+// the response (see RegisterMediaType). The responses with an unknown or missing Content-Type are
+// decoded with the fallback decoder. In the safe mode, the body is also decoded with the fallback
+// decoder when the selected one fails. The decoders of all the known media types are built once,
+// when the HeaderAwareDecoder is created.
+//
+// The fallback decoder and some extra media types can be set in the extra config of the backend.
+// The default fallback is the json decoder for the auto mode and the string one for the safe mode.
+//
+//	"github.com/vm-affekt/krakend/encoding": {
+//		"fallback": "string",
+//		"media_types": {
+//			"application/vnd.users.v1": "json"
+//		}
+//	}
+func NewContentTypeDecoder(mode string, isCollection bool, extra map[string]interface{}) HeaderAwareDecoder {
+	safe := strings.ToLower(mode) == SAFE
+	fallbackName := JSON
+	if safe {
+		fallbackName = STRING
+	}
+	custom := map[string]string{}
+	if cfg, ok := extra[Namespace].(map[string]interface{}); ok {
+		if v, ok := cfg["fallback"].(string); ok && v != "" {
+			fallbackName = strings.ToLower(v)
+		}
+		if v, ok := cfg["media_types"].(map[string]interface{}); ok {
+			for k, name := range v {
+				if n, ok := name.(string); ok {
+					custom[strings.ToLower(k)] = strings.ToLower(n)
+				}
+			}
+		}
+	}
+
+	fallback := GetWithConfig(fallbackName, extra)(isCollection)
+	table := newDecoderTable(custom, extra, isCollection)
+	selectDecoder := func(h http.Header) (Decoder, bool) {
+		mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+		if err != nil {
+			return fallback, false
+		}
+		if d, ok := table.lookUp(mediaType); ok {
+			return d, true
+		}
+		return fallback, false
+	}
+
+	if !safe {
+		return func(h http.Header, r io.Reader, v *map[string]interface{}) error {
+			d, _ := selectDecoder(h)
+			return d(r, v)
+		}
+	}
+
+	return func(h http.Header, r io.Reader, v *map[string]interface{}) error {
+		d, selected := selectDecoder(h)
+		if !selected {
+			return d(r, v)
+		}
+		body, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		var data map[string]interface{}
+		err = d(bytes.NewReader(body), &data)
+		if err == nil {
+			*(v) = data
+			return nil
+		}
+		if fallbackErr := fallback(bytes.NewReader(body), v); fallbackErr != nil {
+			return err
+		}
+		return nil
+	}
+}
+
+// decoderTable maps the media types to their decoders
+type decoderTable map[string]Decoder
+
+// newDecoderTable builds the decoders of the registered media types and the custom ones, which take
+// precedence. The decoders are shared by the media types with the same name.
+func newDecoderTable(custom map[string]string, extra map[string]interface{}, isCollection bool) decoderTable {
+	names := map[string]string{}
+	for k, v := range mediaTypes.Clone() {
+		if name, ok := v.(string); ok {
+			names[k] = name
+		}
+	}
+	for k, name := range custom {
+		names[k] = name
+	}
+
+	byName := map[string]Decoder{}
+	table := make(decoderTable, len(names))
+	for mediaType, name := range names {
+		d, ok := byName[name]
+		if !ok {
+			d = GetWithConfig(name, extra)(isCollection)
+			byName[name] = d
+		}
+		table[mediaType] = d
+	}
+	return table
+}
+
+// lookUp returns the decoder of the media type or, if missing, the one of its structured syntax suffix
+func (t decoderTable) lookUp(mediaType string) (Decoder, bool) {
+	if d, ok := t[mediaType]; ok {
+		return d, true
+	}
+	if i := strings.LastIndex(mediaType, "+"); i > 0 {
+		d, ok := t[mediaType[i:]]
+		return d, ok
+	}
+	return nil, false
+}
@@ -0,0 +1,142 @@
+package encoding
+
+import (
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewContentTypeDecoder_auto(t *testing.T) {
+	decoder := NewContentTypeDecoder(AUTO, false, nil)
+	for _, tc := range []struct {
+		contentType string
+		body        string
+		expected    map[string]interface{}
+	}{
+		{contentType: "application/json; charset=utf-8", body: `{"foo":"bar"}`, expected: map[string]interface{}{"foo": "bar"}},
+		{contentType: "application/problem+json", body: `{"foo":"bar"}`, expected: map[string]interface{}{"foo": "bar"}},
+		{contentType: "text/xml", body: `<a><foo>bar</foo></a>`, expected: map[string]interface{}{"foo": "bar"}},
+		{contentType: "application/atom+xml", body: `<a><foo>bar</foo></a>`, expected: map[string]interface{}{"foo": "bar"}},
+		{contentType: "application/x-yaml", body: "foo: bar\n", expected: map[string]interface{}{"foo": "bar"}},
+		{contentType: "application/x-www-form-urlencoded", body: "foo=bar", expected: map[string]interface{}{"foo": "bar"}},
+		{contentType: "text/csv", body: "foo\nbar\n", expected: map[string]interface{}{"foo": "bar"}},
+		{contentType: "text/html", body: "<p>bar</p>", expected: map[string]interface{}{"content": "<p>bar</p>"}},
+		{contentType: "", body: `{"foo":"bar"}`, expected: map[string]interface{}{"foo": "bar"}},
+		{contentType: "application/octet-stream", body: `{"foo":"bar"}`, expected: map[string]interface{}{"foo": "bar"}},
+		{contentType: "invalid;;", body: `{"foo":"bar"}`, expected: map[string]interface{}{"foo": "bar"}},
+	} {
+		var result map[string]interface{}
+		h := http.Header{}
+		h.Set("Content-Type", tc.contentType)
+		if err := decoder(h, strings.NewReader(tc.body), &result); err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.contentType, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(result, tc.expected) {
+			t.Errorf("%s: unexpected result: %v", tc.contentType, result)
+		}
+	}
+
+	var result map[string]interface{}
+	h := http.Header{"Content-Type": []string{"application/json"}}
+	if err := decoder(h, strings.NewReader("<html></html>"), &result); err == nil {
+		t.Error("error expected")
+	}
+}
+
+func TestNewContentTypeDecoder_safe(t *testing.T) {
+	decoder := NewContentTypeDecoder(SAFE, true, nil)
+	var result map[string]interface{}
+
+	h := http.Header{"Content-Type": []string{"application/json"}}
+	if err := decoder(h, strings.NewReader(`["foo"]`), &result); err != nil {
+		t.Error("unexpected error:", err.Error())
+	}
+	if !reflect.DeepEqual(result, map[string]interface{}{"collection": []interface{}{"foo"}}) {
+		t.Errorf("unexpected result: %v", result)
+	}
+
+	if err := decoder(h, strings.NewReader("<html>error</html>"), &result); err != nil {
+		t.Error("unexpected error:", err.Error())
+	}
+	if !reflect.DeepEqual(result, map[string]interface{}{"content": "<html>error</html>"}) {
+		t.Errorf("unexpected result: %v", result)
+	}
+
+	if err := decoder(http.Header{}, strings.NewReader(`["foo"]`), &result); err != nil {
+		t.Error("unexpected error:", err.Error())
+	}
+	if !reflect.DeepEqual(result, map[string]interface{}{"content": `["foo"]`}) {
+		t.Errorf("unexpected result: %v", result)
+	}
+
+	errorMsg := erroredReader("some error")
+	if err := decoder(h, errorMsg, &result); err == nil || err.Error() != errorMsg.Error() {
+		t.Error("unexpected error:", err)
+	}
+}
+
+func TestNewContentTypeDecoder_config(t *testing.T) {
+	RegisterMediaType("application/vnd.report", CSV)
+	defer func() { mediaTypes = initMediaTypes() }()
+
+	decoder := NewContentTypeDecoder(SAFE, false, map[string]interface{}{
+		Namespace: map[string]interface{}{
+			"fallback":    "json",
+			"media_types": map[string]interface{}{"application/vnd.users.v1": "XML"},
+			CSV:           map[string]interface{}{"delimiter": ";"},
+		},
+	})
+
+	for _, tc := range []struct {
+		contentType string
+		body        string
+		expected    map[string]interface{}
+	}{
+		{contentType: "application/vnd.users.v1", body: `<a><foo>bar</foo></a>`, expected: map[string]interface{}{"foo": "bar"}},
+		{contentType: "application/vnd.users.v1", body: `{"foo":"bar"}`, expected: map[string]interface{}{"foo": "bar"}},
+		{contentType: "application/vnd.report", body: "foo;supu\nbar;tupu\n", expected: map[string]interface{}{"foo": "bar", "supu": "tupu"}},
+		{contentType: "", body: `{"foo":"bar"}`, expected: map[string]interface{}{"foo": "bar"}},
+	} {
+		var result map[string]interface{}
+		h := http.Header{"Content-Type": []string{tc.contentType}}
+		if err := decoder(h, strings.NewReader(tc.body), &result); err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.contentType, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(result, tc.expected) {
+			t.Errorf("%s: unexpected result: %v", tc.contentType, result)
+		}
+	}
+
+	var result map[string]interface{}
+	h := http.Header{"Content-Type": []string{"application/vnd.users.v1"}}
+	if err := decoder(h, strings.NewReader("plain text"), &result); err == nil {
+		t.Error("error expected")
+	}
+}
+
+func TestNewContentTypeDecoder_decodersBuiltOnce(t *testing.T) {
+	calls := 0
+	Register("counting", func(isCollection bool) func(io.Reader, *map[string]interface{}) error {
+		calls++
+		return NewJSONDecoder(isCollection)
+	})
+	RegisterMediaType("application/vnd.counting", "counting")
+	RegisterMediaType("+counting", "counting")
+	defer func() { mediaTypes = initMediaTypes() }()
+
+	decoder := NewContentTypeDecoder(AUTO, false, nil)
+	for _, contentType := range []string{"application/vnd.counting", "application/vnd.users+counting", "application/vnd.counting"} {
+		var result map[string]interface{}
+		h := http.Header{"Content-Type": []string{contentType}}
+		if err := decoder(h, strings.NewReader(`{"foo":"bar"}`), &result); err != nil {
+			t.Errorf("%s: unexpected error: %s", contentType, err.Error())
+		}
+	}
+	if calls != 1 {
+		t.Errorf("unexpected number of decoders built: %d", calls)
+	}
+}
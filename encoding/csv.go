@@ -0,0 +1,129 @@
+package encoding
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// CSV is the key for the csv encoding
+const CSV = "csv"
+
+// CSVOptions defines how the CSV documents are parsed
+type CSVOptions struct {
+	// Comma is the field delimiter
+	Comma rune
+	// Comment, if not 0, is the character starting the lines to ignore
+	Comment rune
+}
+
+// DefaultCSVOptions are the options of the csv decoder registered by default
+var DefaultCSVOptions = CSVOptions{Comma: ','}
+
+// NewCSVDecoder returns the right CSV decoder with the default options
+func NewCSVDecoder(isCollection bool) func(io.Reader, *map[string]interface{}) error {
+	return NewCSVDecoderFactory(DefaultCSVOptions)(isCollection)
+}
+
+// NewCSVDecoderFactory returns a DecoderFactory building CSV decoders with the received options.
+//
+// The first row of the document is the header and the rest of them are decoded as objects with
+// the names of the columns as keys. The collection decoders return all the rows under the
+// collection key, while the entity ones return just the first row.
+func NewCSVDecoderFactory(opts CSVOptions) DecoderFactory {
+	c := csvDecoder{opts}
+	return func(isCollection bool) func(io.Reader, *map[string]interface{}) error {
+		if isCollection {
+			return c.decodeCollection
+		}
+		return c.decode
+	}
+}
+
+// newCSVDecoderFactoryFromConfig parses the csv options of the extra config of a backend
+//
+//	"github.com/vm-affekt/krakend/encoding": {
+//		"csv": {
+//			"delimiter": ";",
+//			"comment": "#"
+//		}
+//	}
+func newCSVDecoderFactoryFromConfig(cfg map[string]interface{}) DecoderFactory {
+	opts := DefaultCSVOptions
+	tmp, ok := cfg[CSV].(map[string]interface{})
+	if !ok {
+		return NewCSVDecoderFactory(opts)
+	}
+	if v, ok := tmp["delimiter"].(string); ok && v != "" {
+		opts.Comma, _ = utf8.DecodeRuneInString(v)
+	}
+	if v, ok := tmp["comment"].(string); ok && v != "" {
+		opts.Comment, _ = utf8.DecodeRuneInString(v)
+	}
+	return NewCSVDecoderFactory(opts)
+}
+
+type csvDecoder struct {
+	opts CSVOptions
+}
+
+func (c csvDecoder) decode(r io.Reader, v *map[string]interface{}) error {
+	rows, err := c.rows(r, 1)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		*(v) = map[string]interface{}{}
+		return nil
+	}
+	*(v) = rows[0].(map[string]interface{})
+	return nil
+}
+
+func (c csvDecoder) decodeCollection(r io.Reader, v *map[string]interface{}) error {
+	rows, err := c.rows(r, -1)
+	if err != nil {
+		return err
+	}
+	*(v) = map[string]interface{}{"collection": rows}
+	return nil
+}
+
+// rows decodes up to max rows (all of them, if negative). The missing fields of the short rows
+// are not added and the extra fields of the long ones are ignored.
+func (c csvDecoder) rows(r io.Reader, max int) ([]interface{}, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = c.opts.Comma
+	reader.Comment = c.opts.Comment
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	if len(header) > 0 {
+		// the documents exported by some spreadsheets start with a BOM
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+
+	rows := []interface{}{}
+	for max < 0 || len(rows) < max {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := make(map[string]interface{}, len(header))
+		for i, name := range header {
+			if i >= len(record) {
+				break
+			}
+			row[name] = record[i]
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
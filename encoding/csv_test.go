@@ -0,0 +1,79 @@
+package encoding
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const csvDocument = "\ufeffid,name,email\n1,John,john@example.tld\n2,\"Doe, Jane\",jane@example.tld\n3,Bob\n"
+
+func TestNewCSVDecoder_collection(t *testing.T) {
+	decoder := NewCSVDecoder(true)
+	var result map[string]interface{}
+	if err := decoder(strings.NewReader(csvDocument), &result); err != nil {
+		t.Error("Unexpected error:", err.Error())
+		return
+	}
+	expected := map[string]interface{}{
+		"collection": []interface{}{
+			map[string]interface{}{"id": "1", "name": "John", "email": "john@example.tld"},
+			map[string]interface{}{"id": "2", "name": "Doe, Jane", "email": "jane@example.tld"},
+			map[string]interface{}{"id": "3", "name": "Bob"},
+		},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("unexpected result: %v", result)
+	}
+}
+
+func TestNewCSVDecoder_map(t *testing.T) {
+	decoder := NewCSVDecoder(false)
+	var result map[string]interface{}
+	if err := decoder(strings.NewReader(csvDocument), &result); err != nil {
+		t.Error("Unexpected error:", err.Error())
+		return
+	}
+	expected := map[string]interface{}{"id": "1", "name": "John", "email": "john@example.tld"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("unexpected result: %v", result)
+	}
+
+	if err := decoder(strings.NewReader("id,name\n"), &result); err != nil {
+		t.Error("Unexpected error:", err.Error())
+		return
+	}
+	if len(result) != 0 {
+		t.Errorf("unexpected result: %v", result)
+	}
+}
+
+func TestGetWithConfig_csv(t *testing.T) {
+	decoder := GetWithConfig(CSV, map[string]interface{}{
+		Namespace: map[string]interface{}{
+			CSV: map[string]interface{}{"delimiter": ";", "comment": "#"},
+		},
+	})(true)
+	var result map[string]interface{}
+	if err := decoder(strings.NewReader("# report\nid;name\n1;John\n"), &result); err != nil {
+		t.Error("Unexpected error:", err.Error())
+		return
+	}
+	expected := map[string]interface{}{
+		"collection": []interface{}{map[string]interface{}{"id": "1", "name": "John"}},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("unexpected result: %v", result)
+	}
+}
+
+func TestNewCSVDecoder_ko(t *testing.T) {
+	for _, isCollection := range []bool{true, false} {
+		for _, doc := range []string{"", "id,name\n1,\"John\n"} {
+			var result map[string]interface{}
+			if err := NewCSVDecoder(isCollection)(strings.NewReader(doc), &result); err == nil {
+				t.Errorf("%q: error expected", doc)
+			}
+		}
+	}
+}
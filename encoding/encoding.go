@@ -32,6 +32,20 @@ func Get(name string) DecoderFactory {
 	return decoders.Get(name)
 }
 
+// Namespace is the key of the decoder options in the extra config of the backends
+const Namespace = "github.com/vm-affekt/krakend/encoding"
+
+// GetWithConfig looks up for the requested decoder by a key and configures it with the options
+// defined in the extra config of the backend, if the decoder supports them
+func GetWithConfig(name string, extra map[string]interface{}) DecoderFactory {
+	if cfg, ok := extra[Namespace].(map[string]interface{}); ok {
+		if f, ok := configurableDecoders[name]; ok {
+			return f(cfg)
+		}
+	}
+	return decoders.Get(name)
+}
+
 // NOOP is the key for the NoOp encoding
 const NOOP = "no-op"
 
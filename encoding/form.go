@@ -0,0 +1,43 @@
+package encoding
+
+import (
+	"io"
+	"io/ioutil"
+	"net/url"
+)
+
+// FORM is the key for the application/x-www-form-urlencoded encoding
+const FORM = "form"
+
+// NewFormDecoder return a form decoder. The form bodies can not contain collections, so the
+// isCollection flag is ignored.
+func NewFormDecoder(_ bool) func(io.Reader, *map[string]interface{}) error {
+	return FormDecoder
+}
+
+// FormDecoder implements the Decoder interface. The keys with a single value are decoded as
+// strings and the repeated ones as lists of strings.
+func FormDecoder(r io.Reader, v *map[string]interface{}) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return err
+	}
+	res := make(map[string]interface{}, len(values))
+	for k, vs := range values {
+		if len(vs) == 1 {
+			res[k] = vs[0]
+			continue
+		}
+		list := make([]interface{}, len(vs))
+		for i, s := range vs {
+			list[i] = s
+		}
+		res[k] = list
+	}
+	*(v) = res
+	return nil
+}
@@ -0,0 +1,38 @@
+package encoding
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewFormDecoder_ok(t *testing.T) {
+	for _, isCollection := range []bool{true, false} {
+		decoder := NewFormDecoder(isCollection)
+		var result map[string]interface{}
+		if err := decoder(strings.NewReader("foo=bar&supu=1&supu=2&empty=&msg=hello+world%21"), &result); err != nil {
+			t.Error("Unexpected error:", err.Error())
+			return
+		}
+		expected := map[string]interface{}{
+			"foo":   "bar",
+			"supu":  []interface{}{"1", "2"},
+			"empty": "",
+			"msg":   "hello world!",
+		}
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("unexpected result: %v", result)
+		}
+	}
+}
+
+func TestNewFormDecoder_ko(t *testing.T) {
+	var result map[string]interface{}
+	if err := NewFormDecoder(false)(strings.NewReader("foo=%zz"), &result); err == nil {
+		t.Error("error expected")
+	}
+	errorMsg := erroredReader("some error")
+	if err := NewFormDecoder(false)(errorMsg, &result); err == nil || err.Error() != errorMsg.Error() {
+		t.Error("Unexpected error:", err)
+	}
+}
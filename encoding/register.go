@@ -40,6 +40,18 @@ var (
 		JSON:   NewJSONDecoder,
 		STRING: NewStringDecoder,
 		NOOP:   noOpDecoderFactory,
+		XML:    NewXMLDecoder,
+		YAML:   NewYAMLDecoder,
+		FORM:   NewFormDecoder,
+		CSV:    NewCSVDecoder,
+		// the decoders not receiving the headers of the responses can only use the default
+		// fallback of the content type driven encodings (see NewContentTypeDecoder)
+		AUTO: NewJSONDecoder,
+		SAFE: NewStringDecoder,
+	}
+	configurableDecoders = map[string]func(map[string]interface{}) DecoderFactory{
+		XML: newXMLDecoderFactoryFromConfig,
+		CSV: newCSVDecoderFactoryFromConfig,
 	}
 )
 
@@ -0,0 +1,263 @@
+package encoding
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// XML is the key for the xml encoding
+const XML = "xml"
+
+// XMLOptions defines how the XML documents are converted into maps
+type XMLOptions struct {
+	// AttributePrefix is prepended to the names of the attributes
+	AttributePrefix string
+	// TextKey is the key of the text of the elements with attributes or children
+	TextKey string
+	// IgnoreAttributes drops the attributes of the elements
+	IgnoreAttributes bool
+	// AlwaysArray wraps every child element into an array. By default, only the repeated elements
+	// are converted into arrays.
+	AlwaysArray bool
+	// KeepRoot keeps the root element as the only key of the decoded entities
+	KeepRoot bool
+}
+
+// DefaultXMLOptions are the options of the xml decoder registered by default
+var DefaultXMLOptions = XMLOptions{
+	AttributePrefix: "@",
+	TextKey:         "#text",
+}
+
+// NewXMLDecoder returns the right XML decoder with the default options
+func NewXMLDecoder(isCollection bool) func(io.Reader, *map[string]interface{}) error {
+	return NewXMLDecoderFactory(DefaultXMLOptions)(isCollection)
+}
+
+// NewXMLDecoderFactory returns a DecoderFactory building XML decoders with the received options.
+//
+// The elements containing only text are decoded as strings and the rest of them as maps with their
+// attributes, their text and their children. The namespaces of the names are ignored. The entity
+// decoders return the content of the root element, while the collection ones return the list of
+// its children under the collection key.
+func NewXMLDecoderFactory(opts XMLOptions) DecoderFactory {
+	x := xmlDecoder{opts}
+	return func(isCollection bool) func(io.Reader, *map[string]interface{}) error {
+		if isCollection {
+			return x.decodeCollection
+		}
+		return x.decode
+	}
+}
+
+// newXMLDecoderFactoryFromConfig parses the xml options of the extra config of a backend
+//
+//	"github.com/vm-affekt/krakend/encoding": {
+//		"xml": {
+//			"attribute_prefix": "-",
+//			"text_key": "value",
+//			"ignore_attributes": false,
+//			"arrays": "always",
+//			"keep_root": true
+//		}
+//	}
+func newXMLDecoderFactoryFromConfig(cfg map[string]interface{}) DecoderFactory {
+	opts := DefaultXMLOptions
+	tmp, ok := cfg[XML].(map[string]interface{})
+	if !ok {
+		return NewXMLDecoderFactory(opts)
+	}
+	if v, ok := tmp["attribute_prefix"].(string); ok {
+		opts.AttributePrefix = v
+	}
+	if v, ok := tmp["text_key"].(string); ok && v != "" {
+		opts.TextKey = v
+	}
+	if v, ok := tmp["ignore_attributes"].(bool); ok {
+		opts.IgnoreAttributes = v
+	}
+	if v, ok := tmp["arrays"].(string); ok {
+		opts.AlwaysArray = strings.ToLower(v) == "always"
+	}
+	if v, ok := tmp["keep_root"].(bool); ok {
+		opts.KeepRoot = v
+	}
+	return NewXMLDecoderFactory(opts)
+}
+
+type xmlDecoder struct {
+	opts XMLOptions
+}
+
+func (x xmlDecoder) decode(r io.Reader, v *map[string]interface{}) error {
+	d := newXMLTokenizer(r)
+	root, err := rootElement(d)
+	if err != nil {
+		return err
+	}
+	content, err := x.element(d, root)
+	if err != nil {
+		return err
+	}
+	if x.opts.KeepRoot {
+		*(v) = map[string]interface{}{root.Name.Local: content}
+		return nil
+	}
+	if m, ok := content.(map[string]interface{}); ok {
+		*(v) = m
+		return nil
+	}
+	*(v) = map[string]interface{}{x.opts.TextKey: content}
+	return nil
+}
+
+func (x xmlDecoder) decodeCollection(r io.Reader, v *map[string]interface{}) error {
+	d := newXMLTokenizer(r)
+	if _, err := rootElement(d); err != nil {
+		return err
+	}
+	collection := []interface{}{}
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			item, err := x.element(d, t)
+			if err != nil {
+				return err
+			}
+			collection = append(collection, item)
+		case xml.EndElement:
+			*(v) = map[string]interface{}{"collection": collection}
+			return nil
+		}
+	}
+}
+
+// element decodes the content of the element until its end
+func (x xmlDecoder) element(d *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	m := map[string]interface{}{}
+	if !x.opts.IgnoreAttributes {
+		for _, a := range start.Attr {
+			if a.Name.Space == "xmlns" || a.Name.Local == "xmlns" {
+				continue
+			}
+			m[x.opts.AttributePrefix+a.Name.Local] = a.Value
+		}
+	}
+
+	text := []byte{}
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			child, err := x.element(d, t)
+			if err != nil {
+				return nil, err
+			}
+			x.add(m, t.Name.Local, child)
+		case xml.CharData:
+			text = append(text, t...)
+		case xml.EndElement:
+			s := strings.TrimSpace(string(text))
+			if len(m) == 0 {
+				return s, nil
+			}
+			if s != "" {
+				m[x.opts.TextKey] = s
+			}
+			return m, nil
+		}
+	}
+}
+
+func (x xmlDecoder) add(m map[string]interface{}, name string, v interface{}) {
+	current, ok := m[name]
+	if !ok {
+		if x.opts.AlwaysArray {
+			v = []interface{}{v}
+		}
+		m[name] = v
+		return
+	}
+	if list, ok := current.([]interface{}); ok {
+		m[name] = append(list, v)
+		return
+	}
+	m[name] = []interface{}{current, v}
+}
+
+func newXMLTokenizer(r io.Reader) *xml.Decoder {
+	d := xml.NewDecoder(r)
+	d.CharsetReader = charsetReader
+	return d
+}
+
+// rootElement skips the prolog of the document
+func rootElement(d *xml.Decoder) (xml.StartElement, error) {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			return start, nil
+		}
+	}
+}
+
+// charsetReader supports the latin1 documents, quite common in the legacy services, besides
+// the utf-8 ones
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1", "latin-1":
+		return &latin1Reader{r: bufio.NewReader(input)}, nil
+	case "us-ascii", "ascii":
+		return input, nil
+	}
+	return nil, fmt.Errorf("xml: unsupported charset %s", charset)
+}
+
+type latin1Reader struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func (l *latin1Reader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(l.buf) > 0 {
+			c := copy(p[n:], l.buf)
+			l.buf = l.buf[c:]
+			n += c
+			continue
+		}
+		if n > 0 && l.r.Buffered() == 0 {
+			break
+		}
+		b, err := l.r.ReadByte()
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+		if b < utf8.RuneSelf {
+			p[n] = b
+			n++
+			continue
+		}
+		tmp := make([]byte, 2)
+		l.buf = tmp[:utf8.EncodeRune(tmp, rune(b))]
+	}
+	return n, nil
+}
@@ -0,0 +1,163 @@
+package encoding
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const xmlEntity = `<?xml version="1.0" encoding="UTF-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">
+	<soap:Body>
+		<user id="42" active="true">
+			<name>John</name>
+			<email type="work">john@example.tld</email>
+			<role>admin</role>
+			<role>editor</role>
+		</user>
+	</soap:Body>
+</soap:Envelope>`
+
+func TestNewXMLDecoder_map(t *testing.T) {
+	decoder := NewXMLDecoder(false)
+	var result map[string]interface{}
+	if err := decoder(strings.NewReader(xmlEntity), &result); err != nil {
+		t.Error("Unexpected error:", err.Error())
+		return
+	}
+	expected := map[string]interface{}{
+		"Body": map[string]interface{}{
+			"user": map[string]interface{}{
+				"@id":     "42",
+				"@active": "true",
+				"name":    "John",
+				"email": map[string]interface{}{
+					"@type": "work",
+					"#text": "john@example.tld",
+				},
+				"role": []interface{}{"admin", "editor"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("unexpected result: %v", result)
+	}
+}
+
+func TestNewXMLDecoderFactory_options(t *testing.T) {
+	decoder := NewXMLDecoderFactory(XMLOptions{
+		TextKey:          "value",
+		IgnoreAttributes: true,
+		AlwaysArray:      true,
+		KeepRoot:         true,
+	})(false)
+	var result map[string]interface{}
+	if err := decoder(strings.NewReader(`<user id="1"><name>John</name><email type="work">john@example.tld</email></user>`), &result); err != nil {
+		t.Error("Unexpected error:", err.Error())
+		return
+	}
+	expected := map[string]interface{}{
+		"user": map[string]interface{}{
+			"name":  []interface{}{"John"},
+			"email": []interface{}{"john@example.tld"},
+		},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("unexpected result: %v", result)
+	}
+}
+
+func TestNewXMLDecoder_text(t *testing.T) {
+	decoder := NewXMLDecoder(false)
+	var result map[string]interface{}
+	if err := decoder(strings.NewReader(`<message> hello </message>`), &result); err != nil {
+		t.Error("Unexpected error:", err.Error())
+		return
+	}
+	if !reflect.DeepEqual(result, map[string]interface{}{"#text": "hello"}) {
+		t.Errorf("unexpected result: %v", result)
+	}
+}
+
+func TestNewXMLDecoder_collection(t *testing.T) {
+	decoder := NewXMLDecoder(true)
+	var result map[string]interface{}
+	if err := decoder(strings.NewReader(`<users><user id="1">John</user><user id="2">Jane</user><admin>Root</admin></users>`), &result); err != nil {
+		t.Error("Unexpected error:", err.Error())
+		return
+	}
+	expected := map[string]interface{}{
+		"collection": []interface{}{
+			map[string]interface{}{"@id": "1", "#text": "John"},
+			map[string]interface{}{"@id": "2", "#text": "Jane"},
+			"Root",
+		},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("unexpected result: %v", result)
+	}
+}
+
+func TestNewXMLDecoder_latin1(t *testing.T) {
+	decoder := NewXMLDecoder(false)
+	var result map[string]interface{}
+	doc := "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><city><name>M\xfcnchen</name></city>"
+	if err := decoder(strings.NewReader(doc), &result); err != nil {
+		t.Error("Unexpected error:", err.Error())
+		return
+	}
+	if result["name"] != "München" {
+		t.Errorf("unexpected result: %v", result)
+	}
+}
+
+func TestNewXMLDecoder_ko(t *testing.T) {
+	for _, isCollection := range []bool{true, false} {
+		for _, doc := range []string{
+			`<user><name>John</user>`,
+			`<user><name>John</name>`,
+			``,
+			`<?xml version="1.0" encoding="EBCDIC"?><user/>`,
+		} {
+			var result map[string]interface{}
+			if err := NewXMLDecoder(isCollection)(strings.NewReader(doc), &result); err == nil {
+				t.Errorf("%q: error expected", doc)
+			}
+		}
+	}
+}
+
+func TestGetWithConfig_xml(t *testing.T) {
+	decoder := GetWithConfig(XML, map[string]interface{}{
+		Namespace: map[string]interface{}{
+			XML: map[string]interface{}{
+				"attribute_prefix": "-",
+				"arrays":           "always",
+				"keep_root":        true,
+			},
+		},
+	})(false)
+	var result map[string]interface{}
+	if err := decoder(strings.NewReader(`<user id="1"><name>John</name></user>`), &result); err != nil {
+		t.Error("Unexpected error:", err.Error())
+		return
+	}
+	expected := map[string]interface{}{
+		"user": map[string]interface{}{
+			"-id":  "1",
+			"name": []interface{}{"John"},
+		},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("unexpected result: %v", result)
+	}
+
+	decoder = GetWithConfig(XML, nil)(false)
+	if err := decoder(strings.NewReader(`<user id="1"><name>John</name></user>`), &result); err != nil {
+		t.Error("Unexpected error:", err.Error())
+		return
+	}
+	if !reflect.DeepEqual(result, map[string]interface{}{"@id": "1", "name": "John"}) {
+		t.Errorf("unexpected result: %v", result)
+	}
+}
@@ -0,0 +1,63 @@
+package encoding
+
+import (
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v2"
+)
+
+// YAML is the key for the yaml encoding
+const YAML = "yaml"
+
+// NewYAMLDecoder return the right YAML decoder
+func NewYAMLDecoder(isCollection bool) func(io.Reader, *map[string]interface{}) error {
+	if isCollection {
+		return YAMLCollectionDecoder
+	}
+	return YAMLDecoder
+}
+
+// YAMLDecoder implements the Decoder interface
+func YAMLDecoder(r io.Reader, v *map[string]interface{}) error {
+	var data map[interface{}]interface{}
+	if err := yaml.NewDecoder(r).Decode(&data); err != nil {
+		return err
+	}
+	*(v) = sanitizeYAMLMap(data)
+	return nil
+}
+
+// YAMLCollectionDecoder implements the Decoder interface over a collection
+func YAMLCollectionDecoder(r io.Reader, v *map[string]interface{}) error {
+	var collection []interface{}
+	if err := yaml.NewDecoder(r).Decode(&collection); err != nil {
+		return err
+	}
+	*(v) = map[string]interface{}{"collection": SanitizeYAML(collection)}
+	return nil
+}
+
+// SanitizeYAML converts the map[interface{}]interface{} values decoded by the yaml package into
+// map[string]interface{}, so they can be processed as the decoded JSON documents
+func SanitizeYAML(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		return sanitizeYAMLMap(t)
+	case []interface{}:
+		res := make([]interface{}, len(t))
+		for i, e := range t {
+			res[i] = SanitizeYAML(e)
+		}
+		return res
+	}
+	return v
+}
+
+func sanitizeYAMLMap(m map[interface{}]interface{}) map[string]interface{} {
+	res := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		res[fmt.Sprintf("%v", k)] = SanitizeYAML(v)
+	}
+	return res
+}
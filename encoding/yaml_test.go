@@ -0,0 +1,68 @@
+package encoding
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewYAMLDecoder_map(t *testing.T) {
+	decoder := NewYAMLDecoder(false)
+	original := strings.NewReader(`
+foo: bar
+supu: false
+tupu: 4.20
+nested:
+  1: one
+  list:
+    - a: b
+    - c
+`)
+	var result map[string]interface{}
+	if err := decoder(original, &result); err != nil {
+		t.Error("Unexpected error:", err.Error())
+		return
+	}
+	expected := map[string]interface{}{
+		"foo":  "bar",
+		"supu": false,
+		"tupu": 4.20,
+		"nested": map[string]interface{}{
+			"1": "one",
+			"list": []interface{}{
+				map[string]interface{}{"a": "b"},
+				"c",
+			},
+		},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("unexpected result: %v", result)
+	}
+}
+
+func TestNewYAMLDecoder_collection(t *testing.T) {
+	decoder := NewYAMLDecoder(true)
+	original := strings.NewReader("- foo\n- bar: 42\n")
+	var result map[string]interface{}
+	if err := decoder(original, &result); err != nil {
+		t.Error("Unexpected error:", err.Error())
+		return
+	}
+	expected := map[string]interface{}{
+		"collection": []interface{}{"foo", map[string]interface{}{"bar": 42}},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("unexpected result: %v", result)
+	}
+}
+
+func TestNewYAMLDecoder_ko(t *testing.T) {
+	for _, isCollection := range []bool{true, false} {
+		for _, doc := range []string{"foo: [bar", "", "3"} {
+			var result map[string]interface{}
+			if err := NewYAMLDecoder(isCollection)(strings.NewReader(doc), &result); err == nil {
+				t.Errorf("%q: error expected", doc)
+			}
+		}
+	}
+}
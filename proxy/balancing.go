@@ -3,6 +3,7 @@ package proxy
 import (
 	"context"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/vm-affekt/krakend/config"
@@ -33,16 +34,86 @@ func NewRandomLoadBalancedMiddlewareWithSubscriber(subscriber sd.Subscriber) Mid
 	return newLoadBalancedMiddleware(sd.NewRandomLB(subscriber, time.Now().UnixNano()))
 }
 
+const (
+	loadBalancerKey = "load_balancer"
+
+	roundRobinStrategy         = "round_robin"
+	randomStrategy             = "random"
+	weightedRoundRobinStrategy = "weighted_round_robin"
+	leastConnectionsStrategy   = "least_connections"
+	consistentHashStrategy     = "consistent_hash"
+)
+
+// NewLoadBalancedMiddlewareWithSubscriber creates proxy middleware adding the balancer defined in
+// the extra config of the backend over the received subscriber. The available strategies are
+// round_robin (default), random, weighted_round_robin, least_connections and consistent_hash. The
+// consistent hashing uses the request attribute declared as hash_key (see sd.NewHashKeyFunc).
+//
+// If the backend defines a health check, the subscriber is decorated, so the balancer only sees the
+// healthy hosts (see NewHealthCheckSubscriber).
+//
+//	"github.com/vm-affekt/krakend/proxy": {
+//		"load_balancer": {
+//			"strategy": "consistent_hash",
+//			"hash_key": "header:X-User-Id",
+//			"replicas": 100
+//		}
+//	}
+func NewLoadBalancedMiddlewareWithSubscriber(remote *config.Backend, subscriber sd.Subscriber) Middleware {
+	return NewLoadBalancedMiddlewareWithContext(context.Background(), remote, subscriber)
+}
+
+// NewLoadBalancedMiddlewareWithContext is like NewLoadBalancedMiddlewareWithSubscriber, but the
+// health checks of the backend stop when the received context is done
+func NewLoadBalancedMiddlewareWithContext(ctx context.Context, remote *config.Backend, subscriber sd.Subscriber) Middleware {
+	subscriber = NewHealthCheckSubscriberWithContext(ctx, remote, subscriber)
+	reporter, _ := subscriber.(sd.HealthReporter)
+	return newReportingLoadBalancedMiddleware(newBalancer(remote, subscriber), reporter)
+}
+
+func newBalancer(remote *config.Backend, subscriber sd.Subscriber) sd.Balancer {
+	tmp, ok := getNamespacedConfig(remote.ExtraConfig, loadBalancerKey)
+	if !ok {
+		return sd.NewRoundRobinLB(subscriber)
+	}
+	switch getString(tmp, "strategy", roundRobinStrategy) {
+	case randomStrategy:
+		return sd.NewRandomLB(subscriber, time.Now().UnixNano())
+	case weightedRoundRobinStrategy:
+		return sd.NewWeightedRoundRobinLB(subscriber)
+	case leastConnectionsStrategy:
+		return sd.NewLeastConnectionsLB(subscriber)
+	case consistentHashStrategy:
+		key := sd.NewHashKeyFunc(getString(tmp, "hash_key", ""))
+		return sd.NewConsistentHashLB(subscriber, key, getInt(tmp, "replicas", sd.DefaultHashReplicas))
+	}
+	return sd.NewRoundRobinLB(subscriber)
+}
+
 func newLoadBalancedMiddleware(lb sd.Balancer) Middleware {
+	return newReportingLoadBalancedMiddleware(lb, nil)
+}
+
+// newReportingLoadBalancedMiddleware creates a load balanced middleware notifying the result of
+// every request to the reporter, if any
+func newReportingLoadBalancedMiddleware(lb sd.Balancer, reporter sd.HealthReporter) Middleware {
+	releaser, tracksHosts := lb.(sd.HostReleaser)
 	return func(next ...Proxy) Proxy {
 		if len(next) > 1 {
 			panic(ErrTooManyProxies)
 		}
 		return func(ctx context.Context, request *Request) (*Response, error) {
-			host, err := lb.Host()
+			host, err := pickHost(ctx, lb, &sd.Request{
+				Path:    request.Path,
+				Params:  request.Params,
+				Headers: request.Headers,
+			})
 			if err != nil {
 				return nil, err
 			}
+			if tracksHosts {
+				defer releaser.Release(host)
+			}
 			r := request.Clone()
 
 			rawURL := []byte{}
@@ -56,7 +127,65 @@ func newLoadBalancedMiddleware(lb sd.Balancer) Middleware {
 				r.URL.RawQuery += "&" + r.Query.Encode()
 			}
 
-			return next[0](ctx, &r)
+			if reporter == nil || ctx.Err() != nil {
+				return next[0](ctx, &r)
+			}
+			resp, err := next[0](ctx, &r)
+			if isHostFailure(ctx, resp, err) {
+				reporter.ReportFailure(host)
+			} else if ctx.Err() == nil {
+				reporter.ReportSuccess(host)
+			}
+			return resp, err
+		}
+	}
+}
+
+// hostFailoverAttempts is the max number of times the balancer is asked for a host not used
+// by a previous attempt of the same request
+const hostFailoverAttempts = 5
+
+type hostTrackerKey struct{}
+
+// hostTracker keeps the set of hosts already used by the attempts of a single request
+type hostTracker struct {
+	mu    sync.Mutex
+	tried map[string]struct{}
+}
+
+// withHostTracker returns a context making the load balanced middlewares avoid the hosts
+// already used by previous attempts executed with the same context
+func withHostTracker(ctx context.Context) context.Context {
+	return context.WithValue(ctx, hostTrackerKey{}, &hostTracker{tried: map[string]struct{}{}})
+}
+
+func pickHost(ctx context.Context, lb sd.Balancer, request *sd.Request) (string, error) {
+	t, ok := ctx.Value(hostTrackerKey{}).(*hostTracker)
+	if !ok {
+		return lb.Host(request)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	request.Excluded = t.tried
+	host, err := lb.Host(request)
+	if err != nil {
+		return host, err
+	}
+	for i := 0; i < hostFailoverAttempts; i++ {
+		if _, used := t.tried[host]; !used {
+			break
+		}
+		h, err := lb.Host(request)
+		if err != nil {
+			break
+		}
+		if releaser, ok := lb.(sd.HostReleaser); ok {
+			releaser.Release(host)
 		}
+		host = h
 	}
+	t.tried[host] = struct{}{}
+	return host, nil
 }
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/vm-affekt/krakend/config"
+	"github.com/vm-affekt/krakend/sd"
 	"github.com/vm-affekt/krakend/sd/dnssrv"
 )
 
@@ -130,12 +131,68 @@ func TestNewRoundRobinLoadBalancedMiddleware_DNSSRV(t *testing.T) {
 	dnssrv.DefaultLookup = defaultLookup
 }
 
+func TestNewLoadBalancedMiddlewareWithSubscriber_consistentHash(t *testing.T) {
+	backend := &config.Backend{
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				loadBalancerKey: map[string]interface{}{
+					"strategy": "consistent_hash",
+					"hash_key": "header:X-User-Id",
+				},
+			},
+		},
+	}
+	subscriber := sd.FixedSubscriber{"http://a", "http://b", "http://c"}
+	hosts := map[string]string{}
+	p := NewLoadBalancedMiddlewareWithSubscriber(backend, subscriber)(func(_ context.Context, r *Request) (*Response, error) {
+		hosts[r.Headers["X-User-Id"][0]] = r.URL.Host
+		return &Response{IsComplete: true}, nil
+	})
+	for i := 0; i < 3; i++ {
+		for _, user := range []string{"1", "2", "3", "4", "5"} {
+			request := &Request{Path: "/", Headers: map[string][]string{"X-User-Id": {user}}}
+			before, seen := hosts[user]
+			if _, err := p(context.Background(), request); err != nil {
+				t.Errorf("unexpected error: %s", err.Error())
+				return
+			}
+			if seen && before != hosts[user] {
+				t.Errorf("user %s sent to %s and %s", user, before, hosts[user])
+			}
+		}
+	}
+}
+
+func TestNewLoadBalancedMiddleware_releasesHosts(t *testing.T) {
+	lb := &releasingBalancer{}
+	p := newLoadBalancedMiddleware(lb)(func(_ context.Context, _ *Request) (*Response, error) {
+		if lb.released != 0 {
+			t.Error("the host has been released before the end of the request")
+		}
+		return &Response{IsComplete: true}, nil
+	})
+	if _, err := p(context.Background(), &Request{Path: "/"}); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if lb.released != 1 {
+		t.Errorf("unexpected number of released hosts: %d", lb.released)
+	}
+}
+
+type releasingBalancer struct {
+	released int
+}
+
+func (r *releasingBalancer) Host(_ *sd.Request) (string, error) { return "http://a", nil }
+
+func (r *releasingBalancer) Release(_ string) { r.released++ }
+
 type dummyBalancer string
 
-func (d dummyBalancer) Host() (string, error) { return string(d), nil }
+func (d dummyBalancer) Host(_ *sd.Request) (string, error) { return string(d), nil }
 
 type explosiveBalancer struct {
 	Error error
 }
 
-func (e explosiveBalancer) Host() (string, error) { return "", e.Error }
+func (e explosiveBalancer) Host(_ *sd.Request) (string, error) { return "", e.Error }
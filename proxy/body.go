@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/vm-affekt/krakend/config"
+)
+
+const (
+	bodyBufferingKey = "body_buffering"
+
+	defaultBodyBufferingMaxSize = 1024 * 1024
+)
+
+// ErrRequestBodyTooLarge is the error returned when the body of the request exceeds the size
+// allowed by the body buffering middleware
+var ErrRequestBodyTooLarge error = requestBodyTooLargeError{}
+
+type requestBodyTooLargeError struct{}
+
+// Error implements the error interface
+func (requestBodyTooLargeError) Error() string { return "request body too large" }
+
+// StatusCode returns the status code to send to the client
+func (requestBodyTooLargeError) StatusCode() int { return http.StatusRequestEntityTooLarge }
+
+// NewBodyBufferingMiddleware creates a proxy middleware that reads the body of the request into
+// memory, so every call to the backends gets its own reader when the request is cloned. It is
+// required by the endpoints sending requests with a body to several backends or using concurrent
+// calls or shadow backends. Requests with a body bigger than the max size are rejected with an
+// ErrRequestBodyTooLarge. The same options in the extra config of a backend set the max size of
+// the bodies kept in memory by its middlewares replaying the requests.
+//
+//	"github.com/vm-affekt/krakend/proxy": {
+//		"body_buffering": {
+//			"max_size": 1048576
+//		}
+//	}
+func NewBodyBufferingMiddleware(endpointConfig *config.EndpointConfig) Middleware {
+	maxSize, ok := getBodyBufferingCfg(endpointConfig.ExtraConfig)
+	if !ok {
+		return EmptyMiddleware
+	}
+	return func(next ...Proxy) Proxy {
+		if len(next) > 1 {
+			panic(ErrTooManyProxies)
+		}
+		return func(ctx context.Context, request *Request) (*Response, error) {
+			r, ok, err := bufferRequestBody(request, maxSize)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				r.Body.Close()
+				return nil, ErrRequestBodyTooLarge
+			}
+			return next[0](ctx, r)
+		}
+	}
+}
+
+// IsBodyBufferingEnabled returns true if the endpoint buffers the body of the requests
+func IsBodyBufferingEnabled(endpointConfig *config.EndpointConfig) bool {
+	_, ok := getBodyBufferingCfg(endpointConfig.ExtraConfig)
+	return ok
+}
+
+func getBodyBufferingCfg(extra config.ExtraConfig) (int64, bool) {
+	v, ok := extra[Namespace]
+	if !ok {
+		return 0, false
+	}
+	e, ok := v.(map[string]interface{})
+	if !ok {
+		return 0, false
+	}
+	switch c := e[bodyBufferingKey].(type) {
+	case bool:
+		return defaultBodyBufferingMaxSize, c
+	case map[string]interface{}:
+		maxSize := int64(getInt(c, "max_size", defaultBodyBufferingMaxSize))
+		return maxSize, maxSize > 0
+	}
+	return 0, false
+}
+
+// getBodyMaxSize returns the max size of the bodies kept in memory by the middlewares replaying
+// the requests, declared with the options of the body buffering middleware
+func getBodyMaxSize(extra config.ExtraConfig) int64 {
+	if maxSize, ok := getBodyBufferingCfg(extra); ok {
+		return maxSize
+	}
+	return defaultBodyBufferingMaxSize
+}
+
+// bufferRequestBody returns a copy of the request with its body kept in memory, so it can be
+// replayed by cloning the request. The bodies already buffered are reused. If the body is bigger
+// than the max size, the returned request streams the whole original body and ok is false.
+func bufferRequestBody(request *Request, maxSize int64) (r *Request, ok bool, err error) {
+	if request.Body == nil {
+		return request, true, nil
+	}
+	if _, ok := request.Body.(*bufferedBody); ok {
+		return request, true, nil
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(request.Body, maxSize+1))
+	if err != nil {
+		request.Body.Close()
+		return nil, false, err
+	}
+	clone := request.Clone()
+	if int64(len(data)) > maxSize {
+		clone.Body = partiallyReadBody{io.MultiReader(bytes.NewReader(data), request.Body), request.Body}
+		return &clone, false, nil
+	}
+	request.Body.Close()
+	clone.Body = newBufferedBody(data)
+	return &clone, true, nil
+}
+
+// partiallyReadBody is a body whose first bytes have already been read
+type partiallyReadBody struct {
+	io.Reader
+	io.Closer
+}
+
+// bufferedBody is a request body kept in memory, so it can be read by several backend calls
+type bufferedBody struct {
+	*bytes.Reader
+	data []byte
+}
+
+func newBufferedBody(data []byte) *bufferedBody {
+	return &bufferedBody{Reader: bytes.NewReader(data), data: data}
+}
+
+// Close implements the io.Closer interface
+func (*bufferedBody) Close() error { return nil }
+
+// cloneBody returns a new reader for the buffered bodies. Other bodies are returned as they are.
+func cloneBody(body io.ReadCloser) io.ReadCloser {
+	if b, ok := body.(*bufferedBody); ok {
+		return newBufferedBody(b.data)
+	}
+	return body
+}
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vm-affekt/krakend/config"
+)
+
+func newBodyBufferingEndpoint(cfg interface{}, backends ...*config.Backend) *config.EndpointConfig {
+	return &config.EndpointConfig{
+		Method:  "POST",
+		Timeout: time.Second,
+		Backend: backends,
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{bodyBufferingKey: cfg},
+		},
+	}
+}
+
+func bodyAssertionProxy(t *testing.T, expected string, counter *uint64) Proxy {
+	return func(_ context.Context, r *Request) (*Response, error) {
+		atomic.AddUint64(counter, 1)
+		body, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			t.Error(err)
+		}
+		if string(body) != expected {
+			t.Errorf("unexpected body: '%s'", string(body))
+		}
+		return &Response{Data: map[string]interface{}{"ok": true}, IsComplete: true}, nil
+	}
+}
+
+func TestNewBodyBufferingMiddleware_disabled(t *testing.T) {
+	for _, endpoint := range []*config.EndpointConfig{
+		{},
+		newBodyBufferingEndpoint(false),
+		newBodyBufferingEndpoint("yes"),
+	} {
+		if IsBodyBufferingEnabled(endpoint) {
+			t.Error("the body buffering should be disabled")
+		}
+		body := ioutil.NopCloser(strings.NewReader("supu"))
+		NewBodyBufferingMiddleware(endpoint)(func(_ context.Context, r *Request) (*Response, error) {
+			if r.Body != body {
+				t.Error("the body should not be replaced")
+			}
+			return nil, nil
+		})(context.Background(), &Request{Body: body})
+	}
+}
+
+func TestNewBodyBufferingMiddleware_merge(t *testing.T) {
+	var counter uint64
+	endpoint := newBodyBufferingEndpoint(true, &config.Backend{}, &config.Backend{})
+	p := NewBodyBufferingMiddleware(endpoint)(NewMergeDataMiddleware(endpoint)(
+		NewRequestBuilderMiddleware(&config.Backend{})(bodyAssertionProxy(t, "supu", &counter)),
+		NewRequestBuilderMiddleware(&config.Backend{})(bodyAssertionProxy(t, "supu", &counter)),
+	))
+	resp, err := p(context.Background(), &Request{Body: ioutil.NopCloser(strings.NewReader("supu"))})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !resp.IsComplete || counter != 2 {
+		t.Errorf("unexpected result: %v %d", resp, counter)
+	}
+}
+
+func TestNewBodyBufferingMiddleware_concurrent(t *testing.T) {
+	var counter uint64
+	endpoint := newBodyBufferingEndpoint(map[string]interface{}{"max_size": 10})
+	backend := &config.Backend{ConcurrentCalls: 3, Timeout: time.Second}
+	p := NewBodyBufferingMiddleware(endpoint)(NewConcurrentMiddleware(backend)(func(ctx context.Context, r *Request) (*Response, error) {
+		resp, _ := bodyAssertionProxy(t, "supu", &counter)(ctx, r)
+		resp.IsComplete = false
+		return resp, nil
+	}))
+	if _, err := p(context.Background(), &Request{Body: ioutil.NopCloser(strings.NewReader("supu"))}); err != nil {
+		t.Error(err)
+	}
+	if counter != 3 {
+		t.Errorf("unexpected number of calls: %d", counter)
+	}
+}
+
+func TestNewBodyBufferingMiddleware_tooLarge(t *testing.T) {
+	endpoint := newBodyBufferingEndpoint(map[string]interface{}{"max_size": 3})
+	p := NewBodyBufferingMiddleware(endpoint)(explosiveProxy(t))
+	_, err := p(context.Background(), &Request{Body: ioutil.NopCloser(strings.NewReader("supu"))})
+	if err != ErrRequestBodyTooLarge {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if code := err.(requestBodyTooLargeError).StatusCode(); code != 413 {
+		t.Errorf("unexpected status code: %d", code)
+	}
+}
@@ -0,0 +1,264 @@
+package proxy
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vm-affekt/krakend/config"
+)
+
+const (
+	cacheKey = "cache"
+
+	cachePolicyPublic  = "public"
+	cachePolicyPrivate = "private"
+	cachePolicyNoStore = "no-store"
+
+	defaultCacheMaxSize = 10 * 1024 * 1024
+)
+
+// NewCacheMiddleware creates a proxy middleware that keeps the complete responses in a memory bounded
+// LRU cache for the duration of the endpoint CacheTTL (or the ttl defined in the extra config). The
+// entries are indexed by the endpoint, the params, the query string and the headers listed
+// in the vary option.
+//
+// The private policy adds all the headers received by the proxy to the key, so entries are never
+// shared between clients, and the no-store policy disables the cache.
+//
+//	"github.com/vm-affekt/krakend/proxy": {
+//		"cache": {
+//			"ttl": "30s",
+//			"max_size": 10485760,
+//			"vary": ["Accept-Language"],
+//			"policy": "public"
+//		}
+//	}
+func NewCacheMiddleware(endpointConfig *config.EndpointConfig) Middleware {
+	cfg, ok := getCacheCfg(endpointConfig)
+	if !ok || cfg.Policy == cachePolicyNoStore || cfg.TTL <= 0 || !isCacheableMethod(endpointConfig.Method) {
+		return EmptyMiddleware
+	}
+	return func(next ...Proxy) Proxy {
+		if len(next) > 1 {
+			panic(ErrTooManyProxies)
+		}
+		c := newLRUCache(cfg.MaxSize)
+		return func(ctx context.Context, request *Request) (*Response, error) {
+			key := cfg.key(endpointConfig, request)
+			if resp, ok := c.Get(key); ok {
+				return resp, nil
+			}
+
+			resp, err := next[0](ctx, request)
+			if err == nil && resp != nil && resp.IsComplete && resp.Io == nil {
+				c.Set(key, resp, cfg.TTL)
+			}
+			return resp, err
+		}
+	}
+}
+
+// CacheControlHeaderValue returns the value of the Cache-Control header to add to the complete
+// responses of the endpoint and a flag signaling if the header must be added at all
+func CacheControlHeaderValue(endpointConfig *config.EndpointConfig) (string, bool) {
+	policy := cachePolicyPublic
+	if tmp, ok := getNamespacedConfig(endpointConfig.ExtraConfig, cacheKey); ok {
+		policy = getString(tmp, "policy", policy)
+	}
+	switch policy {
+	case cachePolicyNoStore:
+		return cachePolicyNoStore, true
+	case cachePolicyPrivate:
+		return fmt.Sprintf("private, max-age=%d", int(endpointConfig.CacheTTL.Seconds())), endpointConfig.CacheTTL.Seconds() != 0
+	default:
+		return fmt.Sprintf("public, max-age=%d", int(endpointConfig.CacheTTL.Seconds())), endpointConfig.CacheTTL.Seconds() != 0
+	}
+}
+
+type cacheConfig struct {
+	TTL     time.Duration
+	MaxSize int
+	Vary    []string
+	Policy  string
+}
+
+func getCacheCfg(endpointConfig *config.EndpointConfig) (cacheConfig, bool) {
+	tmp, ok := getNamespacedConfig(endpointConfig.ExtraConfig, cacheKey)
+	if !ok {
+		return cacheConfig{}, false
+	}
+	cfg := cacheConfig{
+		TTL:     getDuration(tmp, "ttl", endpointConfig.CacheTTL),
+		MaxSize: getInt(tmp, "max_size", defaultCacheMaxSize),
+		Vary:    getStrings(tmp, "vary"),
+		Policy:  getString(tmp, "policy", cachePolicyPublic),
+	}
+	for i, h := range cfg.Vary {
+		cfg.Vary[i] = textproto.CanonicalMIMEHeaderKey(h)
+	}
+	return cfg, true
+}
+
+func (c cacheConfig) key(endpointConfig *config.EndpointConfig, request *Request) string {
+	buff := []string{endpointConfig.Method, endpointConfig.Endpoint}
+
+	params := make([]string, 0, len(request.Params))
+	for k, v := range request.Params {
+		params = append(params, k+"="+v)
+	}
+	sort.Strings(params)
+	buff = append(buff, strings.Join(params, "&"), request.Query.Encode())
+
+	headers := c.Vary
+	if c.Policy == cachePolicyPrivate {
+		headers = make([]string, 0, len(request.Headers))
+		for k := range request.Headers {
+			headers = append(headers, k)
+		}
+		sort.Strings(headers)
+	}
+	for _, h := range headers {
+		buff = append(buff, h+":"+strings.Join(getHeader(request.Headers, h), ","))
+	}
+
+	return strings.Join(buff, "\n")
+}
+
+func getHeader(headers map[string][]string, name string) []string {
+	if v, ok := headers[name]; ok {
+		return v
+	}
+	return headers[textproto.CanonicalMIMEHeaderKey(name)]
+}
+
+func isCacheableMethod(method string) bool {
+	switch strings.ToUpper(method) {
+	case "", http.MethodGet, http.MethodHead:
+		return true
+	}
+	return false
+}
+
+type cacheEntry struct {
+	key      string
+	response *Response
+	size     int
+	stored   time.Time
+	expires  time.Time
+}
+
+// lruCache is a memory bounded LRU cache of responses. The size of the entries is an
+// estimation of the memory required by their data.
+type lruCache struct {
+	mu      sync.Mutex
+	maxSize int
+	size    int
+	ll      *list.List
+	items   map[string]*list.Element
+	now     func() time.Time
+}
+
+func newLRUCache(maxSize int) *lruCache {
+	return &lruCache{
+		maxSize: maxSize,
+		ll:      list.New(),
+		items:   map[string]*list.Element{},
+		now:     time.Now,
+	}
+}
+
+// Get returns a copy of the stored response with the Age header updated
+func (c *lruCache) Get(key string) (*Response, bool) {
+	c.mu.Lock()
+	e, ok := c.items[key]
+	if !ok {
+		c.mu.Unlock()
+		return nil, false
+	}
+	entry := e.Value.(*cacheEntry)
+	now := c.now()
+	if !now.Before(entry.expires) {
+		c.remove(e)
+		c.mu.Unlock()
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	c.mu.Unlock()
+
+	resp := CloneResponse(entry.response)
+	if resp.Metadata.Headers == nil {
+		resp.Metadata.Headers = map[string][]string{}
+	}
+	resp.Metadata.Headers["Age"] = []string{strconv.Itoa(int(now.Sub(entry.stored).Seconds()))}
+	return resp, true
+}
+
+// Set stores a copy of the received response
+func (c *lruCache) Set(key string, resp *Response, ttl time.Duration) {
+	entry := &cacheEntry{
+		key:      key,
+		response: CloneResponse(resp),
+	}
+	entry.size = len(key) + estimateSize(entry.response.Data)
+	if entry.size > c.maxSize {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry.stored = c.now()
+	entry.expires = entry.stored.Add(ttl)
+
+	if e, ok := c.items[key]; ok {
+		c.remove(e)
+	}
+	c.items[key] = c.ll.PushFront(entry)
+	c.size += entry.size
+
+	for c.size > c.maxSize {
+		c.remove(c.ll.Back())
+	}
+}
+
+func (c *lruCache) remove(e *list.Element) {
+	entry := c.ll.Remove(e).(*cacheEntry)
+	delete(c.items, entry.key)
+	c.size -= entry.size
+}
+
+// estimateSize returns a rough estimation of the memory used by the received value
+func estimateSize(v interface{}) int {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		size := 48
+		for k, v := range t {
+			size += len(k) + 16 + estimateSize(v)
+		}
+		return size
+	case []interface{}:
+		size := 24
+		for _, v := range t {
+			size += 16 + estimateSize(v)
+		}
+		return size
+	case []map[string]interface{}:
+		size := 24
+		for _, v := range t {
+			size += estimateSize(v)
+		}
+		return size
+	case string:
+		return len(t) + 16
+	default:
+		return 16
+	}
+}
@@ -0,0 +1,227 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/vm-affekt/krakend/config"
+)
+
+func newCountingProxy(calls *int) Proxy {
+	return func(_ context.Context, r *Request) (*Response, error) {
+		*calls++
+		return &Response{
+			Data:       map[string]interface{}{"id": r.Params["Id"], "calls": *calls, "nested": map[string]interface{}{"a": 1}},
+			IsComplete: true,
+		}, nil
+	}
+}
+
+func TestNewCacheMiddleware_disabled(t *testing.T) {
+	for i, endpoint := range []*config.EndpointConfig{
+		{Method: "GET", CacheTTL: time.Minute},
+		{
+			Method:   "GET",
+			CacheTTL: time.Minute,
+			ExtraConfig: config.ExtraConfig{
+				Namespace: map[string]interface{}{
+					cacheKey: map[string]interface{}{"policy": "no-store"},
+				},
+			},
+		},
+		{
+			Method:   "POST",
+			CacheTTL: time.Minute,
+			ExtraConfig: config.ExtraConfig{
+				Namespace: map[string]interface{}{
+					cacheKey: map[string]interface{}{},
+				},
+			},
+		},
+	} {
+		calls := 0
+		p := NewCacheMiddleware(endpoint)(newCountingProxy(&calls))
+		p(context.Background(), &Request{})
+		p(context.Background(), &Request{})
+		if calls != 2 {
+			t.Errorf("#%d: unexpected number of calls: %d", i, calls)
+		}
+	}
+}
+
+func TestNewCacheMiddleware_ok(t *testing.T) {
+	calls := 0
+	endpoint := &config.EndpointConfig{
+		Endpoint: "/supu/{id}",
+		Method:   "GET",
+		CacheTTL: time.Minute,
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				cacheKey: map[string]interface{}{"vary": []interface{}{"accept-language"}},
+			},
+		},
+	}
+	p := NewCacheMiddleware(endpoint)(newCountingProxy(&calls))
+
+	req := func(id, lang string) *Request {
+		return &Request{
+			Params:  map[string]string{"Id": id},
+			Query:   url.Values{"a": []string{"1"}},
+			Headers: map[string][]string{"Accept-Language": {lang}, "X-Forwarded-For": {id + lang}},
+		}
+	}
+
+	first, _ := p(context.Background(), req("1", "en"))
+	first.Data["nested"].(map[string]interface{})["a"] = 42
+
+	second, err := p(context.Background(), req("1", "en"))
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if calls != 1 {
+		t.Errorf("unexpected number of calls: %d", calls)
+	}
+	if v := second.Data["nested"].(map[string]interface{})["a"]; v != 1 {
+		t.Errorf("the cached response has been modified: %v", second.Data)
+	}
+	if age, ok := second.Metadata.Headers["Age"]; !ok || age[0] != "0" {
+		t.Errorf("unexpected Age header: %v", second.Metadata.Headers)
+	}
+
+	p(context.Background(), req("2", "en"))
+	p(context.Background(), req("1", "es"))
+	if calls != 3 {
+		t.Errorf("unexpected number of calls: %d", calls)
+	}
+}
+
+func TestNewCacheMiddleware_private(t *testing.T) {
+	calls := 0
+	endpoint := &config.EndpointConfig{
+		Endpoint: "/supu/{id}",
+		Method:   "GET",
+		CacheTTL: time.Minute,
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				cacheKey: map[string]interface{}{"policy": "private"},
+			},
+		},
+	}
+	p := NewCacheMiddleware(endpoint)(newCountingProxy(&calls))
+	for _, ip := range []string{"1.1.1.1", "2.2.2.2", "1.1.1.1"} {
+		p(context.Background(), &Request{Headers: map[string][]string{"X-Forwarded-For": {ip}}})
+	}
+	if calls != 2 {
+		t.Errorf("unexpected number of calls: %d", calls)
+	}
+}
+
+func TestNewCacheMiddleware_incomplete(t *testing.T) {
+	calls := 0
+	endpoint := &config.EndpointConfig{
+		Endpoint: "/supu/{id}",
+		Method:   "GET",
+		CacheTTL: time.Minute,
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				cacheKey: map[string]interface{}{},
+			},
+		},
+	}
+	p := NewCacheMiddleware(endpoint)(func(_ context.Context, _ *Request) (*Response, error) {
+		calls++
+		return &Response{Data: map[string]interface{}{}, IsComplete: false}, nil
+	})
+	p(context.Background(), &Request{})
+	p(context.Background(), &Request{})
+	if calls != 2 {
+		t.Errorf("incomplete responses should not be cached. calls: %d", calls)
+	}
+}
+
+func TestLRUCache_expiration(t *testing.T) {
+	now := time.Now()
+	c := newLRUCache(defaultCacheMaxSize)
+	c.now = func() time.Time { return now }
+
+	c.Set("a", &Response{Data: map[string]interface{}{"a": 1}}, time.Minute)
+	now = now.Add(30 * time.Second)
+	resp, ok := c.Get("a")
+	if !ok {
+		t.Error("the entry should be present")
+		return
+	}
+	if age := resp.Metadata.Headers["Age"]; age[0] != "30" {
+		t.Errorf("unexpected age: %v", age)
+	}
+	now = now.Add(time.Minute)
+	if _, ok := c.Get("a"); ok {
+		t.Error("the entry should have expired")
+	}
+	if c.size != 0 || c.ll.Len() != 0 {
+		t.Errorf("the expired entry has not been removed: %d", c.size)
+	}
+}
+
+func TestLRUCache_eviction(t *testing.T) {
+	entrySize := len("key-0") + estimateSize(map[string]interface{}{"a": 1})
+	c := newLRUCache(3 * entrySize)
+	for i := 0; i < 4; i++ {
+		c.Set(fmt.Sprintf("key-%d", i), &Response{Data: map[string]interface{}{"a": 1}}, time.Minute)
+		if i == 2 {
+			c.Get("key-0")
+		}
+	}
+	if _, ok := c.Get("key-1"); ok {
+		t.Error("the least recently used entry should have been evicted")
+	}
+	for _, k := range []string{"key-0", "key-2", "key-3"} {
+		if _, ok := c.Get(k); !ok {
+			t.Errorf("the entry %s should be present", k)
+		}
+	}
+}
+
+func TestCacheControlHeaderValue(t *testing.T) {
+	for i, tc := range []struct {
+		cfg     *config.EndpointConfig
+		value   string
+		enabled bool
+	}{
+		{cfg: &config.EndpointConfig{}, value: "public, max-age=0", enabled: false},
+		{cfg: &config.EndpointConfig{CacheTTL: time.Minute}, value: "public, max-age=60", enabled: true},
+		{
+			cfg: &config.EndpointConfig{
+				CacheTTL: time.Minute,
+				ExtraConfig: config.ExtraConfig{
+					Namespace: map[string]interface{}{
+						cacheKey: map[string]interface{}{"policy": "private"},
+					},
+				},
+			},
+			value:   "private, max-age=60",
+			enabled: true,
+		},
+		{
+			cfg: &config.EndpointConfig{
+				CacheTTL: time.Minute,
+				ExtraConfig: config.ExtraConfig{
+					Namespace: map[string]interface{}{
+						cacheKey: map[string]interface{}{"policy": "no-store"},
+					},
+				},
+			},
+			value:   "no-store",
+			enabled: true,
+		},
+	} {
+		value, enabled := CacheControlHeaderValue(tc.cfg)
+		if value != tc.value || enabled != tc.enabled {
+			t.Errorf("#%d: unexpected result: %s %v", i, value, enabled)
+		}
+	}
+}
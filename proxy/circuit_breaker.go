@@ -0,0 +1,215 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/vm-affekt/krakend/config"
+)
+
+const circuitBreakerKey = "circuit_breaker"
+
+// CircuitBreakerState is the state of a circuit breaker
+type CircuitBreakerState int
+
+const (
+	// CircuitBreakerClosed lets all the requests reach the backend
+	CircuitBreakerClosed CircuitBreakerState = iota
+	// CircuitBreakerOpen rejects all the requests without calling the backend
+	CircuitBreakerOpen
+	// CircuitBreakerHalfOpen lets a single probe reach the backend
+	CircuitBreakerHalfOpen
+)
+
+func (s CircuitBreakerState) String() string {
+	switch s {
+	case CircuitBreakerClosed:
+		return "closed"
+	case CircuitBreakerOpen:
+		return "open"
+	case CircuitBreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// ErrCircuitBreakerOpen is the error returned when the circuit breaker rejects a request
+var ErrCircuitBreakerOpen = errors.New("circuit breaker is open")
+
+// CircuitBreakerStateChange is the hook called every time a circuit breaker changes its state
+type CircuitBreakerStateChange func(name string, from, to CircuitBreakerState)
+
+// NewCircuitBreakerMiddleware creates a proxy middleware that stops sending requests to the backend
+// once the configured failure thresholds are reached. After the timeout, a single probe is allowed
+// and the breaker closes again if it succeeds. The onChange hook is optional.
+//
+//	"github.com/vm-affekt/krakend/proxy": {
+//		"circuit_breaker": {
+//			"interval": 60,
+//			"timeout": 10,
+//			"max_errors": 5,
+//			"error_ratio": 0.5,
+//			"min_requests": 20
+//		}
+//	}
+func NewCircuitBreakerMiddleware(remote *config.Backend, onChange CircuitBreakerStateChange) Middleware {
+	cfg, ok := getCircuitBreakerCfg(remote)
+	if !ok {
+		return EmptyMiddleware
+	}
+	return func(next ...Proxy) Proxy {
+		if len(next) > 1 {
+			panic(ErrTooManyProxies)
+		}
+		cb := newCircuitBreaker(cfg, onChange)
+		return func(ctx context.Context, request *Request) (*Response, error) {
+			if !cb.Allow() {
+				return nil, ErrCircuitBreakerOpen
+			}
+			result, err := next[0](ctx, request)
+			cb.Report(err == nil && result != nil)
+			return result, err
+		}
+	}
+}
+
+type circuitBreakerConfig struct {
+	Name        string
+	Interval    time.Duration
+	Timeout     time.Duration
+	MaxErrors   int
+	ErrorRatio  float64
+	MinRequests int
+}
+
+func getCircuitBreakerCfg(remote *config.Backend) (circuitBreakerConfig, bool) {
+	tmp, ok := getNamespacedConfig(remote.ExtraConfig, circuitBreakerKey)
+	if !ok {
+		return circuitBreakerConfig{}, false
+	}
+	cfg := circuitBreakerConfig{
+		Name:        getString(tmp, "name", remote.URLPattern),
+		Interval:    getDuration(tmp, "interval", 60*time.Second),
+		Timeout:     getDuration(tmp, "timeout", 10*time.Second),
+		MaxErrors:   getInt(tmp, "max_errors", 5),
+		ErrorRatio:  getFloat(tmp, "error_ratio", 0),
+		MinRequests: getInt(tmp, "min_requests", 20),
+	}
+	return cfg, cfg.MaxErrors > 0 || cfg.ErrorRatio > 0
+}
+
+type circuitBreaker struct {
+	cfg      circuitBreakerConfig
+	onChange CircuitBreakerStateChange
+	now      func() time.Time
+
+	mu              sync.Mutex
+	state           CircuitBreakerState
+	consecutive     int
+	requests        int
+	failures        int
+	windowStart     time.Time
+	openedAt        time.Time
+	probeInProgress bool
+}
+
+func newCircuitBreaker(cfg circuitBreakerConfig, onChange CircuitBreakerStateChange) *circuitBreaker {
+	return &circuitBreaker{
+		cfg:         cfg,
+		onChange:    onChange,
+		now:         time.Now,
+		windowStart: time.Now(),
+	}
+}
+
+// Allow reports whether the next request can be sent to the backend
+func (cb *circuitBreaker) Allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case CircuitBreakerOpen:
+		if cb.now().Sub(cb.openedAt) < cb.cfg.Timeout {
+			return false
+		}
+		cb.setState(CircuitBreakerHalfOpen)
+		cb.probeInProgress = true
+		return true
+	case CircuitBreakerHalfOpen:
+		if cb.probeInProgress {
+			return false
+		}
+		cb.probeInProgress = true
+		return true
+	}
+
+	if cb.cfg.Interval > 0 && cb.now().Sub(cb.windowStart) > cb.cfg.Interval {
+		cb.resetCounters()
+	}
+	return true
+}
+
+// Report registers the result of a request allowed by the breaker
+func (cb *circuitBreaker) Report(success bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == CircuitBreakerHalfOpen {
+		cb.probeInProgress = false
+		if success {
+			cb.resetCounters()
+			cb.setState(CircuitBreakerClosed)
+			return
+		}
+		cb.trip()
+		return
+	}
+
+	if cb.state == CircuitBreakerOpen {
+		return
+	}
+
+	cb.requests++
+	if success {
+		cb.consecutive = 0
+		return
+	}
+	cb.consecutive++
+	cb.failures++
+
+	if cb.cfg.MaxErrors > 0 && cb.consecutive >= cb.cfg.MaxErrors {
+		cb.trip()
+		return
+	}
+	if cb.cfg.ErrorRatio > 0 && cb.requests >= cb.cfg.MinRequests &&
+		float64(cb.failures)/float64(cb.requests) >= cb.cfg.ErrorRatio {
+		cb.trip()
+	}
+}
+
+func (cb *circuitBreaker) trip() {
+	cb.openedAt = cb.now()
+	cb.resetCounters()
+	cb.setState(CircuitBreakerOpen)
+}
+
+func (cb *circuitBreaker) resetCounters() {
+	cb.consecutive = 0
+	cb.requests = 0
+	cb.failures = 0
+	cb.windowStart = cb.now()
+}
+
+func (cb *circuitBreaker) setState(state CircuitBreakerState) {
+	if cb.state == state {
+		return
+	}
+	from := cb.state
+	cb.state = state
+	if cb.onChange != nil {
+		cb.onChange(cb.cfg.Name, from, state)
+	}
+}
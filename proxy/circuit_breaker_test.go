@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vm-affekt/krakend/config"
+)
+
+func TestNewCircuitBreakerMiddleware_disabled(t *testing.T) {
+	mw := NewCircuitBreakerMiddleware(&config.Backend{}, nil)
+	expected := &Response{Data: map[string]interface{}{"supu": 42}, IsComplete: true}
+	resp, err := mw(dummyProxy(expected))(context.Background(), &Request{})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if resp != expected {
+		t.Errorf("unexpected response: %v", resp)
+	}
+}
+
+func TestNewCircuitBreakerMiddleware_multipleNext(t *testing.T) {
+	defer func() {
+		if r := recover(); r != ErrTooManyProxies {
+			t.Errorf("The code did not panic as expected: %v", r)
+		}
+	}()
+	backend := config.Backend{
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{circuitBreakerKey: map[string]interface{}{}},
+		},
+	}
+	NewCircuitBreakerMiddleware(&backend, nil)(NoopProxy, NoopProxy)
+}
+
+func TestNewCircuitBreakerMiddleware_consecutiveErrors(t *testing.T) {
+	backend := config.Backend{
+		URLPattern: "/supu",
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				circuitBreakerKey: map[string]interface{}{
+					"max_errors": 2,
+					"timeout":    "100ms",
+				},
+			},
+		},
+	}
+	changes := []string{}
+	onChange := func(name string, from, to CircuitBreakerState) {
+		if name != "/supu" {
+			t.Errorf("unexpected breaker name: %s", name)
+		}
+		changes = append(changes, from.String()+"->"+to.String())
+	}
+
+	calls := 0
+	shouldFail := true
+	backendErr := errors.New("boom")
+	p := NewCircuitBreakerMiddleware(&backend, onChange)(func(_ context.Context, _ *Request) (*Response, error) {
+		calls++
+		if shouldFail {
+			return nil, backendErr
+		}
+		return &Response{IsComplete: true}, nil
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := p(context.Background(), &Request{}); err != backendErr {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if _, err := p(context.Background(), &Request{}); err != ErrCircuitBreakerOpen {
+		t.Errorf("the breaker should be open. have: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("unexpected number of calls: %d", calls)
+	}
+
+	<-time.After(150 * time.Millisecond)
+	shouldFail = false
+
+	if _, err := p(context.Background(), &Request{}); err != nil {
+		t.Errorf("the probe should succeed. have: %v", err)
+	}
+	if _, err := p(context.Background(), &Request{}); err != nil {
+		t.Errorf("the breaker should be closed. have: %v", err)
+	}
+
+	expected := []string{"closed->open", "open->half-open", "half-open->closed"}
+	if len(changes) != len(expected) {
+		t.Errorf("unexpected state changes: %v", changes)
+		return
+	}
+	for i, c := range expected {
+		if changes[i] != c {
+			t.Errorf("unexpected state change #%d. want: %s, have: %s", i, c, changes[i])
+		}
+	}
+}
+
+func TestCircuitBreaker_halfOpenFailure(t *testing.T) {
+	now := time.Now()
+	cb := newCircuitBreaker(circuitBreakerConfig{MaxErrors: 1, Timeout: time.Second}, nil)
+	cb.now = func() time.Time { return now }
+
+	cb.Report(false)
+	if cb.Allow() {
+		t.Error("the breaker should be open")
+	}
+
+	now = now.Add(2 * time.Second)
+	if !cb.Allow() {
+		t.Error("the breaker should allow a probe")
+	}
+	if cb.Allow() {
+		t.Error("the breaker should allow just a single probe")
+	}
+	cb.Report(false)
+	if cb.state != CircuitBreakerOpen {
+		t.Errorf("unexpected state: %s", cb.state)
+	}
+	if cb.Allow() {
+		t.Error("the breaker should be open")
+	}
+}
+
+func TestCircuitBreaker_errorRatio(t *testing.T) {
+	cb := newCircuitBreaker(circuitBreakerConfig{ErrorRatio: 0.5, MinRequests: 4, Interval: time.Minute}, nil)
+
+	for _, success := range []bool{true, false, true} {
+		if !cb.Allow() {
+			t.Error("the breaker should be closed")
+		}
+		cb.Report(success)
+	}
+	if cb.state != CircuitBreakerClosed {
+		t.Errorf("unexpected state: %s", cb.state)
+	}
+	cb.Report(false)
+	if cb.state != CircuitBreakerOpen {
+		t.Errorf("unexpected state: %s", cb.state)
+	}
+}
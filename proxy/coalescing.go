@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/textproto"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/vm-affekt/krakend/config"
+	"github.com/vm-affekt/krakend/encoding"
+)
+
+const coalescingKey = "coalescing"
+
+// NewCoalescingMiddleware creates a proxy middleware that collapses the concurrent identical requests
+// into a single execution of the next proxy. Two requests are identical if they have the same params,
+// query string and headers (except the ignored ones). Every caller receives its own deep copy of the
+// shared response, so it can be safely modified. The shared execution is not canceled when the
+// caller starting it goes away: it only ends with the endpoint timeout.
+//
+// By default, the X-Forwarded-For header is ignored when comparing requests. Endpoints using the
+// no-op encoding are never coalesced, since their body can not be shared.
+//
+//	"github.com/vm-affekt/krakend/proxy": {
+//		"coalescing": {
+//			"ignored_headers": ["X-Forwarded-For"]
+//		}
+//	}
+func NewCoalescingMiddleware(endpointConfig *config.EndpointConfig) Middleware {
+	ignored, ok := getCoalescingCfg(endpointConfig.ExtraConfig)
+	if !ok || !isCacheableMethod(endpointConfig.Method) || endpointConfig.OutputEncoding == encoding.NOOP {
+		return EmptyMiddleware
+	}
+	return func(next ...Proxy) Proxy {
+		if len(next) > 1 {
+			panic(ErrTooManyProxies)
+		}
+		g := &flightGroup{calls: map[string]*flight{}}
+		return func(ctx context.Context, request *Request) (*Response, error) {
+			return g.Do(ctx, coalescingRequestKey(request, ignored), func() (*Response, error) {
+				localCtx, cancel := context.Context(newcontextWrapper(ctx)), func() {}
+				if endpointConfig.Timeout > 0 {
+					localCtx, cancel = context.WithTimeout(localCtx, endpointConfig.Timeout)
+				}
+				defer cancel()
+				return next[0](localCtx, request)
+			})
+		}
+	}
+}
+
+func getCoalescingCfg(extra config.ExtraConfig) (map[string]struct{}, bool) {
+	v, ok := extra[Namespace]
+	if !ok {
+		return nil, false
+	}
+	e, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	ignored := map[string]struct{}{"X-Forwarded-For": {}}
+	switch c := e[coalescingKey].(type) {
+	case bool:
+		return ignored, c
+	case map[string]interface{}:
+		if _, ok := c["ignored_headers"]; ok {
+			ignored = map[string]struct{}{}
+			for _, h := range getStrings(c, "ignored_headers") {
+				ignored[textproto.CanonicalMIMEHeaderKey(h)] = struct{}{}
+			}
+		}
+		return ignored, true
+	}
+	return nil, false
+}
+
+func coalescingRequestKey(request *Request, ignored map[string]struct{}) string {
+	parts := make([]string, 0, len(request.Params)+len(request.Headers)+1)
+	for k, v := range request.Params {
+		parts = append(parts, "p:"+k+"="+v)
+	}
+	for k, vs := range request.Headers {
+		if _, ok := ignored[textproto.CanonicalMIMEHeaderKey(k)]; ok {
+			continue
+		}
+		parts = append(parts, "h:"+k+"="+strings.Join(vs, ","))
+	}
+	sort.Strings(parts)
+	parts = append(parts, "q:"+request.Query.Encode())
+	return strings.Join(parts, "\n")
+}
+
+type flight struct {
+	done chan struct{}
+	resp *Response
+	err  error
+}
+
+// flightGroup tracks the in-flight executions by key
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+}
+
+// Do executes f once for all the concurrent calls sharing the same key. The received context
+// only controls how long the caller waits for the shared result, so f should not depend on it.
+// A panic in f is returned as an error to all the callers.
+func (g *flightGroup) Do(ctx context.Context, key string, f func() (*Response, error)) (*Response, error) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		select {
+		case <-c.done:
+			return CloneResponse(c.resp), c.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	c := &flight{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	g.run(key, c, f)
+
+	return CloneResponse(c.resp), c.err
+}
+
+func (g *flightGroup) run(key string, c *flight, f func() (*Response, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.resp, c.err = nil, fmt.Errorf("coalescing: panic in the shared execution: %v", r)
+		}
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+	c.resp, c.err = f()
+}
@@ -0,0 +1,205 @@
+package proxy
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vm-affekt/krakend/config"
+)
+
+func TestNewCoalescingMiddleware_disabled(t *testing.T) {
+	for i, endpoint := range []*config.EndpointConfig{
+		{Method: "GET"},
+		{Method: "GET", ExtraConfig: config.ExtraConfig{Namespace: map[string]interface{}{coalescingKey: false}}},
+		{Method: "POST", ExtraConfig: config.ExtraConfig{Namespace: map[string]interface{}{coalescingKey: true}}},
+	} {
+		var calls uint64
+		p := NewCoalescingMiddleware(endpoint)(func(_ context.Context, _ *Request) (*Response, error) {
+			atomic.AddUint64(&calls, 1)
+			return nil, nil
+		})
+		p(context.Background(), &Request{})
+		p(context.Background(), &Request{})
+		if calls != 2 {
+			t.Errorf("#%d: unexpected number of calls: %d", i, calls)
+		}
+	}
+}
+
+func TestNewCoalescingMiddleware_ok(t *testing.T) {
+	endpoint := &config.EndpointConfig{
+		Method:      "GET",
+		ExtraConfig: config.ExtraConfig{Namespace: map[string]interface{}{coalescingKey: true}},
+	}
+	var calls uint64
+	release := make(chan struct{})
+	p := NewCoalescingMiddleware(endpoint)(func(_ context.Context, _ *Request) (*Response, error) {
+		atomic.AddUint64(&calls, 1)
+		<-release
+		return &Response{
+			Data:       map[string]interface{}{"nested": map[string]interface{}{"a": 1}},
+			IsComplete: true,
+		}, nil
+	})
+
+	total := 10
+	wg := &sync.WaitGroup{}
+	responses := make([]*Response, total)
+	for i := 0; i < total; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i], _ = p(context.Background(), &Request{
+				Params:  map[string]string{"Id": "42"},
+				Query:   url.Values{"a": []string{"b"}},
+				Headers: map[string][]string{"X-Forwarded-For": {time.Now().String()}},
+			})
+		}(i)
+	}
+	<-time.After(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("unexpected number of calls: %d", calls)
+	}
+	responses[0].Data["nested"].(map[string]interface{})["a"] = 42
+	for i, r := range responses[1:] {
+		if r == nil || !r.IsComplete {
+			t.Errorf("#%d: unexpected response: %v", i, r)
+			continue
+		}
+		if v := r.Data["nested"].(map[string]interface{})["a"]; v != 1 {
+			t.Errorf("#%d: the responses are sharing their data: %v", i, r.Data)
+		}
+	}
+}
+
+func TestCoalescingRequestKey(t *testing.T) {
+	ignored := map[string]struct{}{"X-Forwarded-For": {}}
+	base := &Request{
+		Params:  map[string]string{"Id": "42"},
+		Query:   url.Values{"a": []string{"b"}},
+		Headers: map[string][]string{"X-Forwarded-For": {"1.1.1.1"}, "Accept": {"application/json"}},
+	}
+	key := coalescingRequestKey(base, ignored)
+
+	sameKey := CloneRequest(base)
+	sameKey.Headers["X-Forwarded-For"] = []string{"2.2.2.2"}
+	if coalescingRequestKey(sameKey, ignored) != key {
+		t.Error("the ignored headers should not be part of the key")
+	}
+
+	for i, r := range []*Request{
+		{Params: map[string]string{"Id": "1"}, Query: base.Query, Headers: base.Headers},
+		{Params: base.Params, Query: url.Values{"a": []string{"c"}}, Headers: base.Headers},
+		{Params: base.Params, Query: base.Query, Headers: map[string][]string{"Accept": {"text/plain"}}},
+	} {
+		if coalescingRequestKey(r, ignored) == key {
+			t.Errorf("#%d: the keys should be different", i)
+		}
+	}
+}
+
+func TestFlightGroup_waiterContext(t *testing.T) {
+	g := &flightGroup{calls: map[string]*flight{}}
+	release := make(chan struct{})
+	go g.Do(context.Background(), "key", func() (*Response, error) {
+		<-release
+		return &Response{}, nil
+	})
+	<-time.After(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := g.Do(ctx, "key", func() (*Response, error) {
+		t.Error("the function should not be executed")
+		return nil, nil
+	}); err != context.DeadlineExceeded {
+		t.Errorf("unexpected error: %v", err)
+	}
+	close(release)
+}
+
+func TestNewCoalescingMiddleware_leaderCanceled(t *testing.T) {
+	endpoint := &config.EndpointConfig{
+		Method:      "GET",
+		Timeout:     time.Second,
+		ExtraConfig: config.ExtraConfig{Namespace: map[string]interface{}{coalescingKey: true}},
+	}
+	release := make(chan struct{})
+	p := NewCoalescingMiddleware(endpoint)(func(ctx context.Context, _ *Request) (*Response, error) {
+		select {
+		case <-release:
+			return &Response{IsComplete: true}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	})
+
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	leaderErr := make(chan error)
+	go func() {
+		_, err := p(leaderCtx, &Request{})
+		leaderErr <- err
+	}()
+	<-time.After(10 * time.Millisecond)
+
+	waiter := make(chan *Response)
+	go func() {
+		resp, _ := p(context.Background(), &Request{})
+		waiter <- resp
+	}()
+	<-time.After(10 * time.Millisecond)
+
+	cancel()
+	<-time.After(10 * time.Millisecond)
+	close(release)
+
+	if resp := <-waiter; resp == nil || !resp.IsComplete {
+		t.Errorf("unexpected response: %v", resp)
+	}
+	if err := <-leaderErr; err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
+
+func TestFlightGroup_panic(t *testing.T) {
+	g := &flightGroup{calls: map[string]*flight{}}
+	release := make(chan struct{})
+	leaderErr := make(chan error)
+	go func() {
+		_, err := g.Do(context.Background(), "key", func() (*Response, error) {
+			<-release
+			panic("boom")
+		})
+		leaderErr <- err
+	}()
+	<-time.After(10 * time.Millisecond)
+
+	waiterErr := make(chan error)
+	go func() {
+		_, err := g.Do(context.Background(), "key", func() (*Response, error) { return nil, nil })
+		waiterErr <- err
+	}()
+	<-time.After(10 * time.Millisecond)
+	close(release)
+
+	for _, ch := range []chan error{leaderErr, waiterErr} {
+		select {
+		case err := <-ch:
+			if err == nil {
+				t.Error("error expected")
+			}
+		case <-time.After(time.Second):
+			t.Error("the callers are still waiting")
+		}
+	}
+	if len(g.calls) != 0 {
+		t.Errorf("unexpected calls: %v", g.calls)
+	}
+}
@@ -27,7 +27,8 @@ func NewConcurrentMiddleware(remote *config.Backend) Middleware {
 			failed := make(chan error, remote.ConcurrentCalls)
 
 			for i := 0; i < remote.ConcurrentCalls; i++ {
-				go processConcurrentCall(localCtx, next[0], request, results, failed)
+				r := request.Clone()
+				go processConcurrentCall(localCtx, next[0], &r, results, failed)
 			}
 
 			var response *Response
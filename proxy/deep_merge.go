@@ -0,0 +1,215 @@
+package proxy
+
+import (
+	"reflect"
+	"strings"
+)
+
+const (
+	deepMergeCombinerName = "deep_merge"
+	deepMergeKey          = "deep_merge"
+
+	// DeepMergeArrayReplace replaces the existing array with the new one
+	DeepMergeArrayReplace = "replace"
+	// DeepMergeArrayAppend appends the elements of the new array to the existing one
+	DeepMergeArrayAppend = "append"
+	// DeepMergeArrayUnion merges the objects sharing the same value for the configured key and
+	// appends the rest of the elements not already present
+	DeepMergeArrayUnion = "union"
+
+	// DeepMergeFirstWins keeps the existing value on conflicts
+	DeepMergeFirstWins = "first_wins"
+	// DeepMergeLastWins overwrites the existing value on conflicts
+	DeepMergeLastWins = "last_wins"
+	// DeepMergeError keeps the existing value on conflicts and reports an error
+	DeepMergeError = "error"
+)
+
+// DeepMergeConfig defines the behaviour of the deep merge combiner
+type DeepMergeConfig struct {
+	// Arrays is the strategy to apply when both responses contain an array under the same key
+	Arrays string
+	// ArrayKey is the field used to identify the objects of the arrays by the union strategy
+	ArrayKey string
+	// Conflict is the policy to apply when both responses contain incompatible values under the same key
+	Conflict string
+}
+
+// MergeConflictError is the error reported by the deep merge combiner when the conflict policy is 'error'
+type MergeConflictError struct {
+	Paths []string
+}
+
+// Error implements the error interface
+func (m MergeConflictError) Error() string {
+	return "merge conflict at: " + strings.Join(m.Paths, ", ")
+}
+
+// NewDeepMergeCombiner returns a ResponseCombiner merging the nested objects of the responses
+// recursively. Since a ResponseCombiner can not return errors, conflicts under the 'error' policy
+// just flag the response as incomplete.
+//
+// The deep_merge combiner selected through the combiner key of the proxy extra config can be
+// configured this way:
+//
+//	"github.com/vm-affekt/krakend/proxy": {
+//		"combiner": "deep_merge",
+//		"deep_merge": {
+//			"arrays": "union",
+//			"array_key": "id",
+//			"conflict": "error"
+//		}
+//	}
+//
+// Responses are merged in the order of their backends, so the first_wins and last_wins policies
+// always pick the value of the same backend.
+func NewDeepMergeCombiner(cfg DeepMergeConfig) ResponseCombiner {
+	m := newDeepMerger(cfg)
+	return func(total int, parts []*Response) *Response {
+		res, err := m.Combine(total, parts)
+		if err != nil {
+			res.IsComplete = false
+		}
+		return res
+	}
+}
+
+func getDeepMergeConfig(e map[string]interface{}) DeepMergeConfig {
+	cfg := DeepMergeConfig{}
+	if tmp, ok := e[deepMergeKey].(map[string]interface{}); ok {
+		cfg.Arrays = getString(tmp, "arrays", "")
+		cfg.ArrayKey = getString(tmp, "array_key", "")
+		cfg.Conflict = getString(tmp, "conflict", "")
+	}
+	return cfg
+}
+
+type deepMerger struct {
+	cfg DeepMergeConfig
+}
+
+func newDeepMerger(cfg DeepMergeConfig) deepMerger {
+	switch cfg.Arrays {
+	case DeepMergeArrayAppend, DeepMergeArrayUnion:
+	default:
+		cfg.Arrays = DeepMergeArrayReplace
+	}
+	switch cfg.Conflict {
+	case DeepMergeFirstWins, DeepMergeError:
+	default:
+		cfg.Conflict = DeepMergeLastWins
+	}
+	if cfg.ArrayKey == "" {
+		cfg.ArrayKey = "id"
+	}
+	return deepMerger{cfg}
+}
+
+// Combine merges the received parts into the first valid one
+func (d deepMerger) Combine(total int, parts []*Response) (*Response, error) {
+	isComplete := len(parts) == total
+	var retResponse *Response
+	conflicts := []string{}
+	for _, part := range parts {
+		if part == nil || part.Data == nil {
+			isComplete = false
+			continue
+		}
+		isComplete = isComplete && part.IsComplete
+		if retResponse == nil {
+			retResponse = part
+			continue
+		}
+		conflicts = d.mergeMaps(retResponse.Data, part.Data, "", conflicts)
+	}
+
+	if nil == retResponse {
+		// do not allow nil data in the response:
+		return &Response{Data: make(map[string]interface{}, 0), IsComplete: isComplete}, nil
+	}
+	retResponse.IsComplete = isComplete
+	if len(conflicts) > 0 {
+		return retResponse, MergeConflictError{conflicts}
+	}
+	return retResponse, nil
+}
+
+func (d deepMerger) mergeMaps(dst, src map[string]interface{}, prefix string, conflicts []string) []string {
+	for k, sv := range src {
+		dv, ok := dst[k]
+		if !ok {
+			dst[k] = sv
+			continue
+		}
+		path := prefix + k
+
+		switch s := sv.(type) {
+		case map[string]interface{}:
+			if dm, ok := dv.(map[string]interface{}); ok {
+				conflicts = d.mergeMaps(dm, s, path+".", conflicts)
+				continue
+			}
+		case []interface{}:
+			if da, ok := dv.([]interface{}); ok {
+				dst[k], conflicts = d.mergeArrays(da, s, path, conflicts)
+				continue
+			}
+		}
+
+		if reflect.DeepEqual(dv, sv) {
+			continue
+		}
+		switch d.cfg.Conflict {
+		case DeepMergeLastWins:
+			dst[k] = sv
+		case DeepMergeError:
+			conflicts = append(conflicts, path)
+		}
+	}
+	return conflicts
+}
+
+func (d deepMerger) mergeArrays(dst, src []interface{}, path string, conflicts []string) ([]interface{}, []string) {
+	switch d.cfg.Arrays {
+	case DeepMergeArrayAppend:
+		return append(dst, src...), conflicts
+	case DeepMergeArrayUnion:
+		res := dst
+		for _, sv := range src {
+			if sm, ok := sv.(map[string]interface{}); ok {
+				if id, ok := sm[d.cfg.ArrayKey]; ok {
+					if dm, found := findByKey(res, d.cfg.ArrayKey, id); found {
+						conflicts = d.mergeMaps(dm, sm, path+".", conflicts)
+						continue
+					}
+				}
+			}
+			if !containsValue(res, sv) {
+				res = append(res, sv)
+			}
+		}
+		return res, conflicts
+	default:
+		return src, conflicts
+	}
+}
+
+func findByKey(elems []interface{}, key string, id interface{}) (map[string]interface{}, bool) {
+	for _, e := range elems {
+		if m, ok := e.(map[string]interface{}); ok {
+			if v, ok := m[key]; ok && reflect.DeepEqual(v, id) {
+				return m, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func containsValue(elems []interface{}, v interface{}) bool {
+	for _, e := range elems {
+		if reflect.DeepEqual(e, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vm-affekt/krakend/config"
+)
+
+func TestDeepMerger_Combine(t *testing.T) {
+	for i, tc := range []struct {
+		cfg      DeepMergeConfig
+		expected map[string]interface{}
+		err      bool
+	}{
+		{
+			cfg: DeepMergeConfig{},
+			expected: map[string]interface{}{
+				"user":  map[string]interface{}{"id": 1, "name": "b", "email": "a@b.c", "age": 42},
+				"items": []interface{}{map[string]interface{}{"id": 2, "qty": 3}, "z"},
+			},
+		},
+		{
+			cfg: DeepMergeConfig{Arrays: DeepMergeArrayAppend, Conflict: DeepMergeFirstWins},
+			expected: map[string]interface{}{
+				"user": map[string]interface{}{"id": 1, "name": "a", "email": "a@b.c", "age": 42},
+				"items": []interface{}{
+					map[string]interface{}{"id": 1, "qty": 1},
+					map[string]interface{}{"id": 2},
+					"z",
+					map[string]interface{}{"id": 2, "qty": 3},
+					"z",
+				},
+			},
+		},
+		{
+			cfg: DeepMergeConfig{Arrays: DeepMergeArrayUnion, Conflict: DeepMergeError},
+			expected: map[string]interface{}{
+				"user": map[string]interface{}{"id": 1, "name": "a", "email": "a@b.c", "age": 42},
+				"items": []interface{}{
+					map[string]interface{}{"id": 1, "qty": 1},
+					map[string]interface{}{"id": 2, "qty": 3},
+					"z",
+				},
+			},
+			err: true,
+		},
+	} {
+		parts := []*Response{
+			{
+				IsComplete: true,
+				Data: map[string]interface{}{
+					"user": map[string]interface{}{"id": 1, "name": "a", "email": "a@b.c"},
+					"items": []interface{}{
+						map[string]interface{}{"id": 1, "qty": 1},
+						map[string]interface{}{"id": 2},
+						"z",
+					},
+				},
+			},
+			{
+				IsComplete: true,
+				Data: map[string]interface{}{
+					"user":  map[string]interface{}{"id": 1, "name": "b", "age": 42},
+					"items": []interface{}{map[string]interface{}{"id": 2, "qty": 3}, "z"},
+				},
+			},
+		}
+		res, err := newDeepMerger(tc.cfg).Combine(2, parts)
+		if tc.err {
+			if err == nil {
+				t.Errorf("#%d: expecting a conflict error", i)
+			} else if paths := err.(MergeConflictError).Paths; !reflect.DeepEqual(paths, []string{"user.name"}) {
+				t.Errorf("#%d: unexpected conflicts: %v", i, paths)
+			}
+		} else if err != nil {
+			t.Errorf("#%d: unexpected error: %s", i, err.Error())
+		}
+		if !res.IsComplete {
+			t.Errorf("#%d: the response should be complete", i)
+		}
+		if !reflect.DeepEqual(res.Data, tc.expected) {
+			t.Errorf("#%d: unexpected result: %v", i, res.Data)
+		}
+	}
+}
+
+func TestNewDeepMergeCombiner_conflict(t *testing.T) {
+	res := NewDeepMergeCombiner(DeepMergeConfig{Conflict: DeepMergeError})(2, []*Response{
+		{Data: map[string]interface{}{"a": 1}, IsComplete: true},
+		{Data: map[string]interface{}{"a": 2}, IsComplete: true},
+	})
+	if res.IsComplete {
+		t.Error("the response should be flagged as incomplete")
+	}
+	if v := res.Data["a"]; v != 1 {
+		t.Errorf("unexpected value: %v", v)
+	}
+}
+
+func TestNewMergeDataMiddleware_deepMerge(t *testing.T) {
+	backend := config.Backend{}
+	endpoint := config.EndpointConfig{
+		Backend: []*config.Backend{&backend, &backend},
+		Timeout: time.Second,
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				mergeKey: deepMergeCombinerName,
+				deepMergeKey: map[string]interface{}{
+					"conflict": "error",
+				},
+			},
+		},
+	}
+	mw := NewMergeDataMiddleware(&endpoint)
+	p := mw(
+		dummyProxy(&Response{Data: map[string]interface{}{"user": map[string]interface{}{"name": "a"}}, IsComplete: true}),
+		dummyProxy(&Response{Data: map[string]interface{}{"user": map[string]interface{}{"name": "b", "age": 1}}, IsComplete: true}),
+	)
+	out, err := p(context.Background(), &Request{})
+	if err == nil {
+		t.Error("expecting a merge error")
+	}
+	if out == nil {
+		t.Error("expecting a response")
+		return
+	}
+	if out.IsComplete {
+		t.Error("the response should be incomplete")
+	}
+	user, ok := out.Data["user"].(map[string]interface{})
+	if !ok || user["age"] != 1 || user["name"] == nil {
+		t.Errorf("unexpected response: %v", out.Data)
+	}
+}
+
+func TestNewMergeDataMiddleware_deepMergeBackendOrder(t *testing.T) {
+	backend := config.Backend{}
+	for _, policy := range []string{DeepMergeFirstWins, DeepMergeLastWins} {
+		endpoint := config.EndpointConfig{
+			Backend: []*config.Backend{&backend, &backend},
+			Timeout: time.Second,
+			ExtraConfig: config.ExtraConfig{
+				Namespace: map[string]interface{}{
+					mergeKey:     deepMergeCombinerName,
+					deepMergeKey: map[string]interface{}{"conflict": policy},
+				},
+			},
+		}
+		p := NewMergeDataMiddleware(&endpoint)(
+			delayedProxy(t, 20*time.Millisecond, &Response{Data: map[string]interface{}{"name": "a"}, IsComplete: true}),
+			dummyProxy(&Response{Data: map[string]interface{}{"name": "b"}, IsComplete: true}),
+		)
+		out, err := p(context.Background(), &Request{})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", policy, err.Error())
+			continue
+		}
+		expected := "a"
+		if policy == DeepMergeLastWins {
+			expected = "b"
+		}
+		if v := out.Data["name"]; v != expected {
+			t.Errorf("%s: unexpected value: %v", policy, v)
+		}
+	}
+}
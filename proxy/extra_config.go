@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/vm-affekt/krakend/config"
+)
+
+// getNamespacedConfig returns the map stored under the received key inside the proxy namespace
+// of the extra config
+func getNamespacedConfig(extra config.ExtraConfig, key string) (map[string]interface{}, bool) {
+	v, ok := extra[Namespace]
+	if !ok {
+		return nil, false
+	}
+	e, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	v, ok = e[key]
+	if !ok {
+		return nil, false
+	}
+	tmp, ok := v.(map[string]interface{})
+	return tmp, ok
+}
+
+func getFloat(cfg map[string]interface{}, key string, fallback float64) float64 {
+	switch v := cfg[key].(type) {
+	case float64:
+		return v
+	case float32:
+		return float64(v)
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case json.Number:
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+	}
+	return fallback
+}
+
+func getInt(cfg map[string]interface{}, key string, fallback int) int {
+	if _, ok := cfg[key]; !ok {
+		return fallback
+	}
+	return int(getFloat(cfg, key, float64(fallback)))
+}
+
+func getBool(cfg map[string]interface{}, key string, fallback bool) bool {
+	if v, ok := cfg[key].(bool); ok {
+		return v
+	}
+	return fallback
+}
+
+func getString(cfg map[string]interface{}, key string, fallback string) string {
+	if v, ok := cfg[key].(string); ok && v != "" {
+		return v
+	}
+	return fallback
+}
+
+func getStrings(cfg map[string]interface{}, key string) []string {
+	switch v := cfg[key].(type) {
+	case []string:
+		return v
+	case []interface{}:
+		res := make([]string, 0, len(v))
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				res = append(res, s)
+			}
+		}
+		return res
+	}
+	return []string{}
+}
+
+// getDuration parses the value stored under the received key. Strings are parsed with
+// time.ParseDuration and numeric values are considered seconds
+func getDuration(cfg map[string]interface{}, key string, fallback time.Duration) time.Duration {
+	switch v := cfg[key].(type) {
+	case string:
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+		return fallback
+	case time.Duration:
+		return v
+	}
+	if _, ok := cfg[key]; !ok {
+		return fallback
+	}
+	return time.Duration(getFloat(cfg, key, fallback.Seconds()) * float64(time.Second))
+}
+
+func getInts(cfg map[string]interface{}, key string) []int {
+	switch v := cfg[key].(type) {
+	case []int:
+		return v
+	case []interface{}:
+		res := make([]int, 0, len(v))
+		for i := range v {
+			res = append(res, getInt(map[string]interface{}{key: v[i]}, key, 0))
+		}
+		return res
+	}
+	return []int{}
+}
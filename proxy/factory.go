@@ -1,6 +1,9 @@
 package proxy
 
 import (
+	"context"
+	"io"
+
 	"github.com/vm-affekt/krakend/config"
 	"github.com/vm-affekt/krakend/logging"
 	"github.com/vm-affekt/krakend/sd"
@@ -40,10 +43,19 @@ func NewDefaultFactory(backendFactory BackendFactory, logger logging.Logger) Fac
 // NewDefaultFactoryWithSubscriber returns a default proxy factory with the injected proxy builder,
 // logger and subscriber factory
 func NewDefaultFactoryWithSubscriber(backendFactory BackendFactory, logger logging.Logger, sF sd.SubscriberFactory) Factory {
-	return defaultFactory{backendFactory, logger, sF}
+	return NewDefaultFactoryWithContext(context.Background(), backendFactory, logger, sF)
+}
+
+// NewDefaultFactoryWithContext returns a default proxy factory with the injected proxy builder,
+// logger and subscriber factory. The background tasks of the proxies it creates, like the health
+// checks of the backends, stop when the context is done and the subscribers implementing io.Closer
+// are closed, so it should be canceled when the proxies are discarded (i.e. after a config reload).
+func NewDefaultFactoryWithContext(ctx context.Context, backendFactory BackendFactory, logger logging.Logger, sF sd.SubscriberFactory) Factory {
+	return defaultFactory{ctx, backendFactory, logger, sF}
 }
 
 type defaultFactory struct {
+	ctx               context.Context
 	backendFactory    BackendFactory
 	logger            logging.Logger
 	subscriberFactory sd.SubscriberFactory
@@ -64,6 +76,10 @@ func (pf defaultFactory) New(cfg *config.EndpointConfig) (p Proxy, err error) {
 	}
 
 	p = NewStaticMiddleware(cfg)(p)
+	p = NewCoalescingMiddleware(cfg)(p)
+	p = NewCacheMiddleware(cfg)(p)
+	p = NewBodyBufferingMiddleware(cfg)(p)
+	p = NewRateLimitMiddleware(cfg)(p)
 	return
 }
 
@@ -82,10 +98,33 @@ func (pf defaultFactory) newSingle(cfg *config.EndpointConfig) (Proxy, error) {
 
 func (pf defaultFactory) newStack(backend *config.Backend) (p Proxy) {
 	p = pf.backendFactory(backend)
-	p = NewRoundRobinLoadBalancedMiddlewareWithSubscriber(pf.subscriberFactory(backend))(p)
-	if backend.ConcurrentCalls > 1 {
+	p = NewLoadBalancedMiddlewareWithContext(pf.ctx, backend, pf.newSubscriber(backend))(p)
+	p = NewRetryMiddleware(backend)(p)
+	p = NewCircuitBreakerMiddleware(backend, pf.logCircuitBreakerStateChange)(p)
+	if _, ok := getHedgingCfg(backend); ok {
+		p = NewHedgingMiddleware(backend)(p)
+	} else if backend.ConcurrentCalls > 1 {
 		p = NewConcurrentMiddleware(backend)(p)
 	}
+	p = NewRequestBodyMiddleware(backend)(p)
 	p = NewRequestBuilderMiddleware(backend)(p)
+	p = NewFallbackMiddleware(backend, pf.newStack)(p)
 	return
 }
+
+// newSubscriber creates the subscriber of the backend and, if it can be closed, closes it when the
+// context of the factory is done
+func (pf defaultFactory) newSubscriber(backend *config.Backend) sd.Subscriber {
+	subscriber := pf.subscriberFactory(backend)
+	if c, ok := subscriber.(io.Closer); ok && pf.ctx.Done() != nil {
+		go func() {
+			<-pf.ctx.Done()
+			c.Close()
+		}()
+	}
+	return subscriber
+}
+
+func (pf defaultFactory) logCircuitBreakerStateChange(name string, from, to CircuitBreakerState) {
+	pf.logger.Warning("circuit breaker", name, "changed its state from", from.String(), "to", to.String())
+}
@@ -140,3 +140,50 @@ func TestNewDefaultFactory_ok(t *testing.T) {
 		t.Errorf("The proxy middleware propagated an unexpected error: %v\n", response)
 	}
 }
+
+func TestNewDefaultFactoryWithContext_closeSubscribers(t *testing.T) {
+	closed := make(chan struct{}, 2)
+	sF := func(cfg *config.Backend) sd.Subscriber {
+		return closableSubscriber{sd.FixedSubscriber(cfg.Host), closed}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	factory := NewDefaultFactoryWithContext(ctx, httpProxy, logging.NoOp, sF)
+	if _, err := factory.New(&config.EndpointConfig{
+		Backend: []*config.Backend{
+			{Host: []string{"http://a"}, URLPattern: "/a"},
+			{Host: []string{"http://b"}, URLPattern: "/b"},
+		},
+	}); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	select {
+	case <-closed:
+		t.Error("subscriber closed before the cancelation")
+		return
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	cancel()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Errorf("subscriber #%d not closed", i)
+			return
+		}
+	}
+}
+
+type closableSubscriber struct {
+	sd.FixedSubscriber
+	closed chan struct{}
+}
+
+func (c closableSubscriber) Close() error {
+	c.closed <- struct{}{}
+	return nil
+}
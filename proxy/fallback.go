@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"context"
+
+	"github.com/vm-affekt/krakend/config"
+)
+
+const (
+	fallbackKey = "fallback"
+
+	// DegradedResponseHeaderName is the header added to the responses containing fallback data
+	DegradedResponseHeaderName = "X-KrakenD-Degraded"
+	// HeaderDegradedResponseValue is the value of the DegradedResponseHeaderName header
+	HeaderDegradedResponseValue = "true"
+)
+
+// NewFallbackMiddleware creates a proxy middleware that replaces the result of the backend with the
+// one of its fallback when the backend fails, times out or returns an incomplete response. The
+// fallback can be a static payload or another backend definition. The fields of the fallback
+// backend not declared in its definition are copied from the primary one, except the extra
+// config. The stack of the fallback backend is created with the received function.
+//
+// The fallback responses are never complete and they carry the DegradedResponseHeaderName header,
+// so the clients can tell when they received degraded data. If the fallback also fails, the
+// original result is returned. The fallback backend is decoded and initialized like the ones of the
+// config file (see config.NewBackend). The request bodies are kept in memory for the fallback up to
+// the max size of the body buffering options of the backend. The requests with bigger bodies are
+// not replayed against the fallback.
+//
+//	"github.com/vm-affekt/krakend/proxy": {
+//		"fallback": {
+//			"timeout": "300ms",
+//			"backend": {
+//				"host": ["http://replica.example.com"],
+//				"url_pattern": "/users/{id}"
+//			},
+//			"data": {"users": []}
+//		}
+//	}
+func NewFallbackMiddleware(remote *config.Backend, stackFactory func(*config.Backend) Proxy) Middleware {
+	tmp, ok := getNamespacedConfig(remote.ExtraConfig, fallbackKey)
+	if !ok {
+		return EmptyMiddleware
+	}
+
+	var fallback Proxy
+	if b, ok := tmp["backend"].(map[string]interface{}); ok {
+		backend, err := newFallbackBackend(remote, b)
+		if err != nil {
+			return EmptyMiddleware
+		}
+		fallback = stackFactory(backend)
+	} else if data, ok := tmp["data"].(map[string]interface{}); ok {
+		fallback = func(_ context.Context, _ *Request) (*Response, error) {
+			return &Response{Data: CloneResponseData(data), IsComplete: true}, nil
+		}
+	} else {
+		return EmptyMiddleware
+	}
+	timeout := getDuration(tmp, "timeout", 0)
+	maxSize := getBodyMaxSize(remote.ExtraConfig)
+
+	return func(next ...Proxy) Proxy {
+		if len(next) > 1 {
+			panic(ErrTooManyProxies)
+		}
+		return func(ctx context.Context, request *Request) (*Response, error) {
+			request, ok, err := bufferRequestBody(request, maxSize)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				// the body can not be replayed, so the fallback is not available
+				return next[0](ctx, request)
+			}
+			newRequest := func() *Request {
+				r := request.Clone()
+				return &r
+			}
+
+			primaryCtx, cancel := ctx, func() {}
+			if timeout > 0 {
+				primaryCtx, cancel = context.WithTimeout(ctx, timeout)
+			}
+			result, err := next[0](primaryCtx, newRequest())
+			cancel()
+			if err == nil && result != nil && result.IsComplete {
+				return result, nil
+			}
+
+			degraded, fErr := fallback(ctx, newRequest())
+			if fErr != nil || degraded == nil {
+				return result, err
+			}
+			degraded.IsComplete = false
+			markDegraded(degraded)
+			return degraded, nil
+		}
+	}
+}
+
+// isDegraded reports whether the response contains fallback data
+func isDegraded(r *Response) bool {
+	v := getHeader(r.Metadata.Headers, DegradedResponseHeaderName)
+	return len(v) > 0 && v[0] == HeaderDegradedResponseValue
+}
+
+func markDegraded(r *Response) {
+	if r.Metadata.Headers == nil {
+		r.Metadata.Headers = map[string][]string{}
+	}
+	r.Metadata.Headers[DegradedResponseHeaderName] = []string{HeaderDegradedResponseValue}
+}
+
+func newFallbackBackend(remote *config.Backend, cfg map[string]interface{}) (*config.Backend, error) {
+	b, err := config.NewBackend(remote, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if d := getDuration(cfg, "timeout", 0); d > 0 {
+		b.Timeout = d
+	}
+	return b, nil
+}
@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vm-affekt/krakend/config"
+)
+
+func newFallbackBackendConfig(cfg map[string]interface{}) *config.Backend {
+	return &config.Backend{
+		Host:       []string{"http://primary"},
+		URLPattern: "/primary",
+		Method:     "POST",
+		Group:      "users",
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{fallbackKey: cfg},
+		},
+	}
+}
+
+func TestNewFallbackMiddleware_disabled(t *testing.T) {
+	for _, remote := range []*config.Backend{
+		{},
+		newFallbackBackendConfig(map[string]interface{}{}),
+	} {
+		mw := NewFallbackMiddleware(remote, func(_ *config.Backend) Proxy {
+			t.Error("unexpected call to the stack factory")
+			return nil
+		})
+		failing := func(_ context.Context, _ *Request) (*Response, error) { return nil, errors.New("boom") }
+		if _, err := mw(failing)(context.Background(), &Request{}); err == nil {
+			t.Error("expecting an error")
+		}
+	}
+}
+
+func TestNewFallbackMiddleware_static(t *testing.T) {
+	mw := NewFallbackMiddleware(newFallbackBackendConfig(map[string]interface{}{
+		"data": map[string]interface{}{"users": []interface{}{}},
+	}), nil)
+
+	for _, next := range []Proxy{
+		func(_ context.Context, _ *Request) (*Response, error) { return nil, errors.New("boom") },
+		func(_ context.Context, _ *Request) (*Response, error) {
+			return &Response{Data: map[string]interface{}{"users": 1}, IsComplete: false}, nil
+		},
+	} {
+		resp, err := mw(next)(context.Background(), &Request{})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			continue
+		}
+		if resp.IsComplete || !isDegraded(resp) {
+			t.Errorf("the response should be flagged as degraded: %v", resp)
+		}
+		if users, ok := resp.Data["users"].([]interface{}); !ok || len(users) != 0 {
+			t.Errorf("unexpected data: %v", resp.Data)
+		}
+	}
+
+	resp, err := mw(dummyProxy(&Response{Data: map[string]interface{}{"users": 1}, IsComplete: true}))(context.Background(), &Request{})
+	if err != nil || !resp.IsComplete || isDegraded(resp) || resp.Data["users"] != 1 {
+		t.Errorf("unexpected result: %v %v", resp, err)
+	}
+}
+
+func TestNewFallbackMiddleware_backend(t *testing.T) {
+	var fallbackBackend *config.Backend
+	mw := NewFallbackMiddleware(newFallbackBackendConfig(map[string]interface{}{
+		"timeout": "10ms",
+		"backend": map[string]interface{}{
+			"host":        []interface{}{"replica:8080"},
+			"url_pattern": "/users/{id}/{resp0_user.id}",
+		},
+	}), func(b *config.Backend) Proxy {
+		fallbackBackend = b
+		return func(ctx context.Context, r *Request) (*Response, error) {
+			if ctx.Err() != nil {
+				t.Error("the fallback should not be limited by the timeout of the primary")
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			if string(body) != "payload" {
+				t.Errorf("unexpected body: %s", string(body))
+			}
+			return &Response{Data: map[string]interface{}{"source": "fallback"}, IsComplete: true}, nil
+		}
+	})
+
+	if fallbackBackend == nil {
+		t.Error("the fallback stack has not been created")
+		return
+	}
+	if fallbackBackend.URLPattern != "/users/{{.Id}}/{{.Resp0_user.id}}" {
+		t.Errorf("unexpected url pattern: %s", fallbackBackend.URLPattern)
+	}
+	if len(fallbackBackend.Host) != 1 || fallbackBackend.Host[0] != "http://replica:8080" {
+		t.Errorf("unexpected hosts: %v", fallbackBackend.Host)
+	}
+	if fallbackBackend.Method != "POST" || fallbackBackend.Group != "users" || len(fallbackBackend.ExtraConfig) != 0 {
+		t.Errorf("unexpected backend: %+v", fallbackBackend)
+	}
+
+	slow := func(ctx context.Context, r *Request) (*Response, error) {
+		ioutil.ReadAll(r.Body)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	resp, err := mw(slow)(context.Background(), &Request{Body: ioutil.NopCloser(strings.NewReader("payload"))})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if resp.IsComplete || !isDegraded(resp) || resp.Data["source"] != "fallback" {
+		t.Errorf("unexpected response: %v", resp)
+	}
+}
+
+func TestNewFallbackMiddleware_bodyTooLarge(t *testing.T) {
+	remote := newFallbackBackendConfig(map[string]interface{}{
+		"backend": map[string]interface{}{},
+	})
+	remote.ExtraConfig[Namespace].(map[string]interface{})[bodyBufferingKey] = map[string]interface{}{"max_size": 4}
+	mw := NewFallbackMiddleware(remote, func(_ *config.Backend) Proxy {
+		return func(_ context.Context, _ *Request) (*Response, error) {
+			t.Error("the fallback should not be called")
+			return nil, nil
+		}
+	})
+	expectedErr := errors.New("primary")
+	_, err := mw(func(_ context.Context, r *Request) (*Response, error) {
+		if body, _ := ioutil.ReadAll(r.Body); string(body) != "payload" {
+			t.Errorf("unexpected body: %s", string(body))
+		}
+		return nil, expectedErr
+	})(context.Background(), &Request{Body: ioutil.NopCloser(strings.NewReader("payload"))})
+	if err != expectedErr {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNewFallbackMiddleware_fallbackFailure(t *testing.T) {
+	mw := NewFallbackMiddleware(newFallbackBackendConfig(map[string]interface{}{
+		"backend": map[string]interface{}{},
+	}), func(_ *config.Backend) Proxy {
+		return func(_ context.Context, _ *Request) (*Response, error) { return nil, errors.New("fallback") }
+	})
+	expectedErr := errors.New("primary")
+	_, err := mw(func(_ context.Context, _ *Request) (*Response, error) { return nil, expectedErr })(context.Background(), &Request{})
+	if err != expectedErr {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNewMergeDataMiddleware_degraded(t *testing.T) {
+	backend := config.Backend{}
+	endpoint := config.EndpointConfig{
+		Backend: []*config.Backend{&backend, &backend},
+		Timeout: time.Second,
+	}
+	degraded := &Response{Data: map[string]interface{}{"b": 1}}
+	markDegraded(degraded)
+	p := NewMergeDataMiddleware(&endpoint)(
+		delayedProxy(t, 10*time.Millisecond, &Response{Data: map[string]interface{}{"a": 1}, IsComplete: true}),
+		dummyProxy(degraded),
+	)
+	resp, err := p(context.Background(), &Request{})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if resp.IsComplete || !isDegraded(resp) || len(resp.Data) != 2 {
+		t.Errorf("unexpected response: %v", resp)
+	}
+}
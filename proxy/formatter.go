@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"sort"
 	"strings"
 
 	"github.com/vm-affekt/krakend/config"
@@ -23,10 +24,22 @@ type entityFormatter struct {
 	Target         string
 	Prefix         string
 	PropertyFilter propertyFilter
-	Mapping        map[string]string
+	Mapping        []fieldMapping
 }
 
-// NewEntityFormatter creates an entity formatter with the received backend definition
+// fieldMapping moves the value found at the From path to the To path
+type fieldMapping struct {
+	From []string
+	To   []string
+}
+
+// NewEntityFormatter creates an entity formatter with the received backend definition.
+//
+// The fields of the whitelist, the blacklist and the mapping are dot-separated paths of any depth.
+// Arrays are traversed transparently, so the path "items.id" refers to the id field of every object
+// in the items array. The wildcard "*" matches every key of an object or every element of an array,
+// like in "items.*.secret". Mappings move the values between paths, so nested fields can be moved up
+// ("user.address.city": "city") or down ("city": "address.city").
 func NewEntityFormatter(remote *config.Backend) EntityFormatter {
 	var propertyFilter propertyFilter
 	if len(remote.Whitelist) > 0 {
@@ -34,11 +47,16 @@ func NewEntityFormatter(remote *config.Backend) EntityFormatter {
 	} else {
 		propertyFilter = newBlacklistingFilter(remote.Blacklist)
 	}
-	sanitizedMappings := make(map[string]string, len(remote.Mapping))
-	for i, m := range remote.Mapping {
-		v := strings.Split(m, ".")
-		sanitizedMappings[i] = v[0]
+	sanitizedMappings := make([]fieldMapping, 0, len(remote.Mapping))
+	for from, to := range remote.Mapping {
+		sanitizedMappings = append(sanitizedMappings, fieldMapping{
+			From: strings.Split(from, "."),
+			To:   strings.Split(to, "."),
+		})
 	}
+	sort.Slice(sanitizedMappings, func(i, j int) bool {
+		return strings.Join(sanitizedMappings[i].From, ".") < strings.Join(sanitizedMappings[j].From, ".")
+	})
 	return entityFormatter{
 		Target:         remote.Target,
 		Prefix:         remote.Group,
@@ -56,11 +74,8 @@ func (e entityFormatter) Format(entity Response) Response {
 		e.PropertyFilter(&entity)
 	}
 	if len(entity.Data) > 0 {
-		for formerKey, newKey := range e.Mapping {
-			if v, ok := entity.Data[formerKey]; ok {
-				entity.Data[newKey] = v
-				delete(entity.Data, formerKey)
-			}
+		for _, m := range e.Mapping {
+			moveField(entity.Data, m.From, m.To)
 		}
 	}
 	if e.Prefix != "" {
@@ -80,37 +95,96 @@ func extractTarget(target string, entity *Response) {
 	}
 }
 
+const pathWildcard = "*"
+
 func whitelistPrune(wlDict map[string]interface{}, inDict map[string]interface{}) bool {
 	canDelete := true
-	var deleteSibling bool
 	for k, v := range inDict {
-		deleteSibling = true
-		if subWl, ok := wlDict[k]; ok {
-			if subWlDict, okk := subWl.(map[string]interface{}); okk {
-				if subInDict, isDict := v.(map[string]interface{}); isDict && !whitelistPrune(subWlDict, subInDict) {
-					deleteSibling = false
-				}
-			} else {
-				// whitelist leaf, maintain this branch
-				deleteSibling = false
-			}
+		subWl, ok := wlDict[k]
+		if !ok {
+			subWl, ok = wlDict[pathWildcard]
 		}
-		if deleteSibling {
-			delete(inDict, k)
-		} else {
-			canDelete = false
+		if ok {
+			if pruned, keep := whitelistValue(subWl, v); keep {
+				inDict[k] = pruned
+				canDelete = false
+				continue
+			}
 		}
+		delete(inDict, k)
 	}
 	return canDelete
 }
 
-func newWhitelistingFilter(whitelist []string) propertyFilter {
-	wlDict := make(map[string]interface{})
-	for _, k := range whitelist {
-		wlFields := strings.Split(k, ".")
-		d := buildDictPath(wlDict, wlFields[:len(wlFields)-1])
-		d[wlFields[len(wlFields)-1]] = true
+// whitelistValue prunes the received value and reports if it must be kept
+func whitelistValue(wl interface{}, v interface{}) (interface{}, bool) {
+	wlDict, ok := wl.(map[string]interface{})
+	if !ok {
+		// whitelist leaf, maintain this branch
+		return v, true
+	}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return t, !whitelistPrune(wlDict, t)
+	case []interface{}:
+		elemWl := elementPaths(wlDict)
+		res := make([]interface{}, 0, len(t))
+		for _, e := range t {
+			if pruned, keep := whitelistValue(elemWl, e); keep {
+				res = append(res, pruned)
+			}
+		}
+		return res, len(res) > 0
+	}
+	return v, false
+}
+
+// elementPaths returns the paths to apply to the elements of an array. The wildcard matches every
+// element, while the rest of the paths are applied to all of them.
+func elementPaths(dict map[string]interface{}) interface{} {
+	sub, ok := dict[pathWildcard]
+	if !ok {
+		return dict
 	}
+	if len(dict) == 1 {
+		return sub
+	}
+	return mergePaths(withoutWildcard(dict), sub)
+}
+
+// mergePaths returns the union of the received path trees
+func mergePaths(a, b interface{}) interface{} {
+	aDict, okA := a.(map[string]interface{})
+	bDict, okB := b.(map[string]interface{})
+	if !okA || !okB {
+		// one of them is a leaf, so it covers the whole branch
+		return true
+	}
+	res := make(map[string]interface{}, len(aDict)+len(bDict))
+	for k, v := range aDict {
+		res[k] = v
+	}
+	for k, v := range bDict {
+		if prev, ok := res[k]; ok {
+			v = mergePaths(prev, v)
+		}
+		res[k] = v
+	}
+	return res
+}
+
+func withoutWildcard(dict map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{}, len(dict))
+	for k, v := range dict {
+		if k != pathWildcard {
+			res[k] = v
+		}
+	}
+	return res
+}
+
+func newWhitelistingFilter(whitelist []string) propertyFilter {
+	wlDict := newPathTree(whitelist)
 
 	return func(entity *Response) {
 		if whitelistPrune(wlDict, entity.Data) {
@@ -121,6 +195,30 @@ func newWhitelistingFilter(whitelist []string) propertyFilter {
 	}
 }
 
+// newPathTree builds the tree of the received dot separated paths. A path covers all the paths
+// below it, no matter the order they are declared.
+func newPathTree(paths []string) map[string]interface{} {
+	tree := map[string]interface{}{}
+	for _, path := range paths {
+		fields := strings.Split(path, ".")
+		p := tree
+		for _, f := range fields[:len(fields)-1] {
+			next, ok := p[f]
+			if !ok {
+				next = map[string]interface{}{}
+				p[f] = next
+			}
+			if p, ok = next.(map[string]interface{}); !ok {
+				break
+			}
+		}
+		if p != nil {
+			p[fields[len(fields)-1]] = true
+		}
+	}
+	return tree
+}
+
 func buildDictPath(accumulator map[string]interface{}, fields []string) map[string]interface{} {
 	ok := true
 	var c map[string]interface{}
@@ -142,40 +240,99 @@ func buildDictPath(accumulator map[string]interface{}, fields []string) map[stri
 }
 
 func newBlacklistingFilter(blacklist []string) propertyFilter {
-	bl := make(map[string][]string, len(blacklist))
-	for _, key := range blacklist {
-		keys := strings.Split(key, ".")
-		if len(keys) > 1 {
-			if sub, ok := bl[keys[0]]; ok {
-				bl[keys[0]] = append(sub, keys[1])
-			} else {
-				bl[keys[0]] = []string{keys[1]}
+	blDict := newPathTree(blacklist)
+
+	return func(entity *Response) {
+		blacklistPrune(blDict, entity.Data)
+	}
+}
+
+// blacklistPrune removes the blacklisted fields from the received value and returns it
+func blacklistPrune(blDict map[string]interface{}, v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, sub := range blDict {
+			if k != pathWildcard {
+				blacklistValue(sub, t, k)
+				continue
 			}
-		} else {
-			bl[keys[0]] = []string{}
+			for key := range t {
+				blacklistValue(sub, t, key)
+			}
+		}
+	case []interface{}:
+		elemBl, ok := elementPaths(blDict).(map[string]interface{})
+		if !ok {
+			// every element is blacklisted
+			return t[:0]
+		}
+		for i, e := range t {
+			t[i] = blacklistPrune(elemBl, e)
 		}
 	}
+	return v
+}
 
-	return func(entity *Response) {
-		for k, sub := range bl {
-			if len(sub) == 0 {
-				delete(entity.Data, k)
-			} else {
-				if tmp := blacklistFilterSub(entity.Data[k], sub); len(tmp) > 0 {
-					entity.Data[k] = tmp
-				}
+func blacklistValue(bl interface{}, inDict map[string]interface{}, key string) {
+	v, ok := inDict[key]
+	if !ok {
+		return
+	}
+	if subBl, ok := bl.(map[string]interface{}); ok {
+		inDict[key] = blacklistPrune(subBl, v)
+		return
+	}
+	delete(inDict, key)
+}
+
+// moveField moves the value stored at the from path to the to path. The common prefix of both paths
+// is traversed, so the mapping is applied to every element of the arrays found there.
+func moveField(data map[string]interface{}, from, to []string) {
+	if len(from) == 0 || len(to) == 0 {
+		return
+	}
+	if len(from) > 1 && len(to) > 1 && from[0] == to[0] {
+		moveNestedField(data[from[0]], from[1:], to[1:])
+		return
+	}
+	v, ok := extractField(data, from)
+	if !ok {
+		return
+	}
+	d := buildDictPath(data, to[:len(to)-1])
+	d[to[len(to)-1]] = v
+}
+
+func moveNestedField(v interface{}, from, to []string) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		moveField(t, from, to)
+	case []interface{}:
+		if from[0] == pathWildcard && to[0] == pathWildcard {
+			from, to = from[1:], to[1:]
+		}
+		for _, e := range t {
+			if m, ok := e.(map[string]interface{}); ok {
+				moveField(m, from, to)
 			}
 		}
 	}
 }
 
-func blacklistFilterSub(v interface{}, blacklist []string) map[string]interface{} {
-	tmp, ok := v.(map[string]interface{})
-	if !ok {
-		return map[string]interface{}{}
+// extractField removes the value stored at the received path and returns it
+func extractField(data map[string]interface{}, path []string) (interface{}, bool) {
+	p := data
+	for _, k := range path[:len(path)-1] {
+		next, ok := p[k].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		p = next
 	}
-	for _, key := range blacklist {
-		delete(tmp, key)
+	k := path[len(path)-1]
+	v, ok := p[k]
+	if ok {
+		delete(p, k)
 	}
-	return tmp
+	return v, ok
 }
@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/vm-affekt/krakend/config"
@@ -367,3 +368,115 @@ func TestEntityFormatter_altogether(t *testing.T) {
 		t.Errorf("The formatter returned an unexpected result size: %v\n", result)
 	}
 }
+
+func newNestedSample() map[string]interface{} {
+	return map[string]interface{}{
+		"id": 1,
+		"user": map[string]interface{}{
+			"name": "supu",
+			"address": map[string]interface{}{
+				"city":   "Barcelona",
+				"street": map[string]interface{}{"name": "Rambla", "number": 42},
+			},
+		},
+		"items": []interface{}{
+			map[string]interface{}{"id": 1, "secret": "a", "tags": []interface{}{map[string]interface{}{"id": "x", "hidden": true}}},
+			map[string]interface{}{"id": 2, "secret": "b"},
+			"not an object",
+		},
+	}
+}
+
+func TestEntityFormatter_deepBlacklist(t *testing.T) {
+	f := NewEntityFormatter(&config.Backend{Blacklist: []string{
+		"user.address.street.number",
+		"items.*.secret",
+		"items.tags.hidden",
+		"unknown.path.to.nothing",
+		"id.not.an.object",
+	}})
+	result := f.Format(Response{Data: newNestedSample(), IsComplete: true})
+
+	expected := newNestedSample()
+	delete(expected["user"].(map[string]interface{})["address"].(map[string]interface{})["street"].(map[string]interface{}), "number")
+	items := expected["items"].([]interface{})
+	delete(items[0].(map[string]interface{}), "secret")
+	delete(items[1].(map[string]interface{}), "secret")
+	delete(items[0].(map[string]interface{})["tags"].([]interface{})[0].(map[string]interface{}), "hidden")
+
+	if !reflect.DeepEqual(expected, result.Data) {
+		t.Errorf("unexpected result: %v", result.Data)
+	}
+}
+
+func TestEntityFormatter_blacklistWildcards(t *testing.T) {
+	for i, blacklist := range [][]string{
+		{"items.*", "user.address", "user.address.city"},
+		{"user.address.city", "items.*", "user.address"},
+	} {
+		f := NewEntityFormatter(&config.Backend{Blacklist: blacklist})
+		result := f.Format(Response{Data: newNestedSample(), IsComplete: true})
+
+		expected := map[string]interface{}{
+			"id":    1,
+			"user":  map[string]interface{}{"name": "supu"},
+			"items": []interface{}{},
+		}
+		if !reflect.DeepEqual(expected, result.Data) {
+			t.Errorf("#%d: unexpected result: %v", i, result.Data)
+		}
+	}
+}
+
+func TestEntityFormatter_deepWhitelist(t *testing.T) {
+	f := NewEntityFormatter(&config.Backend{Whitelist: []string{
+		"user.address.street.name",
+		"items.id",
+		"items.*.tags.id",
+	}})
+	result := f.Format(Response{Data: newNestedSample(), IsComplete: true})
+
+	expected := map[string]interface{}{
+		"user": map[string]interface{}{
+			"address": map[string]interface{}{
+				"street": map[string]interface{}{"name": "Rambla"},
+			},
+		},
+		"items": []interface{}{
+			map[string]interface{}{"id": 1, "tags": []interface{}{map[string]interface{}{"id": "x"}}},
+			map[string]interface{}{"id": 2},
+		},
+	}
+	if !reflect.DeepEqual(expected, result.Data) {
+		t.Errorf("unexpected result: %v", result.Data)
+	}
+}
+
+func TestEntityFormatter_deepMapping(t *testing.T) {
+	f := NewEntityFormatter(&config.Backend{Mapping: map[string]string{
+		"user.address.city": "city",
+		"id":                "meta.id",
+		"items.*.secret":    "items.*.password",
+		"user.name":         "user.full_name",
+		"unknown.field":     "known",
+	}})
+	result := f.Format(Response{Data: newNestedSample(), IsComplete: true})
+
+	expected := newNestedSample()
+	user := expected["user"].(map[string]interface{})
+	delete(user["address"].(map[string]interface{}), "city")
+	user["full_name"] = user["name"]
+	delete(user, "name")
+	expected["city"] = "Barcelona"
+	expected["meta"] = map[string]interface{}{"id": 1}
+	delete(expected, "id")
+	for _, item := range expected["items"].([]interface{})[:2] {
+		m := item.(map[string]interface{})
+		m["password"] = m["secret"]
+		delete(m, "secret")
+	}
+
+	if !reflect.DeepEqual(expected, result.Data) {
+		t.Errorf("unexpected result: %v", result.Data)
+	}
+}
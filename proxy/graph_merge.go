@@ -0,0 +1,219 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vm-affekt/krakend/config"
+)
+
+const (
+	dependsOnKey = "depends_on"
+	criticalKey  = "critical"
+)
+
+// ErrDependencyFailed is the error reported for the backends not executed because one of their
+// dependencies failed
+var ErrDependencyFailed = errors.New("dependency failed")
+
+// mergeNode is a backend of the dependency graph
+type mergeNode struct {
+	pattern  string
+	deps     []int
+	children []int
+	critical bool
+}
+
+// getMergeGraph returns the dependency graph of the backends and a flag signaling if any backend
+// declares its dependencies. The backends can only depend on the previous ones, so the graph is
+// always acyclic. Besides the declared dependencies, the backends referenced by the placeholders
+// of the url pattern are also considered dependencies. The backends are not critical by default.
+//
+//	"github.com/vm-affekt/krakend/proxy": {
+//		"depends_on": [0, 1],
+//		"critical": true
+//	}
+func getMergeGraph(backends []*config.Backend) ([]mergeNode, bool) {
+	nodes := make([]mergeNode, len(backends))
+	isGraph := false
+	for i, b := range backends {
+		nodes[i] = mergeNode{pattern: b.URLPattern}
+		deps := map[int]struct{}{}
+		if tmp, ok := b.ExtraConfig[Namespace].(map[string]interface{}); ok {
+			if _, ok := tmp[dependsOnKey]; ok {
+				isGraph = true
+				for _, d := range getInts(tmp, dependsOnKey) {
+					deps[d] = struct{}{}
+				}
+			}
+			nodes[i].critical = getBool(tmp, criticalKey, false)
+		}
+		for _, match := range reMergeKey.FindAllStringSubmatch(b.URLPattern, -1) {
+			if d, err := strconv.Atoi(match[1]); err == nil {
+				deps[d] = struct{}{}
+			}
+		}
+		for d := range deps {
+			if d < 0 || d >= i {
+				continue
+			}
+			nodes[i].deps = append(nodes[i].deps, d)
+			nodes[d].children = append(nodes[d].children, i)
+		}
+	}
+	return nodes, isGraph
+}
+
+// graphMerge executes every backend as soon as all its dependencies have been completed, so the
+// independent ones run in parallel. The failure of a backend skips its dependents, while the failure
+// of a critical one also stops scheduling the rest of the pending backends. In both cases, the
+// results of the backends already running are still collected.
+func graphMerge(nodes []mergeNode, timeout time.Duration, rc errorAwareCombiner, next ...Proxy) Proxy {
+	return func(ctx context.Context, request *Request) (*Response, error) {
+		localCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		results := make(chan mergePart, len(next))
+		run := func(i int, parts []*Response) {
+			r := CloneRequest(request)
+			resolveMergeParams(nodes[i].pattern, r.Params, parts)
+			go requestPart(localCtx, next[i], r, i, results)
+		}
+
+		parts := make([]*Response, len(next))
+		pending := make([]int, len(next))
+		done := make([]bool, len(next))
+		running := 0
+		for i, n := range nodes {
+			pending[i] = len(n.deps)
+			if pending[i] == 0 {
+				run(i, parts)
+				running++
+			}
+		}
+
+		acc := newErrorAwareMergeAccumulator(len(next), rc)
+	GraphLoop:
+		for ; running > 0; running-- {
+			var part mergePart
+			select {
+			case part = <-results:
+			case <-localCtx.Done():
+				// the backends ignoring the context can not delay the response
+				for i := range done {
+					if !done[i] && pending[i] >= 0 {
+						acc.MergeFrom(i, nil, localCtx.Err())
+					}
+				}
+				break GraphLoop
+			}
+			done[part.index] = true
+			if part.err != nil {
+				acc.MergeFrom(part.index, nil, part.err)
+				skipped := skipDependents(nodes, part.index, pending)
+				if nodes[part.index].critical {
+					skipped = append(skipped, skipPending(pending)...)
+				}
+				for _, i := range skipped {
+					acc.MergeFrom(i, nil, skippedBackendError{i})
+				}
+				continue
+			}
+			if len(nodes[part.index].children) > 0 {
+				// the accumulator may modify the responses it receives
+				parts[part.index] = CloneResponse(part.resp)
+			}
+			acc.MergeFrom(part.index, part.resp, nil)
+			for _, c := range nodes[part.index].children {
+				pending[c]--
+				if pending[c] == 0 {
+					run(c, parts)
+					running++
+				}
+			}
+		}
+
+		return acc.Result()
+	}
+}
+
+// skipDependents flags as skipped all the nodes depending on the received one, directly or not
+func skipDependents(nodes []mergeNode, index int, pending []int) []int {
+	skipped := []int{}
+	for _, c := range nodes[index].children {
+		if pending[c] < 0 {
+			continue
+		}
+		pending[c] = -1
+		skipped = append(skipped, c)
+		skipped = append(skipped, skipDependents(nodes, c, pending)...)
+	}
+	return skipped
+}
+
+// skipPending flags as skipped all the nodes waiting for their dependencies
+func skipPending(pending []int) []int {
+	skipped := []int{}
+	for i, p := range pending {
+		if p > 0 {
+			pending[i] = -1
+			skipped = append(skipped, i)
+		}
+	}
+	return skipped
+}
+
+type skippedBackendError struct {
+	index int
+}
+
+func (s skippedBackendError) Error() string {
+	return "backend " + strconv.Itoa(s.index) + ": " + ErrDependencyFailed.Error()
+}
+
+// Unwrap returns ErrDependencyFailed
+func (skippedBackendError) Unwrap() error { return ErrDependencyFailed }
+
+// resolveMergeParams adds to the params the values of the placeholders referencing the responses
+// of the previous backends. The placeholders can point to nested fields and array elements using
+// a dot separated path, like in {{.Resp0_user.addresses.0.city}}.
+func resolveMergeParams(pattern string, params map[string]string, parts []*Response) {
+	for _, match := range reMergeKey.FindAllStringSubmatch(pattern, -1) {
+		rNum, err := strconv.Atoi(match[1])
+		if err != nil || rNum >= len(parts) || parts[rNum] == nil {
+			continue
+		}
+		v, ok := lookupPath(parts[rNum].Data, strings.Split(match[2], "."))
+		if !ok {
+			continue
+		}
+		params["Resp"+match[1]+"_"+match[2]] = fmt.Sprintf("%v", v)
+	}
+}
+
+func lookupPath(data map[string]interface{}, path []string) (interface{}, bool) {
+	var v interface{} = data
+	for _, k := range path {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			next, ok := t[k]
+			if !ok {
+				return nil, false
+			}
+			v = next
+		case []interface{}:
+			i, err := strconv.Atoi(k)
+			if err != nil || i < 0 || i >= len(t) {
+				return nil, false
+			}
+			v = t[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
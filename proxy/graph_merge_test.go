@@ -0,0 +1,235 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vm-affekt/krakend/config"
+)
+
+func newGraphEndpoint(backends ...*config.Backend) *config.EndpointConfig {
+	return &config.EndpointConfig{
+		Backend: backends,
+		Timeout: time.Second,
+	}
+}
+
+func newGraphBackend(pattern string, deps []interface{}, critical bool) *config.Backend {
+	return &config.Backend{
+		URLPattern: pattern,
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				dependsOnKey: deps,
+				criticalKey:  critical,
+			},
+		},
+	}
+}
+
+func TestNewMergeDataMiddleware_graph(t *testing.T) {
+	endpoint := newGraphEndpoint(
+		newGraphBackend("/user", []interface{}{}, true),
+		newGraphBackend("/stats", []interface{}{}, true),
+		newGraphBackend("/orders/{{.Resp0_user.id}}?city={{.Resp0_user.addresses.1.city}}", []interface{}{0}, true),
+		newGraphBackend("/items/{{.Resp2_last}}", []interface{}{1}, true),
+	)
+	var calls int32
+	p := NewMergeDataMiddleware(endpoint)(
+		delayedProxy(t, 50*time.Millisecond, &Response{Data: map[string]interface{}{"user": map[string]interface{}{
+			"id":        42,
+			"addresses": []interface{}{map[string]interface{}{"city": "a"}, map[string]interface{}{"city": "b"}},
+		}}, IsComplete: true}),
+		delayedProxy(t, 50*time.Millisecond, &Response{Data: map[string]interface{}{"stats": 1}, IsComplete: true}),
+		func(_ context.Context, r *Request) (*Response, error) {
+			atomic.AddInt32(&calls, 1)
+			if r.Params["Resp0_user.id"] != "42" || r.Params["Resp0_user.addresses.1.city"] != "b" {
+				t.Errorf("unexpected params: %v", r.Params)
+			}
+			return &Response{Data: map[string]interface{}{"last": "x"}, IsComplete: true}, nil
+		},
+		func(_ context.Context, r *Request) (*Response, error) {
+			atomic.AddInt32(&calls, 1)
+			if r.Params["Resp2_last"] != "x" {
+				t.Errorf("unexpected params: %v", r.Params)
+			}
+			return &Response{Data: map[string]interface{}{"items": 3}, IsComplete: true}, nil
+		},
+	)
+
+	start := time.Now()
+	out, err := p(context.Background(), &Request{Params: map[string]string{}})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if elapsed := time.Since(start); elapsed > 90*time.Millisecond {
+		t.Errorf("the independent backends have not been executed in parallel: %s", elapsed)
+	}
+	if calls != 2 {
+		t.Errorf("unexpected number of calls: %d", calls)
+	}
+	if !out.IsComplete || len(out.Data) != 4 {
+		t.Errorf("unexpected response: %v", out)
+	}
+}
+
+func TestNewMergeDataMiddleware_graphNonCriticalFailure(t *testing.T) {
+	endpoint := newGraphEndpoint(
+		newGraphBackend("/a", []interface{}{}, false),
+		newGraphBackend("/b", []interface{}{}, true),
+		newGraphBackend("/c/{{.Resp0_id}}", []interface{}{}, true),
+		newGraphBackend("/d", []interface{}{2}, true),
+		newGraphBackend("/e", []interface{}{1}, true),
+	)
+	var calls int32
+	failing := func(_ context.Context, _ *Request) (*Response, error) { return nil, errors.New("boom") }
+	counting := func(r *Response) Proxy {
+		return func(_ context.Context, _ *Request) (*Response, error) {
+			atomic.AddInt32(&calls, 1)
+			return r, nil
+		}
+	}
+	p := NewMergeDataMiddleware(endpoint)(
+		failing,
+		counting(&Response{Data: map[string]interface{}{"b": 1}, IsComplete: true}),
+		counting(&Response{Data: map[string]interface{}{"c": 1}, IsComplete: true}),
+		counting(&Response{Data: map[string]interface{}{"d": 1}, IsComplete: true}),
+		counting(&Response{Data: map[string]interface{}{"e": 1}, IsComplete: true}),
+	)
+	out, err := p(context.Background(), &Request{})
+	if err == nil {
+		t.Error("expecting an error")
+	}
+	mErr, ok := err.(mergeError)
+	if !ok || len(mErr.errs) != 3 {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("unexpected number of calls: %d", calls)
+	}
+	if out.IsComplete || len(out.Data) != 2 || out.Data["b"] != 1 || out.Data["e"] != 1 {
+		t.Errorf("unexpected response: %v", out)
+	}
+}
+
+func TestNewMergeDataMiddleware_graphCriticalFailure(t *testing.T) {
+	endpoint := newGraphEndpoint(
+		newGraphBackend("/a", []interface{}{}, true),
+		newGraphBackend("/b", []interface{}{}, true),
+		newGraphBackend("/c", []interface{}{1}, true),
+	)
+	var calls int32
+	p := NewMergeDataMiddleware(endpoint)(
+		func(_ context.Context, _ *Request) (*Response, error) { return nil, errors.New("boom") },
+		delayedProxy(t, 50*time.Millisecond, &Response{Data: map[string]interface{}{"b": 1}, IsComplete: true}),
+		func(_ context.Context, _ *Request) (*Response, error) {
+			atomic.AddInt32(&calls, 1)
+			return &Response{Data: map[string]interface{}{"c": 1}, IsComplete: true}, nil
+		},
+	)
+	out, err := p(context.Background(), &Request{})
+	if err == nil {
+		t.Error("expecting an error")
+	}
+	if out.IsComplete || len(out.Data) != 1 || out.Data["b"] != 1 {
+		t.Errorf("the results of the running backends have been dropped: %v", out)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if calls != 0 {
+		t.Errorf("the dependent backend should not be executed: %d", calls)
+	}
+}
+
+func TestNewMergeDataMiddleware_graphDefaultsToNonCritical(t *testing.T) {
+	endpoint := newGraphEndpoint(
+		&config.Backend{URLPattern: "/a"},
+		&config.Backend{URLPattern: "/b"},
+		newGraphBackend("/c", []interface{}{1}, false),
+		&config.Backend{URLPattern: "/d/{{.Resp2_id}}"},
+	)
+	p := NewMergeDataMiddleware(endpoint)(
+		func(_ context.Context, _ *Request) (*Response, error) { return nil, errors.New("boom") },
+		delayedProxy(t, 20*time.Millisecond, &Response{Data: map[string]interface{}{"b": 1}, IsComplete: true}),
+		delayedProxy(t, 20*time.Millisecond, &Response{Data: map[string]interface{}{"id": 1}, IsComplete: true}),
+		dummyProxy(&Response{Data: map[string]interface{}{"d": 1}, IsComplete: true}),
+	)
+	out, err := p(context.Background(), &Request{Params: map[string]string{}})
+	mErr, ok := err.(mergeError)
+	if !ok || len(mErr.backends) != 1 || mErr.backends[0] != 0 {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if out.IsComplete || len(out.Data) != 3 || out.Data["b"] != 1 || out.Data["id"] != 1 || out.Data["d"] != 1 {
+		t.Errorf("unexpected response: %v", out)
+	}
+}
+
+func TestNewMergeDataMiddleware_graphTimeout(t *testing.T) {
+	endpoint := newGraphEndpoint(
+		newGraphBackend("/a", []interface{}{}, true),
+		newGraphBackend("/b", []interface{}{}, true),
+		newGraphBackend("/c", []interface{}{1}, true),
+	)
+	endpoint.Timeout = 50 * time.Millisecond
+	release := make(chan struct{})
+	defer close(release)
+	p := NewMergeDataMiddleware(endpoint)(
+		func(_ context.Context, _ *Request) (*Response, error) {
+			return &Response{Data: map[string]interface{}{"a": 1}, IsComplete: true}, nil
+		},
+		func(_ context.Context, _ *Request) (*Response, error) {
+			<-release
+			return &Response{Data: map[string]interface{}{"b": 1}, IsComplete: true}, nil
+		},
+		func(_ context.Context, _ *Request) (*Response, error) {
+			t.Error("the dependent backend should not be executed")
+			return nil, nil
+		},
+	)
+
+	start := time.Now()
+	out, err := p(context.Background(), &Request{})
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("the merge has ignored the timeout: %s", elapsed)
+	}
+	if out.IsComplete || out.Data["a"] != 1 {
+		t.Errorf("unexpected response: %v", out)
+	}
+	mErr, ok := err.(mergeError)
+	if !ok {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	if len(mErr.backends) != 2 || mErr.backends[0]+mErr.backends[1] != 3 {
+		t.Errorf("unexpected failed backends: %v", mErr.backends)
+	}
+	for _, e := range mErr.errs {
+		if e != context.DeadlineExceeded {
+			t.Errorf("unexpected error: %v", e)
+		}
+	}
+}
+
+func TestLookupPath(t *testing.T) {
+	data := map[string]interface{}{
+		"a": map[string]interface{}{"b": []interface{}{1, map[string]interface{}{"c": "d"}}},
+	}
+	for _, tc := range []struct {
+		path  []string
+		value interface{}
+		ok    bool
+	}{
+		{path: []string{"a", "b", "0"}, value: 1, ok: true},
+		{path: []string{"a", "b", "1", "c"}, value: "d", ok: true},
+		{path: []string{"a", "b", "2"}},
+		{path: []string{"a", "x"}},
+		{path: []string{"a", "b", "0", "c"}},
+	} {
+		v, ok := lookupPath(data, tc.path)
+		if v != tc.value || ok != tc.ok {
+			t.Errorf("%v: unexpected result: %v %v", tc.path, v, ok)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/vm-affekt/krakend/config"
+	"github.com/vm-affekt/krakend/sd"
+)
+
+const (
+	healthCheckKey = "health_check"
+
+	defaultHealthCheckInterval       = 10 * time.Second
+	defaultHealthCheckTimeout        = time.Second
+	defaultHealthCheckMaxFailures    = 5
+	defaultHealthCheckCoolDown       = 30 * time.Second
+	defaultHealthCheckPanicThreshold = 50
+)
+
+// NewHealthCheckSubscriber decorates the received subscriber with a sd.HealthCheckSubscriber if
+// the backend defines a health check. The hosts are actively probed if the path is defined and
+// passively ejected after max_failures consecutive network errors or 5xx responses for the
+// cool_down period. If the percentage of healthy hosts drops below the panic_threshold, all the
+// hosts are used.
+//
+// The active probes run until the process exits. Use NewHealthCheckSubscriberWithContext for binding
+// them to the lifecycle of the proxy stack.
+//
+//	"github.com/vm-affekt/krakend/proxy": {
+//		"health_check": {
+//			"path": "/__health",
+//			"interval": "10s",
+//			"timeout": "1s",
+//			"max_failures": 5,
+//			"cool_down": "30s",
+//			"panic_threshold": 50
+//		}
+//	}
+func NewHealthCheckSubscriber(remote *config.Backend, subscriber sd.Subscriber) sd.Subscriber {
+	return NewHealthCheckSubscriberWithContext(context.Background(), remote, subscriber)
+}
+
+// NewHealthCheckSubscriberWithContext is like NewHealthCheckSubscriber, but the active probes stop
+// when the received context is done
+func NewHealthCheckSubscriberWithContext(ctx context.Context, remote *config.Backend, subscriber sd.Subscriber) sd.Subscriber {
+	tmp, ok := getNamespacedConfig(remote.ExtraConfig, healthCheckKey)
+	if !ok {
+		return subscriber
+	}
+	return sd.NewHealthCheckSubscriber(ctx, subscriber, sd.HealthCheckConfig{
+		Path:           getString(tmp, "path", ""),
+		Interval:       getDuration(tmp, "interval", defaultHealthCheckInterval),
+		Timeout:        getDuration(tmp, "timeout", defaultHealthCheckTimeout),
+		MaxFailures:    getInt(tmp, "max_failures", defaultHealthCheckMaxFailures),
+		CoolDown:       getDuration(tmp, "cool_down", defaultHealthCheckCoolDown),
+		PanicThreshold: getFloat(tmp, "panic_threshold", defaultHealthCheckPanicThreshold),
+	})
+}
+
+// isHostFailure reports whether the result of a request points to an unhealthy host. The requests
+// canceled by the caller are ignored, but the ones exceeding their deadline count as failures.
+func isHostFailure(ctx context.Context, resp *Response, err error) bool {
+	switch ctx.Err() {
+	case context.Canceled:
+		return false
+	case context.DeadlineExceeded:
+		return true
+	}
+	if err != nil {
+		switch t := err.(type) {
+		case backendStatusError:
+			return t.BackendStatusCode() >= http.StatusInternalServerError
+		case responseError:
+			return t.StatusCode() >= http.StatusInternalServerError
+		}
+		return isNetworkError(err)
+	}
+	return resp != nil && resp.Metadata.StatusCode >= http.StatusInternalServerError
+}
@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vm-affekt/krakend/config"
+	"github.com/vm-affekt/krakend/logging"
+	"github.com/vm-affekt/krakend/sd"
+	"github.com/vm-affekt/krakend/transport/http/client"
+)
+
+func TestNewLoadBalancedMiddlewareWithSubscriber_healthCheck(t *testing.T) {
+	backend := &config.Backend{
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				healthCheckKey: map[string]interface{}{
+					"max_failures":    2,
+					"cool_down":       "1m",
+					"panic_threshold": 0,
+				},
+			},
+		},
+	}
+	hosts := []string{}
+	p := NewLoadBalancedMiddlewareWithSubscriber(backend, sd.FixedSubscriber{"http://a", "http://b"})(func(_ context.Context, r *Request) (*Response, error) {
+		host := r.URL.Scheme + "://" + r.URL.Host
+		hosts = append(hosts, host)
+		if host == "http://a" {
+			return nil, &url.Error{Op: "Get", URL: host, Err: errors.New("connection refused")}
+		}
+		return &Response{IsComplete: true}, nil
+	})
+
+	for i := 0; i < 4; i++ {
+		p(context.Background(), &Request{Path: "/"})
+	}
+	hosts = hosts[:0]
+	for i := 0; i < 4; i++ {
+		if _, err := p(context.Background(), &Request{Path: "/"}); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+		}
+	}
+	for _, h := range hosts {
+		if h != "http://b" {
+			t.Errorf("request sent to an ejected host: %v", hosts)
+			return
+		}
+	}
+}
+
+func TestNewDefaultFactoryWithContext_healthCheck(t *testing.T) {
+	var probes int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&probes, 1)
+	}))
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	factory := NewDefaultFactoryWithContext(ctx, httpProxy, logging.NoOp, sd.GetSubscriber)
+	if _, err := factory.New(&config.EndpointConfig{
+		Backend: []*config.Backend{
+			{
+				Host:       []string{s.URL},
+				URLPattern: "/",
+				ExtraConfig: config.ExtraConfig{
+					Namespace: map[string]interface{}{
+						healthCheckKey: map[string]interface{}{
+							"path":     "/__health",
+							"interval": "10ms",
+						},
+					},
+				},
+			},
+		},
+	}); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	for i := 0; atomic.LoadInt32(&probes) == 0; i++ {
+		if i == 100 {
+			t.Error("the host was never probed")
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+
+	total := atomic.LoadInt32(&probes)
+	time.Sleep(50 * time.Millisecond)
+	if v := atomic.LoadInt32(&probes); v != total {
+		t.Errorf("the host was probed after the cancelation: %d > %d", v, total)
+	}
+}
+
+func TestIsHostFailure(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithTimeout(context.Background(), 0)
+	defer cancelExpired()
+
+	for i, tc := range []struct {
+		ctx      context.Context
+		resp     *Response
+		err      error
+		expected bool
+	}{
+		{context.Background(), &Response{IsComplete: true}, nil, false},
+		{context.Background(), &Response{Metadata: Metadata{StatusCode: 503}}, nil, true},
+		{context.Background(), &Response{Metadata: Metadata{StatusCode: 404}}, nil, false},
+		{context.Background(), nil, &url.Error{Op: "Get", URL: "http://a", Err: errors.New("connection refused")}, true},
+		{context.Background(), nil, client.InvalidStatusCodeError{Code: 502, Status: 500}, true},
+		{context.Background(), nil, client.InvalidStatusCodeError{Code: 404, Status: 404}, false},
+		{context.Background(), nil, client.UnexpectedStatusCodeError{Code: 502}, true},
+		{context.Background(), nil, client.UnexpectedStatusCodeError{Code: 404}, false},
+		{context.Background(), nil, client.ErrInvalidStatusCode, false},
+		{canceled, nil, &url.Error{Op: "Get", URL: "http://a", Err: context.Canceled}, false},
+		{expired, nil, context.DeadlineExceeded, true},
+	} {
+		if v := isHostFailure(tc.ctx, tc.resp, tc.err); v != tc.expected {
+			t.Errorf("#%d: unexpected result: %v", i, v)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package proxy
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vm-affekt/krakend/config"
+)
+
+const (
+	hedgingKey = "hedging"
+
+	defaultHedgingDelay          = 100 * time.Millisecond
+	defaultHedgingBudget         = 10
+	defaultHedgingBudgetCapacity = 10
+	defaultHedgingWindow         = 1000
+	defaultHedgingMinSamples     = 20
+	hedgingPercentileRefresh     = 50
+)
+
+// NewHedgingMiddleware creates a proxy middleware that sends a single request to the backend and,
+// if it has not answered after a while, sends another one (a hedge) and returns the first complete
+// response. The delay before each hedge can be fixed or be the configured percentile of the latencies
+// observed by the middleware, using the fixed one while there are not enough samples. Unlike the
+// concurrent middleware, the backend only receives extra requests when it is slow.
+//
+// The number of hedges is limited by a budget, expressed as a percentage of the requests handled by
+// the middleware. The max_requests option defaults to the concurrent calls of the backend (at least 2)
+// and, by default, only the requests with an idempotent method are hedged. The request bodies are kept
+// in memory up to the max size of the body buffering options of the backend and the requests with
+// bigger bodies are not hedged.
+//
+//	"github.com/vm-affekt/krakend/proxy": {
+//		"hedging": {
+//			"delay": "100ms",
+//			"percentile": 95,
+//			"max_requests": 2,
+//			"budget": 10,
+//			"all_methods": false
+//		}
+//	}
+func NewHedgingMiddleware(remote *config.Backend) Middleware {
+	cfg, ok := getHedgingCfg(remote)
+	if !ok {
+		return EmptyMiddleware
+	}
+	maxSize := getBodyMaxSize(remote.ExtraConfig)
+	return func(next ...Proxy) Proxy {
+		if len(next) > 1 {
+			panic(ErrTooManyProxies)
+		}
+		latencies := newLatencyTracker(defaultHedgingWindow, defaultHedgingMinSamples)
+		budget := newHedgingBudget(cfg.Budget/100, defaultHedgingBudgetCapacity)
+
+		return func(ctx context.Context, request *Request) (*Response, error) {
+			budget.Deposit()
+			if !cfg.AllMethods && !isIdempotentMethod(request.Method) {
+				return next[0](ctx, request)
+			}
+
+			request, ok, err := bufferRequestBody(request, maxSize)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				// the body can not be replayed, so the request is not hedged
+				return next[0](ctx, request)
+			}
+
+			localCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			results := make(chan hedgedResult, cfg.MaxRequests)
+			send := func() {
+				r := request.Clone()
+				go func() {
+					resp, err := next[0](localCtx, &r)
+					results <- hedgedResult{resp, err}
+				}()
+			}
+
+			delay := cfg.Delay
+			if cfg.Percentile > 0 {
+				delay = latencies.Percentile(cfg.Percentile, cfg.Delay)
+			}
+			timer := time.NewTimer(delay)
+			defer timer.Stop()
+
+			// the latency is measured from the first request, so the hedges winning the race do
+			// not lower the observed percentiles
+			start := time.Now()
+			send()
+			sent, inFlight := 1, 1
+
+			var response *Response
+			for inFlight > 0 {
+				select {
+				case res := <-results:
+					inFlight--
+					if res.err == nil && res.resp != nil && res.resp.IsComplete {
+						latencies.Record(time.Since(start))
+						return res.resp, nil
+					}
+					if res.resp != nil || response == nil {
+						response, err = res.resp, res.err
+					}
+				case <-timer.C:
+					if sent < cfg.MaxRequests && budget.Withdraw() {
+						send()
+						sent++
+						inFlight++
+						timer.Reset(delay)
+					}
+				case <-ctx.Done():
+					return response, ctx.Err()
+				}
+			}
+			return response, err
+		}
+	}
+}
+
+type hedgedResult struct {
+	resp *Response
+	err  error
+}
+
+type hedgingConfig struct {
+	Delay       time.Duration
+	Percentile  float64
+	MaxRequests int
+	Budget      float64
+	AllMethods  bool
+}
+
+func getHedgingCfg(remote *config.Backend) (hedgingConfig, bool) {
+	tmp, ok := getNamespacedConfig(remote.ExtraConfig, hedgingKey)
+	if !ok {
+		return hedgingConfig{}, false
+	}
+	maxRequests := remote.ConcurrentCalls
+	if maxRequests < 2 {
+		maxRequests = 2
+	}
+	cfg := hedgingConfig{
+		Delay:       getDuration(tmp, "delay", defaultHedgingDelay),
+		Percentile:  getFloat(tmp, "percentile", 0),
+		MaxRequests: getInt(tmp, "max_requests", maxRequests),
+		Budget:      getFloat(tmp, "budget", defaultHedgingBudget),
+		AllMethods:  getBool(tmp, "all_methods", false),
+	}
+	if cfg.Percentile > 100 {
+		cfg.Percentile = 100
+	}
+	return cfg, cfg.MaxRequests > 1 && cfg.Budget > 0
+}
+
+// hedgingBudget is a token bucket refilled with a fraction of token per request. Every hedge
+// consumes a whole token. The bucket starts full.
+type hedgingBudget struct {
+	mu       sync.Mutex
+	ratio    float64
+	capacity float64
+	tokens   float64
+}
+
+func newHedgingBudget(ratio, capacity float64) *hedgingBudget {
+	return &hedgingBudget{ratio: ratio, capacity: capacity, tokens: capacity}
+}
+
+// Deposit adds the share of tokens of a new request
+func (b *hedgingBudget) Deposit() {
+	b.mu.Lock()
+	b.tokens = math.Min(b.capacity, b.tokens+b.ratio)
+	b.mu.Unlock()
+}
+
+// Withdraw consumes a token if available
+func (b *hedgingBudget) Withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// latencyTracker keeps the latencies of the last requests in a ring buffer. The sorted copy used
+// for calculating the percentiles is refreshed periodically.
+type latencyTracker struct {
+	mu         sync.Mutex
+	samples    []time.Duration
+	next       int
+	total      int
+	minSamples int
+	sorted     []time.Duration
+}
+
+func newLatencyTracker(size, minSamples int) *latencyTracker {
+	return &latencyTracker{
+		samples:    make([]time.Duration, 0, size),
+		minSamples: minSamples,
+	}
+}
+
+// Record adds a new sample
+func (l *latencyTracker) Record(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.samples) < cap(l.samples) {
+		l.samples = append(l.samples, d)
+	} else {
+		l.samples[l.next] = d
+		l.next = (l.next + 1) % len(l.samples)
+	}
+	l.total++
+	if len(l.samples) == l.minSamples || l.total%hedgingPercentileRefresh == 0 {
+		l.sorted = append(l.sorted[:0], l.samples...)
+		sort.Slice(l.sorted, func(i, j int) bool { return l.sorted[i] < l.sorted[j] })
+	}
+}
+
+// Percentile returns the requested percentile of the recorded samples or the fallback
+// if there are not enough samples
+func (l *latencyTracker) Percentile(p float64, fallback time.Duration) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.sorted) < l.minSamples {
+		return fallback
+	}
+	i := int(math.Ceil(p/100*float64(len(l.sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return l.sorted[i]
+}
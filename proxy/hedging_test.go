@@ -0,0 +1,204 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vm-affekt/krakend/config"
+)
+
+func TestNewHedgingMiddleware_disabled(t *testing.T) {
+	for _, remote := range []*config.Backend{
+		{},
+		{
+			ConcurrentCalls: 1,
+			ExtraConfig: config.ExtraConfig{
+				Namespace: map[string]interface{}{
+					hedgingKey: map[string]interface{}{"max_requests": 1},
+				},
+			},
+		},
+		{
+			ConcurrentCalls: 1,
+			ExtraConfig: config.ExtraConfig{
+				Namespace: map[string]interface{}{
+					hedgingKey: map[string]interface{}{"budget": 0},
+				},
+			},
+		},
+	} {
+		var calls int32
+		p := NewHedgingMiddleware(remote)(func(_ context.Context, _ *Request) (*Response, error) {
+			atomic.AddInt32(&calls, 1)
+			time.Sleep(10 * time.Millisecond)
+			return &Response{IsComplete: true}, nil
+		})
+		p(context.Background(), &Request{})
+		if calls != 1 {
+			t.Errorf("unexpected number of calls: %d", calls)
+		}
+	}
+}
+
+func TestNewHedgingMiddleware_fastBackend(t *testing.T) {
+	var calls int32
+	remote := &config.Backend{
+		ConcurrentCalls: 1,
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				hedgingKey: map[string]interface{}{"delay": "50ms"},
+			},
+		},
+	}
+	p := NewHedgingMiddleware(remote)(func(_ context.Context, _ *Request) (*Response, error) {
+		atomic.AddInt32(&calls, 1)
+		return &Response{IsComplete: true}, nil
+	})
+	for i := 0; i < 10; i++ {
+		if resp, err := p(context.Background(), &Request{}); err != nil || !resp.IsComplete {
+			t.Errorf("unexpected result: %v %v", resp, err)
+		}
+	}
+	time.Sleep(60 * time.Millisecond)
+	if calls != 10 {
+		t.Errorf("unexpected number of calls: %d", calls)
+	}
+}
+
+func TestNewHedgingMiddleware_slowBackend(t *testing.T) {
+	var calls int32
+	remote := &config.Backend{
+		ConcurrentCalls: 1,
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				hedgingKey: map[string]interface{}{"delay": "10ms", "max_requests": 3},
+			},
+		},
+	}
+	p := NewHedgingMiddleware(remote)(func(ctx context.Context, _ *Request) (*Response, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return &Response{Data: map[string]interface{}{"hedged": true}, IsComplete: true}, nil
+	})
+	start := time.Now()
+	resp, err := p(context.Background(), &Request{})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("the hedged request took too long: %s", elapsed)
+	}
+	if resp.Data["hedged"] != true {
+		t.Errorf("unexpected response: %v", resp)
+	}
+	if calls != 2 {
+		t.Errorf("unexpected number of calls: %d", calls)
+	}
+}
+
+func TestNewHedgingMiddleware_nonIdempotent(t *testing.T) {
+	var calls int32
+	remote := &config.Backend{
+		ConcurrentCalls: 1,
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				hedgingKey: map[string]interface{}{"delay": "1ms"},
+			},
+		},
+	}
+	p := NewHedgingMiddleware(remote)(func(_ context.Context, _ *Request) (*Response, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(20 * time.Millisecond)
+		return &Response{IsComplete: true}, nil
+	})
+	p(context.Background(), &Request{Method: "POST"})
+	if calls != 1 {
+		t.Errorf("unexpected number of calls: %d", calls)
+	}
+}
+
+func TestNewHedgingMiddleware_bodyTooLarge(t *testing.T) {
+	var calls int32
+	remote := &config.Backend{
+		ConcurrentCalls: 1,
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				hedgingKey:       map[string]interface{}{"delay": "1ms", "all_methods": true},
+				bodyBufferingKey: map[string]interface{}{"max_size": 2},
+			},
+		},
+	}
+	p := NewHedgingMiddleware(remote)(func(_ context.Context, r *Request) (*Response, error) {
+		atomic.AddInt32(&calls, 1)
+		if b, _ := ioutil.ReadAll(r.Body); string(b) != "supu" {
+			t.Errorf("unexpected body: %s", string(b))
+		}
+		time.Sleep(20 * time.Millisecond)
+		return &Response{IsComplete: true}, nil
+	})
+	p(context.Background(), &Request{Method: "POST", Body: newDummyReadCloser("supu")})
+	if calls != 1 {
+		t.Errorf("unexpected number of calls: %d", calls)
+	}
+}
+
+func TestNewHedgingMiddleware_allFailed(t *testing.T) {
+	expected := errors.New("boom")
+	remote := &config.Backend{
+		ConcurrentCalls: 1,
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				hedgingKey: map[string]interface{}{"delay": "1ms"},
+			},
+		},
+	}
+	p := NewHedgingMiddleware(remote)(func(_ context.Context, _ *Request) (*Response, error) {
+		time.Sleep(5 * time.Millisecond)
+		return nil, expected
+	})
+	if _, err := p(context.Background(), &Request{}); err != expected {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestHedgingBudget(t *testing.T) {
+	b := newHedgingBudget(0.5, 2)
+	if !b.Withdraw() || !b.Withdraw() {
+		t.Error("the budget should start full")
+	}
+	if b.Withdraw() {
+		t.Error("the budget should be exhausted")
+	}
+	b.Deposit()
+	if b.Withdraw() {
+		t.Error("the budget should not have a whole token")
+	}
+	b.Deposit()
+	b.Deposit()
+	if !b.Withdraw() {
+		t.Error("the budget should have a token")
+	}
+}
+
+func TestLatencyTracker(t *testing.T) {
+	l := newLatencyTracker(100, 10)
+	if d := l.Percentile(50, time.Second); d != time.Second {
+		t.Errorf("unexpected percentile without samples: %s", d)
+	}
+	for i := 1; i <= 200; i++ {
+		l.Record(time.Duration(i) * time.Millisecond)
+	}
+	if d := l.Percentile(50, time.Second); d != 150*time.Millisecond {
+		t.Errorf("unexpected p50: %s", d)
+	}
+	if d := l.Percentile(99, time.Second); d != 199*time.Millisecond {
+		t.Errorf("unexpected p99: %s", d)
+	}
+}
@@ -30,15 +30,39 @@ func NewHTTPProxy(remote *config.Backend, cf client.HTTPClientFactory, decode en
 	return NewHTTPProxyWithHTTPExecutor(remote, client.DefaultHTTPRequestExecutor(cf), decode)
 }
 
-// NewHTTPProxyWithHTTPExecutor creates a http proxy with the injected configuration, HTTPRequestExecutor and Decoder
+// NewHTTPProxyWithHTTPExecutor creates a http proxy with the injected configuration, HTTPRequestExecutor and Decoder.
+// The backends with the auto and safe encodings ignore the Decoder and select one with the Content-Type of the
+// responses (see encoding.NewContentTypeDecoder).
 func NewHTTPProxyWithHTTPExecutor(remote *config.Backend, re client.HTTPRequestExecutor, dec encoding.Decoder) Proxy {
 	if remote.Encoding == encoding.NOOP {
 		return NewHTTPProxyDetailed(remote, re, client.NoOpHTTPStatusHandler, NoOpHTTPResponseParser)
 	}
 
 	ef := NewEntityFormatter(remote)
-	rp := DefaultHTTPResponseParserFactory(HTTPResponseParserConfig{dec, ef})
-	return NewHTTPProxyDetailed(remote, re, client.GetHTTPStatusHandler(remote), rp)
+	var rp HTTPResponseParser
+	switch strings.ToLower(remote.Encoding) {
+	case encoding.AUTO, encoding.SAFE:
+		dec := encoding.NewContentTypeDecoder(remote.Encoding, remote.IsCollection, remote.ExtraConfig)
+		rp = NewHeaderAwareHTTPResponseParser(dec, ef)
+	default:
+		rp = DefaultHTTPResponseParserFactory(HTTPResponseParserConfig{dec, ef})
+	}
+	rp = NewResponseMetadataParser(remote, rp)
+	sh := client.GetHTTPStatusHandler(remote)
+	if needsBackendStatus(remote) {
+		sh = client.BackendStatusHTTPStatusHandler(sh)
+	}
+	return NewHTTPProxyDetailed(remote, re, sh, rp)
+}
+
+// needsBackendStatus reports whether the backend has a middleware depending on the status code of
+// the backend, like the retries with status codes or the health checks
+func needsBackendStatus(remote *config.Backend) bool {
+	if cfg, ok := getRetryCfg(remote.ExtraConfig); ok && len(cfg.StatusCodes) > 0 {
+		return true
+	}
+	_, ok := getNamespacedConfig(remote.ExtraConfig, healthCheckKey)
+	return ok
 }
 
 // NewHTTPProxyDetailed creates a http proxy with the injected configuration, HTTPRequestExecutor,
@@ -28,20 +28,42 @@ type HTTPResponseParserFactory func(HTTPResponseParserConfig) HTTPResponseParser
 
 // DefaultHTTPResponseParserFactory is the default implementation of HTTPResponseParserFactory
 func DefaultHTTPResponseParserFactory(cfg HTTPResponseParserConfig) HTTPResponseParser {
+	return NewHeaderAwareHTTPResponseParser(func(_ http.Header, r io.Reader, v *map[string]interface{}) error {
+		return cfg.Decoder(r, v)
+	}, cfg.EntityFormatter)
+}
+
+// NewHeaderAwareHTTPResponseParser returns a HTTPResponseParser decoding the body of the responses
+// with a decoder receiving their headers, like the ones selecting the format with the Content-Type
+func NewHeaderAwareHTTPResponseParser(dec encoding.HeaderAwareDecoder, ef EntityFormatter) HTTPResponseParser {
 	return func(ctx context.Context, resp *http.Response) (*Response, error) {
 		var data map[string]interface{}
-		err := cfg.Decoder(resp.Body, &data)
+		var err error
+		if resp.StatusCode == http.StatusNoContent {
+			data = map[string]interface{}{}
+		} else {
+			err = dec(resp.Header, resp.Body, &data)
+		}
 		resp.Body.Close()
 		if err != nil {
-			return nil, err
+			return nil, DecodingError{err}
 		}
 
 		newResponse := Response{Data: data, IsComplete: true}
-		newResponse = cfg.EntityFormatter.Format(newResponse)
+		newResponse = ef.Format(newResponse)
 		return &newResponse, nil
 	}
 }
 
+// DecodingError is the error returned by the DefaultHTTPResponseParserFactory parsers when the body
+// of the backend response can not be decoded
+type DecodingError struct {
+	Err error
+}
+
+// Error returns the message of the decoder error
+func (d DecodingError) Error() string { return d.Err.Error() }
+
 // NoOpHTTPResponseParser is a HTTPResponseParser implementation that just copies the
 // http response body into the proxy response IO
 func NoOpHTTPResponseParser(ctx context.Context, resp *http.Response) (*Response, error) {
@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"testing"
 	"time"
 
@@ -193,6 +194,101 @@ func TestNewHTTPProxy_badStatusCode(t *testing.T) {
 	}
 }
 
+func TestNewHTTPProxy_configuredStatusCodes(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/accepted":
+			w.WriteHeader(http.StatusAccepted)
+			fmt.Fprint(w, `{"status":"queued"}`)
+		case "/empty":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "booom", http.StatusServiceUnavailable)
+		}
+	}))
+	defer backendServer.Close()
+
+	backend := config.Backend{
+		Decoder: encoding.JSONDecoder,
+		ExtraConfig: config.ExtraConfig{
+			client.Namespace: map[string]interface{}{
+				"success_status_codes": []interface{}{200.0, 202.0, 204.0},
+				"status_code_mapping":  map[string]interface{}{"5xx": 502.0},
+			},
+		},
+	}
+	prxy := httpProxy(&backend)
+
+	for _, path := range []string{"/accepted", "/empty"} {
+		rpURL, _ := url.Parse(backendServer.URL + path)
+		response, err := prxy(context.Background(), &Request{Method: "GET", URL: rpURL, Body: newDummyReadCloser("")})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", path, err.Error())
+			continue
+		}
+		if response == nil || !response.IsComplete || response.Data == nil {
+			t.Errorf("%s: unexpected response: %v", path, response)
+		}
+	}
+
+	rpURL, _ := url.Parse(backendServer.URL + "/failing")
+	_, err := prxy(context.Background(), &Request{Method: "GET", URL: rpURL, Body: newDummyReadCloser("")})
+	e, ok := err.(client.InvalidStatusCodeError)
+	if !ok {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	if e.StatusCode() != http.StatusBadGateway || e.BackendStatusCode() != http.StatusServiceUnavailable {
+		t.Errorf("unexpected status codes: %d, %d", e.StatusCode(), e.BackendStatusCode())
+	}
+}
+
+func TestNewHTTPProxy_contentTypeEncoding(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/json":
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			fmt.Fprint(w, `{"status":"ok"}`)
+		case "/xml":
+			w.Header().Set("Content-Type", "application/xml")
+			fmt.Fprint(w, `<response><status>ok</status></response>`)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `<html><body>maintenance</body></html>`)
+		}
+	}))
+	defer backendServer.Close()
+
+	for _, tc := range []struct {
+		encoding string
+		path     string
+		expected map[string]interface{}
+	}{
+		{encoding: encoding.AUTO, path: "/json", expected: map[string]interface{}{"status": "ok"}},
+		{encoding: encoding.AUTO, path: "/xml", expected: map[string]interface{}{"status": "ok"}},
+		{encoding: encoding.SAFE, path: "/xml", expected: map[string]interface{}{"status": "ok"}},
+		{encoding: encoding.SAFE, path: "/html", expected: map[string]interface{}{"content": "<html><body>maintenance</body></html>"}},
+	} {
+		backend := config.Backend{Encoding: tc.encoding, Decoder: encoding.JSONDecoder}
+		rpURL, _ := url.Parse(backendServer.URL + tc.path)
+		response, err := httpProxy(&backend)(context.Background(), &Request{Method: "GET", URL: rpURL, Body: newDummyReadCloser("")})
+		if err != nil {
+			t.Errorf("%s %s: unexpected error: %s", tc.encoding, tc.path, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(response.Data, tc.expected) {
+			t.Errorf("%s %s: unexpected response: %v", tc.encoding, tc.path, response.Data)
+		}
+	}
+
+	backend := config.Backend{Encoding: encoding.AUTO, Decoder: encoding.JSONDecoder}
+	rpURL, _ := url.Parse(backendServer.URL + "/html")
+	_, err := httpProxy(&backend)(context.Background(), &Request{Method: "GET", URL: rpURL, Body: newDummyReadCloser("")})
+	if _, ok := err.(DecodingError); !ok {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
 func TestNewHTTPProxy_badStatusCode_detailed(t *testing.T) {
 	expectedMethod := "GET"
 	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -2,9 +2,8 @@ package proxy
 
 import (
 	"context"
-	"fmt"
 	"regexp"
-	"strconv"
+	"sort"
 	"strings"
 	"time"
 
@@ -21,12 +20,15 @@ func NewMergeDataMiddleware(endpointConfig *config.EndpointConfig) Middleware {
 		return EmptyMiddleware
 	}
 	serviceTimeout := time.Duration(85*endpointConfig.Timeout.Nanoseconds()/100) * time.Nanosecond
-	combiner := getResponseCombiner(endpointConfig.ExtraConfig)
+	combiner := getMetadataMergePolicy(endpointConfig.ExtraConfig).Wrap(getMergeCombiner(endpointConfig.ExtraConfig))
 
 	return func(next ...Proxy) Proxy {
 		if len(next) != totalBackends {
 			panic(ErrNotEnoughProxies)
 		}
+		if nodes, ok := getMergeGraph(endpointConfig.Backend); ok {
+			return graphMerge(nodes, serviceTimeout, combiner, next...)
+		}
 		if shouldRunSequentialMerger(endpointConfig) {
 			patterns := make([]string, len(endpointConfig.Backend))
 			for i, b := range endpointConfig.Backend {
@@ -50,25 +52,20 @@ func shouldRunSequentialMerger(endpointConfig *config.EndpointConfig) bool {
 	return false
 }
 
-func parallelMerge(timeout time.Duration, rc ResponseCombiner, next ...Proxy) Proxy {
+func parallelMerge(timeout time.Duration, rc errorAwareCombiner, next ...Proxy) Proxy {
 	return func(ctx context.Context, request *Request) (*Response, error) {
 		localCtx, cancel := context.WithTimeout(ctx, timeout)
 
-		parts := make(chan *Response, len(next))
-		failed := make(chan error, len(next))
+		parts := make(chan mergePart, len(next))
 
-		for _, n := range next {
-			go requestPart(localCtx, n, request, parts, failed)
+		for i, n := range next {
+			go requestPart(localCtx, n, request, i, parts)
 		}
 
-		acc := newIncrementalMergeAccumulator(len(next), rc)
+		acc := newErrorAwareMergeAccumulator(len(next), rc)
 		for i := 0; i < len(next); i++ {
-			select {
-			case err := <-failed:
-				acc.Merge(nil, err)
-			case response := <-parts:
-				acc.Merge(response, nil)
-			}
+			part := <-parts
+			acc.MergeFrom(part.index, part.resp, part.err)
 		}
 
 		result, err := acc.Result()
@@ -77,52 +74,36 @@ func parallelMerge(timeout time.Duration, rc ResponseCombiner, next ...Proxy) Pr
 	}
 }
 
-var reMergeKey = regexp.MustCompile(`\{\{\.Resp(\d+)_([\d\w-_]+)\}\}`)
+var reMergeKey = regexp.MustCompile(`\{\{\.Resp(\d+)_([\d\w-_\.]+)\}\}`)
 
-func sequentialMerge(patterns []string, timeout time.Duration, rc ResponseCombiner, next ...Proxy) Proxy {
+func sequentialMerge(patterns []string, timeout time.Duration, rc errorAwareCombiner, next ...Proxy) Proxy {
 	return func(ctx context.Context, request *Request) (*Response, error) {
 		localCtx, cancel := context.WithTimeout(ctx, timeout)
 
 		parts := make([]*Response, len(next))
-		out := make(chan *Response, 1)
-		errCh := make(chan error, 1)
+		out := make(chan mergePart, 1)
 
-		acc := newIncrementalMergeAccumulator(len(next), rc)
+		acc := newErrorAwareMergeAccumulator(len(next), rc)
 	TxLoop:
 		for i, n := range next {
 			if i > 0 {
-				for _, match := range reMergeKey.FindAllStringSubmatch(patterns[i], -1) {
-					if len(match) > 1 {
-						rNum, err := strconv.Atoi(match[1])
-						if err != nil || rNum >= i || parts[rNum] == nil {
-							continue
-						}
-						key := "Resp" + match[1] + "_" + match[2]
-
-						v, ok := parts[rNum].Data[match[2]]
-						if !ok {
-							continue
-						}
-						request.Params[key] = fmt.Sprintf("%v", v)
-					}
-				}
+				resolveMergeParams(patterns[i], request.Params, parts[:i])
 			}
-			requestPart(localCtx, n, request, out, errCh)
-			select {
-			case err := <-errCh:
+			requestPart(localCtx, n, request, i, out)
+			part := <-out
+			if part.err != nil {
 				if i == 0 {
 					cancel()
-					return nil, err
+					return nil, part.err
 				}
-				acc.Merge(nil, err)
+				acc.MergeFrom(i, nil, part.err)
 				break TxLoop
-			case response := <-out:
-				acc.Merge(response, nil)
-				if !response.IsComplete {
-					break TxLoop
-				}
-				parts[i] = response
 			}
+			acc.MergeFrom(i, part.resp, nil)
+			if !part.resp.IsComplete {
+				break TxLoop
+			}
+			parts[i] = part.resp
 		}
 
 		result, err := acc.Result()
@@ -133,81 +114,120 @@ func sequentialMerge(patterns []string, timeout time.Duration, rc ResponseCombin
 
 type incrementalMergeAccumulator struct {
 	pending  int
-	data     *Response
-	combiner ResponseCombiner
+	parts    []mergePart
+	combiner errorAwareCombiner
 	errs     []error
+	backends []int
 }
 
 func newIncrementalMergeAccumulator(total int, combiner ResponseCombiner) *incrementalMergeAccumulator {
+	return newErrorAwareMergeAccumulator(total, newErrorAwareCombiner(combiner))
+}
+
+func newErrorAwareMergeAccumulator(total int, combiner errorAwareCombiner) *incrementalMergeAccumulator {
 	return &incrementalMergeAccumulator{
 		pending:  total,
 		combiner: combiner,
 		errs:     []error{},
+		backends: []int{},
 	}
 }
 
 func (i *incrementalMergeAccumulator) Merge(res *Response, err error) {
+	i.MergeFrom(-1, res, err)
+}
+
+// MergeFrom collects the result of the backend with the received index. The index is used for
+// reporting the failures of the backend (-1 if unknown) and for sorting the responses to combine.
+func (i *incrementalMergeAccumulator) MergeFrom(backend int, res *Response, err error) {
 	i.pending--
 	if err != nil {
-		i.errs = append(i.errs, err)
-		if i.data != nil {
-			i.data.IsComplete = false
-		}
+		i.addError(backend, err)
 		return
 	}
 	if res == nil {
-		i.errs = append(i.errs, errNullResult)
-		return
-	}
-	if i.data == nil {
-		i.data = res
+		i.addError(backend, errNullResult)
 		return
 	}
-	i.data = i.combiner(2, []*Response{i.data, res})
+	i.parts = append(i.parts, mergePart{index: backend, resp: res})
+}
+
+func (i *incrementalMergeAccumulator) addError(backend int, err error) {
+	i.errs = append(i.errs, err)
+	i.backends = append(i.backends, backend)
 }
 
+// Result combines the collected responses in the order of their backends, so the conflicts are
+// resolved the same way no matter the order the responses arrived
 func (i *incrementalMergeAccumulator) Result() (*Response, error) {
-	if i.data == nil {
-		return &Response{Data: make(map[string]interface{}, 0), IsComplete: false}, newMergeError(i.errs)
+	sort.SliceStable(i.parts, func(a, b int) bool { return i.parts[a].index < i.parts[b].index })
+	var data *Response
+	for _, part := range i.parts {
+		if data == nil {
+			data = part.resp
+			continue
+		}
+		degraded := isDegraded(part.resp)
+		var err error
+		data, err = i.combiner(2, []*Response{data, part.resp})
+		if err != nil {
+			i.addError(-1, err)
+		}
+		if degraded {
+			markDegraded(data)
+		}
+	}
+
+	if data == nil {
+		return &Response{Data: make(map[string]interface{}, 0), IsComplete: false}, newMergeError(i.errs, i.backends)
 	}
 
 	if i.pending != 0 || len(i.errs) != 0 {
-		i.data.IsComplete = false
+		data.IsComplete = false
 	}
-	return i.data, newMergeError(i.errs)
+	return data, newMergeError(i.errs, i.backends)
+}
+
+// mergePart is the result of the backend with the received index
+type mergePart struct {
+	index int
+	resp  *Response
+	err   error
 }
 
-func requestPart(ctx context.Context, next Proxy, request *Request, out chan<- *Response, failed chan<- error) {
+// requestPart sends the result of the backend with the received index to the out channel. The
+// responses received after the context is done are reported as errors.
+func requestPart(ctx context.Context, next Proxy, request *Request, index int, out chan<- mergePart) {
 	localCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	in, err := next(localCtx, request)
-	if err != nil {
-		failed <- err
-		cancel()
-		return
+	if err == nil && in == nil {
+		err = errNullResult
 	}
-	if in == nil {
-		failed <- errNullResult
-		cancel()
+	if err != nil {
+		out <- mergePart{index: index, err: err}
 		return
 	}
 	select {
-	case out <- in:
+	case out <- mergePart{index: index, resp: in}:
 	case <-ctx.Done():
-		failed <- ctx.Err()
+		out <- mergePart{index: index, err: ctx.Err()}
 	}
-	cancel()
 }
 
-func newMergeError(errs []error) error {
+func newMergeError(errs []error, backends []int) error {
 	if len(errs) == 0 {
 		return nil
 	}
-	return mergeError{errs}
+	return mergeError{errs, backends}
 }
 
+// mergeError contains the errors collected while merging the responses and the index of the
+// backend reporting each of them (-1 if unknown)
 type mergeError struct {
-	errs []error
+	errs     []error
+	backends []int
 }
 
 func (m mergeError) Error() string {
@@ -221,6 +241,15 @@ func (m mergeError) Error() string {
 // ResponseCombiner func to merge the collected responses into a single one
 type ResponseCombiner func(int, []*Response) *Response
 
+// errorAwareCombiner merges the collected responses into a single one, reporting the merging errors
+type errorAwareCombiner func(int, []*Response) (*Response, error)
+
+func newErrorAwareCombiner(rc ResponseCombiner) errorAwareCombiner {
+	return func(total int, parts []*Response) (*Response, error) {
+		return rc(total, parts), nil
+	}
+}
+
 // RegisterResponseCombiner adds a new response combiner into the internal register
 func RegisterResponseCombiner(name string, f ResponseCombiner) {
 	responseCombiners.SetResponseCombiner(name, f)
@@ -235,7 +264,23 @@ const (
 var responseCombiners = initResponseCombiners()
 
 func initResponseCombiners() *combinerRegister {
-	return newCombinerRegister(map[string]ResponseCombiner{defaultCombinerName: combineData}, combineData)
+	return newCombinerRegister(map[string]ResponseCombiner{
+		defaultCombinerName:   combineData,
+		deepMergeCombinerName: NewDeepMergeCombiner(DeepMergeConfig{}),
+	}, combineData)
+}
+
+// getMergeCombiner returns the combiner to use by the merge middleware. The deep_merge combiner is
+// built with the options defined in the extra config, so it is able to report merge conflicts.
+func getMergeCombiner(extra config.ExtraConfig) errorAwareCombiner {
+	if v, ok := extra[Namespace]; ok {
+		if e, ok := v.(map[string]interface{}); ok {
+			if name, ok := e[mergeKey].(string); ok && name == deepMergeCombinerName {
+				return newDeepMerger(getDeepMergeConfig(e)).Combine
+			}
+		}
+	}
+	return newErrorAwareCombiner(getResponseCombiner(extra))
 }
 
 func getResponseCombiner(extra config.ExtraConfig) ResponseCombiner {
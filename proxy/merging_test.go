@@ -518,13 +518,13 @@ func TestNewMergeDataMiddleware_noBackends(t *testing.T) {
 }
 func TestRegisterResponseCombiner(t *testing.T) {
 	subject := "test combiner"
-	if len(responseCombiners.data.Clone()) != 1 {
+	if len(responseCombiners.data.Clone()) != 2 {
 		t.Error("unexpected initial size of the response combiner list:", responseCombiners.data.Clone())
 	}
 	RegisterResponseCombiner(subject, getResponseCombiner(config.ExtraConfig{}))
 	defer func() { responseCombiners = initResponseCombiners() }()
 
-	if len(responseCombiners.data.Clone()) != 2 {
+	if len(responseCombiners.data.Clone()) != 3 {
 		t.Error("unexpected size of the response combiner list:", responseCombiners.data.Clone())
 	}
 	timeout := 500
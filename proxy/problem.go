@@ -0,0 +1,207 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/vm-affekt/krakend/config"
+	"github.com/vm-affekt/krakend/sd"
+	"github.com/vm-affekt/krakend/transport/http/client"
+)
+
+const (
+	problemKey = "problem_details"
+
+	// ProblemContentType is the content type of the problem details documents (RFC 7807)
+	ProblemContentType = "application/problem+json"
+
+	defaultProblemTypePrefix = "urn:krakend:problem:"
+
+	// ProblemTypeTimeout flags the failures caused by a timeout
+	ProblemTypeTimeout = "timeout"
+	// ProblemTypeBackendStatus flags the backend responses with a rejected status code
+	ProblemTypeBackendStatus = "backend_status"
+	// ProblemTypeBackendDecoding flags the backend responses that could not be decoded
+	ProblemTypeBackendDecoding = "backend_decoding"
+	// ProblemTypeBackendUnavailable flags the backends without hosts or with an open circuit breaker
+	ProblemTypeBackendUnavailable = "backend_unavailable"
+	// ProblemTypeDependencyFailed flags the backends skipped because one of their dependencies failed
+	ProblemTypeDependencyFailed = "dependency_failed"
+	// ProblemTypeBackendFailure flags the requests with several kinds of backend failures
+	ProblemTypeBackendFailure = "backend_failure"
+	// ProblemTypeInvalidRequest flags the requests rejected by the gateway
+	ProblemTypeInvalidRequest = "invalid_request"
+	// ProblemTypeInternalError flags any other failure
+	ProblemTypeInternalError = "internal_error"
+)
+
+// Problem is a problem details document (RFC 7807) describing the failure of an endpoint
+type Problem struct {
+	Type     string           `json:"type"`
+	Title    string           `json:"title"`
+	Status   int              `json:"status"`
+	Detail   string           `json:"detail,omitempty"`
+	Instance string           `json:"instance,omitempty"`
+	Failures []BackendFailure `json:"failures,omitempty"`
+}
+
+// BackendFailure describes the failure of a single backend. The url pattern, the status code and the
+// error message are only exposed if the endpoint enables the details.
+type BackendFailure struct {
+	Backend    int    `json:"backend"`
+	Type       string `json:"type"`
+	URLPattern string `json:"url_pattern,omitempty"`
+	Status     int    `json:"status,omitempty"`
+	Detail     string `json:"detail,omitempty"`
+}
+
+// Write sends the problem to the client
+func (p Problem) Write(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// ProblemFactory creates the problem describing the error returned by the proxy of an endpoint. The
+// status is the one the router would return. The instance is the path of the request.
+type ProblemFactory func(err error, status int, instance string) Problem
+
+// NewProblemFactory returns a ProblemFactory for the endpoint and a flag signaling if the endpoint
+// renders its errors as problem details. The details of the errors are hidden by default.
+//
+// If the router would return a 500 Internal Server Error, timeouts are reported as a 504 Gateway
+// Timeout and the other backend failures as a 502 Bad Gateway.
+//
+//	"github.com/vm-affekt/krakend/proxy": {
+//		"problem_details": {
+//			"details": false,
+//			"type_prefix": "https://example.com/problems/"
+//		}
+//	}
+func NewProblemFactory(endpointConfig *config.EndpointConfig) (ProblemFactory, bool) {
+	v, ok := endpointConfig.ExtraConfig[Namespace]
+	if !ok {
+		return nil, false
+	}
+	e, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	details, prefix := false, defaultProblemTypePrefix
+	switch c := e[problemKey].(type) {
+	case bool:
+		if !c {
+			return nil, false
+		}
+	case map[string]interface{}:
+		details = getBool(c, "details", false)
+		prefix = getString(c, "type_prefix", prefix)
+	default:
+		return nil, false
+	}
+
+	return func(err error, status int, instance string) Problem {
+		failures := getBackendFailures(err)
+		problemType := ProblemTypeInternalError
+		for i, f := range failures {
+			if i == 0 {
+				problemType = f.Type
+			} else if problemType != f.Type {
+				problemType = ProblemTypeBackendFailure
+			}
+		}
+
+		if status == http.StatusInternalServerError {
+			switch problemType {
+			case ProblemTypeTimeout:
+				status = http.StatusGatewayTimeout
+			case ProblemTypeInternalError, ProblemTypeInvalidRequest:
+			default:
+				status = http.StatusBadGateway
+			}
+		}
+
+		p := Problem{
+			Type:     prefix + problemType,
+			Title:    http.StatusText(status),
+			Status:   status,
+			Instance: instance,
+			Failures: failures,
+		}
+		if _, ok := err.(mergeError); !ok && details && err != nil {
+			p.Detail = err.Error()
+		}
+		for i, f := range p.Failures {
+			if !details {
+				p.Failures[i] = BackendFailure{Backend: f.Backend, Type: f.Type}
+				continue
+			}
+			if f.Backend >= 0 && f.Backend < len(endpointConfig.Backend) {
+				p.Failures[i].URLPattern = endpointConfig.Backend[f.Backend].URLPattern
+			}
+		}
+		return p
+	}, true
+}
+
+func getBackendFailures(err error) []BackendFailure {
+	if err == nil {
+		return nil
+	}
+	m, ok := err.(mergeError)
+	if !ok {
+		return []BackendFailure{newBackendFailure(0, err)}
+	}
+	failures := make([]BackendFailure, 0, len(m.errs))
+	for i, e := range m.errs {
+		backend := -1
+		if i < len(m.backends) {
+			backend = m.backends[i]
+		}
+		if backend < 0 {
+			// merging errors are not backend failures
+			continue
+		}
+		failures = append(failures, newBackendFailure(backend, e))
+	}
+	return failures
+}
+
+func newBackendFailure(backend int, err error) BackendFailure {
+	f := BackendFailure{Backend: backend, Type: ProblemTypeInternalError, Detail: err.Error()}
+	switch t := err.(type) {
+	case skippedBackendError:
+		f.Type = ProblemTypeDependencyFailed
+	case DecodingError:
+		f.Type = ProblemTypeBackendDecoding
+	case client.InvalidStatusCodeError:
+		f.Type = ProblemTypeBackendStatus
+		f.Status = t.BackendStatusCode()
+	case client.UnexpectedStatusCodeError:
+		f.Type = ProblemTypeBackendStatus
+		f.Status = t.BackendStatusCode()
+	case client.HTTPResponseError:
+		f.Type = ProblemTypeBackendStatus
+		f.Status = t.StatusCode()
+	case net.Error:
+		if t.Timeout() {
+			f.Type = ProblemTypeTimeout
+		}
+	case interface{ StatusCode() int }:
+		if t.StatusCode() < http.StatusInternalServerError {
+			f.Type = ProblemTypeInvalidRequest
+		}
+	default:
+		switch err {
+		case context.DeadlineExceeded:
+			f.Type = ProblemTypeTimeout
+		case client.ErrInvalidStatusCode:
+			f.Type = ProblemTypeBackendStatus
+		case sd.ErrNoHosts, ErrCircuitBreakerOpen:
+			f.Type = ProblemTypeBackendUnavailable
+		}
+	}
+	return f
+}
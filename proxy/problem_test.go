@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vm-affekt/krakend/config"
+	"github.com/vm-affekt/krakend/sd"
+	"github.com/vm-affekt/krakend/transport/http/client"
+)
+
+func TestNewProblemFactory_disabled(t *testing.T) {
+	for _, endpoint := range []*config.EndpointConfig{
+		{},
+		{
+			ExtraConfig: config.ExtraConfig{
+				Namespace: map[string]interface{}{problemKey: false},
+			},
+		},
+		{
+			ExtraConfig: config.ExtraConfig{
+				Namespace: map[string]interface{}{problemKey: "yes"},
+			},
+		},
+	} {
+		if _, ok := NewProblemFactory(endpoint); ok {
+			t.Errorf("unexpected problem factory for %v", endpoint.ExtraConfig)
+		}
+	}
+}
+
+func TestNewProblemFactory_singleBackend(t *testing.T) {
+	endpoint := &config.EndpointConfig{
+		Backend: []*config.Backend{
+			{URLPattern: "/users/{{.Id}}"},
+			{URLPattern: "/posts"},
+			{URLPattern: "/comments"},
+		},
+		Timeout: 100 * time.Millisecond,
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				problemKey: true,
+			},
+		},
+	}
+	problems, ok := NewProblemFactory(endpoint)
+	if !ok {
+		t.Error("the problem factory should be enabled")
+		return
+	}
+
+	for _, tc := range []struct {
+		err            error
+		status         int
+		expectedType   string
+		expectedStatus int
+	}{
+		{context.DeadlineExceeded, http.StatusInternalServerError, ProblemTypeTimeout, http.StatusGatewayTimeout},
+		{client.ErrInvalidStatusCode, http.StatusInternalServerError, ProblemTypeBackendStatus, http.StatusBadGateway},
+		{client.InvalidStatusCodeError{Code: 404, Status: 404}, http.StatusNotFound, ProblemTypeBackendStatus, http.StatusNotFound},
+		{DecodingError{errors.New("invalid character")}, http.StatusInternalServerError, ProblemTypeBackendDecoding, http.StatusBadGateway},
+		{sd.ErrNoHosts, http.StatusInternalServerError, ProblemTypeBackendUnavailable, http.StatusBadGateway},
+		{ErrCircuitBreakerOpen, http.StatusInternalServerError, ProblemTypeBackendUnavailable, http.StatusBadGateway},
+		{ErrRequestBodyTooLarge, http.StatusRequestEntityTooLarge, ProblemTypeInvalidRequest, http.StatusRequestEntityTooLarge},
+		{errors.New("booom"), http.StatusInternalServerError, ProblemTypeInternalError, http.StatusInternalServerError},
+	} {
+		p := problems(tc.err, tc.status, "/supu")
+		if p.Type != defaultProblemTypePrefix+tc.expectedType {
+			t.Errorf("%v: unexpected type: %s", tc.err, p.Type)
+		}
+		if p.Status != tc.expectedStatus || p.Title != http.StatusText(tc.expectedStatus) {
+			t.Errorf("%v: unexpected status: %d %s", tc.err, p.Status, p.Title)
+		}
+		if p.Instance != "/supu" || p.Detail != "" {
+			t.Errorf("%v: unexpected problem: %v", tc.err, p)
+		}
+		expected := []BackendFailure{{Backend: 0, Type: tc.expectedType}}
+		if !reflect.DeepEqual(p.Failures, expected) {
+			t.Errorf("%v: unexpected failures: %v", tc.err, p.Failures)
+		}
+	}
+}
+
+func TestNewProblemFactory_mergedBackends(t *testing.T) {
+	endpoint := &config.EndpointConfig{
+		Backend: []*config.Backend{
+			{URLPattern: "/users/{{.Id}}"},
+			{URLPattern: "/posts"},
+			{URLPattern: "/comments"},
+		},
+		Timeout: 100 * time.Millisecond,
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				problemKey: map[string]interface{}{
+					"details":     true,
+					"type_prefix": "https://example.com/problems/",
+				},
+			},
+		},
+	}
+	problems, ok := NewProblemFactory(endpoint)
+	if !ok {
+		t.Error("the problem factory should be enabled")
+		return
+	}
+
+	p := NewMergeDataMiddleware(endpoint)(
+		dummyProxy(&Response{Data: map[string]interface{}{"a": 1}, IsComplete: true}),
+		func(_ context.Context, _ *Request) (*Response, error) {
+			return nil, client.InvalidStatusCodeError{Code: 503, Status: 502}
+		},
+		func(_ context.Context, _ *Request) (*Response, error) {
+			return nil, DecodingError{errors.New("invalid character")}
+		},
+	)
+	_, err := p(context.Background(), &Request{})
+	if err == nil {
+		t.Error("expecting an error")
+		return
+	}
+
+	problem := problems(err, http.StatusInternalServerError, "/supu")
+	if problem.Type != "https://example.com/problems/"+ProblemTypeBackendFailure {
+		t.Errorf("unexpected type: %s", problem.Type)
+	}
+	if problem.Status != http.StatusBadGateway {
+		t.Errorf("unexpected status: %d", problem.Status)
+	}
+	if problem.Detail != "" {
+		t.Errorf("unexpected detail: %s", problem.Detail)
+	}
+	if len(problem.Failures) != 2 {
+		t.Errorf("unexpected failures: %v", problem.Failures)
+		return
+	}
+	for _, f := range problem.Failures {
+		switch f.Backend {
+		case 1:
+			expected := BackendFailure{Backend: 1, Type: ProblemTypeBackendStatus, URLPattern: "/posts", Status: 503, Detail: client.ErrInvalidStatusCode.Error()}
+			if f != expected {
+				t.Errorf("unexpected failure: %v", f)
+			}
+		case 2:
+			expected := BackendFailure{Backend: 2, Type: ProblemTypeBackendDecoding, URLPattern: "/comments", Detail: "invalid character"}
+			if f != expected {
+				t.Errorf("unexpected failure: %v", f)
+			}
+		default:
+			t.Errorf("unexpected failure: %v", f)
+		}
+	}
+}
+
+func TestProblem_Write(t *testing.T) {
+	w := httptest.NewRecorder()
+	Problem{
+		Type:     defaultProblemTypePrefix + ProblemTypeTimeout,
+		Title:    http.StatusText(http.StatusGatewayTimeout),
+		Status:   http.StatusGatewayTimeout,
+		Failures: []BackendFailure{{Backend: 0, Type: ProblemTypeTimeout}},
+	}.Write(w)
+
+	resp := w.Result()
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != ProblemContentType {
+		t.Errorf("unexpected content type: %s", ct)
+	}
+	var body map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if body["type"] != "urn:krakend:problem:timeout" || body["status"] != 504. {
+		t.Errorf("unexpected body: %v", body)
+	}
+}
@@ -26,6 +26,59 @@ type Response struct {
 	Io         io.Reader
 }
 
+// CloneResponse returns a deep copy of the received response, so the data and the metadata of the
+// received and the returned responses can be manipulated independently. The Io reader is not cloned.
+func CloneResponse(r *Response) *Response {
+	if r == nil {
+		return nil
+	}
+	res := &Response{
+		Data:       CloneResponseData(r.Data),
+		IsComplete: r.IsComplete,
+		Metadata: Metadata{
+			StatusCode: r.Metadata.StatusCode,
+		},
+		Io: r.Io,
+	}
+	if r.Metadata.Headers != nil {
+		res.Metadata.Headers = CloneRequestHeaders(r.Metadata.Headers)
+	}
+	return res
+}
+
+// CloneResponseData returns a deep copy of the received response data
+func CloneResponseData(data map[string]interface{}) map[string]interface{} {
+	if data == nil {
+		return nil
+	}
+	res := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		res[k] = cloneValue(v)
+	}
+	return res
+}
+
+func cloneValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return CloneResponseData(t)
+	case []interface{}:
+		res := make([]interface{}, len(t))
+		for i, e := range t {
+			res[i] = cloneValue(e)
+		}
+		return res
+	case []map[string]interface{}:
+		res := make([]map[string]interface{}, len(t))
+		for i, e := range t {
+			res[i] = CloneResponseData(e)
+		}
+		return res
+	default:
+		return v
+	}
+}
+
 // readCloserWrapper is Io.Reader which is closed when the Context is closed or canceled
 type readCloserWrapper struct {
 	ctx context.Context
@@ -0,0 +1,253 @@
+package proxy
+
+import (
+	"container/list"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vm-affekt/krakend/config"
+)
+
+const (
+	rateLimitKey = "rate_limit"
+
+	rateLimitIPStrategy     = "ip"
+	rateLimitHeaderStrategy = "header"
+	rateLimitJWTStrategy    = "jwt"
+
+	defaultRateLimitMaxClients = 10000
+)
+
+// RateLimitError is the error returned when a request exceeds the rate limit of the endpoint
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+// Error implements the error interface
+func (RateLimitError) Error() string { return "rate limit exceeded" }
+
+// StatusCode returns the status code to send to the client
+func (RateLimitError) StatusCode() int { return http.StatusTooManyRequests }
+
+// Headers returns the headers to send to the client
+func (r RateLimitError) Headers() map[string][]string {
+	return map[string][]string{
+		"Retry-After": {strconv.Itoa(int(math.Ceil(r.RetryAfter.Seconds())))},
+	}
+}
+
+// NewRateLimitMiddleware creates a proxy middleware limiting the rate of the requests reaching the
+// endpoint with a token bucket shared by all the clients and, optionally, a token bucket per client.
+// Clients are identified by their IP (the X-Forwarded-For header added by the router), by the value of
+// a header or by a claim of the JWT sent in the Authorization header (the token signature is not
+// validated). The headers used by the header and jwt strategies must be in the headers_to_pass list.
+//
+// Rejected requests get a RateLimitError, so the routers answer with a 429 and a Retry-After header.
+// The capacities default to the rates and they are never lower than 1.
+//
+//	"github.com/vm-affekt/krakend/proxy": {
+//		"rate_limit": {
+//			"max_rate": 100,
+//			"capacity": 100,
+//			"client_max_rate": 10,
+//			"client_capacity": 10,
+//			"strategy": "header",
+//			"key": "X-Api-Key"
+//		}
+//	}
+func NewRateLimitMiddleware(endpointConfig *config.EndpointConfig) Middleware {
+	cfg, ok := getRateLimitCfg(endpointConfig.ExtraConfig)
+	if !ok {
+		return EmptyMiddleware
+	}
+	return func(next ...Proxy) Proxy {
+		if len(next) > 1 {
+			panic(ErrTooManyProxies)
+		}
+		var global *tokenBucket
+		if cfg.MaxRate > 0 {
+			global = newTokenBucket(cfg.MaxRate, cfg.Capacity, time.Now())
+		}
+		var clients *clientBuckets
+		if cfg.ClientMaxRate > 0 {
+			clients = newClientBuckets(cfg.ClientMaxRate, cfg.ClientCapacity, cfg.MaxClients)
+		}
+		return func(ctx context.Context, request *Request) (*Response, error) {
+			now := time.Now()
+			if clients != nil {
+				if ok, wait := clients.Allow(cfg.clientKey(request), now); !ok {
+					return nil, RateLimitError{wait}
+				}
+			}
+			if global != nil {
+				if ok, wait := global.Allow(now); !ok {
+					return nil, RateLimitError{wait}
+				}
+			}
+			return next[0](ctx, request)
+		}
+	}
+}
+
+type rateLimitConfig struct {
+	MaxRate        float64
+	Capacity       float64
+	ClientMaxRate  float64
+	ClientCapacity float64
+	MaxClients     int
+	Strategy       string
+	Key            string
+}
+
+func getRateLimitCfg(extra config.ExtraConfig) (rateLimitConfig, bool) {
+	tmp, ok := getNamespacedConfig(extra, rateLimitKey)
+	if !ok {
+		return rateLimitConfig{}, false
+	}
+	cfg := rateLimitConfig{
+		MaxRate:       getFloat(tmp, "max_rate", 0),
+		ClientMaxRate: getFloat(tmp, "client_max_rate", 0),
+		MaxClients:    getInt(tmp, "max_clients", defaultRateLimitMaxClients),
+		Strategy:      getString(tmp, "strategy", rateLimitIPStrategy),
+		Key:           getString(tmp, "key", ""),
+	}
+	// the buckets must hold at least a token, or they would reject every request
+	cfg.Capacity = math.Max(1, getFloat(tmp, "capacity", cfg.MaxRate))
+	cfg.ClientCapacity = math.Max(1, getFloat(tmp, "client_capacity", cfg.ClientMaxRate))
+	return cfg, cfg.MaxRate > 0 || cfg.ClientMaxRate > 0
+}
+
+// clientKey extracts the identity of the client from the request. It falls back to the client IP
+// if the configured strategy is not able to find it.
+func (r rateLimitConfig) clientKey(request *Request) string {
+	switch r.Strategy {
+	case rateLimitHeaderStrategy:
+		if v := getHeader(request.Headers, r.Key); len(v) > 0 && v[0] != "" {
+			return "header:" + v[0]
+		}
+	case rateLimitJWTStrategy:
+		if v, ok := jwtClaim(getHeader(request.Headers, "Authorization"), r.Key); ok {
+			return "jwt:" + v
+		}
+	}
+	if v := getHeader(request.Headers, "X-Forwarded-For"); len(v) > 0 {
+		return "ip:" + v[0]
+	}
+	return ""
+}
+
+func jwtClaim(authorization []string, claim string) (string, bool) {
+	if len(authorization) == 0 || claim == "" {
+		return "", false
+	}
+	token := strings.TrimSpace(authorization[0])
+	if len(token) < 7 || !strings.EqualFold(token[:7], "bearer ") {
+		return "", false
+	}
+	parts := strings.Split(strings.TrimSpace(token[7:]), ".")
+	if len(parts) != 3 {
+		return "", false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return "", false
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", false
+	}
+	v, ok := claims[claim]
+	if !ok || v == nil {
+		return "", false
+	}
+	return fmt.Sprintf("%v", v), true
+}
+
+// tokenBucket is a thread-safe token bucket refilled at a constant rate (tokens per second)
+type tokenBucket struct {
+	mu       sync.Mutex
+	rate     float64
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+func newTokenBucket(rate, capacity float64, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:     rate,
+		capacity: capacity,
+		tokens:   capacity,
+		last:     now,
+	}
+}
+
+// Allow consumes a token if available. Otherwise, it returns the time to wait for the next one
+func (b *tokenBucket) Allow(now time.Time) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.capacity, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// clientBuckets keeps a token bucket per client. When the number of tracked clients reaches the
+// limit, the bucket of the least recently seen client is dropped.
+type clientBuckets struct {
+	mu         sync.Mutex
+	rate       float64
+	capacity   float64
+	maxClients int
+	ll         *list.List
+	buckets    map[string]*list.Element
+}
+
+type clientBucket struct {
+	key    string
+	bucket *tokenBucket
+}
+
+func newClientBuckets(rate, capacity float64, maxClients int) *clientBuckets {
+	return &clientBuckets{
+		rate:       rate,
+		capacity:   capacity,
+		maxClients: maxClients,
+		ll:         list.New(),
+		buckets:    map[string]*list.Element{},
+	}
+}
+
+// Allow consumes a token from the bucket of the client
+func (c *clientBuckets) Allow(key string, now time.Time) (bool, time.Duration) {
+	c.mu.Lock()
+	var b *tokenBucket
+	if e, ok := c.buckets[key]; ok {
+		c.ll.MoveToFront(e)
+		b = e.Value.(*clientBucket).bucket
+	} else {
+		if c.maxClients > 0 && c.ll.Len() >= c.maxClients {
+			oldest := c.ll.Back()
+			c.ll.Remove(oldest)
+			delete(c.buckets, oldest.Value.(*clientBucket).key)
+		}
+		b = newTokenBucket(c.rate, c.capacity, now)
+		c.buckets[key] = c.ll.PushFront(&clientBucket{key: key, bucket: b})
+	}
+	c.mu.Unlock()
+
+	return b.Allow(now)
+}
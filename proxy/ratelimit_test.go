@@ -0,0 +1,175 @@
+package proxy
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/vm-affekt/krakend/config"
+)
+
+func TestNewRateLimitMiddleware_disabled(t *testing.T) {
+	p := NewRateLimitMiddleware(&config.EndpointConfig{})(NoopProxy)
+	for i := 0; i < 100; i++ {
+		if _, err := p(context.Background(), &Request{}); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+		}
+	}
+}
+
+func TestNewRateLimitMiddleware_global(t *testing.T) {
+	endpoint := &config.EndpointConfig{
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				rateLimitKey: map[string]interface{}{
+					"max_rate": 1,
+					"capacity": 3,
+				},
+			},
+		},
+	}
+	p := NewRateLimitMiddleware(endpoint)(NoopProxy)
+	for i := 0; i < 3; i++ {
+		if _, err := p(context.Background(), &Request{}); err != nil {
+			t.Errorf("#%d: unexpected error: %s", i, err.Error())
+		}
+	}
+	_, err := p(context.Background(), &Request{})
+	rlErr, ok := err.(RateLimitError)
+	if !ok {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	if rlErr.StatusCode() != 429 {
+		t.Errorf("unexpected status code: %d", rlErr.StatusCode())
+	}
+	if rlErr.RetryAfter <= 0 || rlErr.RetryAfter > time.Second {
+		t.Errorf("unexpected retry after: %s", rlErr.RetryAfter)
+	}
+	if h := rlErr.Headers()["Retry-After"]; len(h) != 1 || h[0] != "1" {
+		t.Errorf("unexpected headers: %v", rlErr.Headers())
+	}
+}
+
+func TestGetRateLimitCfg_capacity(t *testing.T) {
+	for i, tc := range []struct {
+		cfg            map[string]interface{}
+		capacity       float64
+		clientCapacity float64
+	}{
+		{cfg: map[string]interface{}{"max_rate": 5, "client_max_rate": 2}, capacity: 5, clientCapacity: 2},
+		{cfg: map[string]interface{}{"max_rate": 0.5, "client_max_rate": 0.1}, capacity: 1, clientCapacity: 1},
+		{cfg: map[string]interface{}{"max_rate": 5, "capacity": 0, "client_max_rate": 2, "client_capacity": -3}, capacity: 1, clientCapacity: 1},
+		{cfg: map[string]interface{}{"max_rate": 5, "capacity": 10, "client_max_rate": 2, "client_capacity": 4}, capacity: 10, clientCapacity: 4},
+	} {
+		cfg, ok := getRateLimitCfg(config.ExtraConfig{Namespace: map[string]interface{}{rateLimitKey: tc.cfg}})
+		if !ok {
+			t.Errorf("#%d: the rate limit should be enabled", i)
+			continue
+		}
+		if cfg.Capacity != tc.capacity || cfg.ClientCapacity != tc.clientCapacity {
+			t.Errorf("#%d: unexpected capacities: %v %v", i, cfg.Capacity, cfg.ClientCapacity)
+		}
+	}
+
+	p := NewRateLimitMiddleware(&config.EndpointConfig{
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				rateLimitKey: map[string]interface{}{"max_rate": 1, "capacity": 0},
+			},
+		},
+	})(NoopProxy)
+	if _, err := p(context.Background(), &Request{}); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
+
+func TestNewRateLimitMiddleware_client(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		cfg     map[string]interface{}
+		headers func(client string) map[string][]string
+	}{
+		{
+			name: "ip",
+			cfg:  map[string]interface{}{},
+			headers: func(client string) map[string][]string {
+				return map[string][]string{"X-Forwarded-For": {client}}
+			},
+		},
+		{
+			name: "header",
+			cfg:  map[string]interface{}{"strategy": "header", "key": "X-Api-Key"},
+			headers: func(client string) map[string][]string {
+				return map[string][]string{"X-Api-Key": {client}, "X-Forwarded-For": {"1.1.1.1"}}
+			},
+		},
+		{
+			name: "jwt",
+			cfg:  map[string]interface{}{"strategy": "jwt", "key": "sub"},
+			headers: func(client string) map[string][]string {
+				payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"` + client + `"}`))
+				return map[string][]string{"Authorization": {"Bearer header." + payload + ".signature"}, "X-Forwarded-For": {"1.1.1.1"}}
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.cfg["client_max_rate"] = 0.001
+			tc.cfg["client_capacity"] = 2
+			endpoint := &config.EndpointConfig{
+				ExtraConfig: config.ExtraConfig{
+					Namespace: map[string]interface{}{
+						rateLimitKey: tc.cfg,
+					},
+				},
+			}
+			p := NewRateLimitMiddleware(endpoint)(NoopProxy)
+			for _, client := range []string{"a", "b"} {
+				for i := 0; i < 2; i++ {
+					if _, err := p(context.Background(), &Request{Headers: tc.headers(client)}); err != nil {
+						t.Errorf("%s #%d: unexpected error: %s", client, i, err.Error())
+					}
+				}
+				if _, err := p(context.Background(), &Request{Headers: tc.headers(client)}); err == nil {
+					t.Errorf("%s: the request should be rejected", client)
+				}
+			}
+		})
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(2, 2, now)
+	for i := 0; i < 2; i++ {
+		if ok, _ := b.Allow(now); !ok {
+			t.Errorf("#%d: the token should be available", i)
+		}
+	}
+	ok, wait := b.Allow(now)
+	if ok || wait != 500*time.Millisecond {
+		t.Errorf("unexpected result: %v %s", ok, wait)
+	}
+	if ok, _ := b.Allow(now.Add(500 * time.Millisecond)); !ok {
+		t.Error("the bucket should have been refilled")
+	}
+}
+
+func TestClientBuckets_cleanup(t *testing.T) {
+	now := time.Now()
+	c := newClientBuckets(1, 1, 2)
+	c.Allow("a", now)
+	c.Allow("b", now)
+	c.Allow("a", now)
+	c.Allow("c", now)
+	if _, ok := c.buckets["b"]; ok {
+		t.Error("the least recently used bucket should have been removed")
+	}
+	if len(c.buckets) != 2 || c.ll.Len() != 2 {
+		t.Errorf("unexpected number of buckets: %d", len(c.buckets))
+	}
+	if ok, _ := c.Allow("a", now); ok {
+		t.Error("the bucket of the client a should have been kept")
+	}
+}
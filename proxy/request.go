@@ -37,6 +37,7 @@ func (r *Request) GeneratePath(URLPattern string) {
 // Clone clones itself into a new request. The returned cloned request is not
 // thread-safe, so changes on request.Params and request.Headers could generate
 // race-conditions depending on the part of the pipe they are being executed.
+// Bodies buffered by the body buffering middleware get a new reader.
 // For thread-safe request headers and/or params manipulation, use the proxy.CloneRequest
 // function.
 func (r *Request) Clone() Request {
@@ -45,7 +46,7 @@ func (r *Request) Clone() Request {
 		URL:     r.URL,
 		Query:   r.Query,
 		Path:    r.Path,
-		Body:    r.Body,
+		Body:    cloneBody(r.Body),
 		Params:  r.Params,
 		Headers: r.Headers,
 	}
@@ -0,0 +1,223 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/vm-affekt/krakend/config"
+	"github.com/vm-affekt/krakend/encoding"
+)
+
+const requestBodyKey = "request_body"
+
+// ErrInvalidRequestBody is the error returned when the body of the request can not be transformed
+// because it is not a valid JSON object
+var ErrInvalidRequestBody error = invalidRequestBodyError{}
+
+type invalidRequestBodyError struct{}
+
+// Error implements the error interface
+func (invalidRequestBodyError) Error() string { return "invalid request body" }
+
+// StatusCode returns the status code to send to the client
+func (invalidRequestBodyError) StatusCode() int { return http.StatusBadRequest }
+
+// NewRequestBodyMiddleware creates a proxy middleware that reshapes the JSON body of the requests
+// sent to the backend. The fields are removed, renamed, added from the path params and the query
+// string, and injected (in that order) and, finally, the body can be nested under a key. Fields are
+// referenced with dot separated paths. Bodies with a non JSON content type are not modified.
+//
+// If ignore_body is enabled, the body received from the client is discarded and a new one is built
+// from the params, the query string and the injected values. The GET and HEAD requests are only
+// modified when the body gets fields from the params, the query string or the injected values.
+//
+//	"github.com/vm-affekt/krakend/proxy": {
+//		"request_body": {
+//			"remove": ["password_confirmation"],
+//			"rename": {"user_name": "user.name"},
+//			"params": {"user.id": "id"},
+//			"query": {"page": "p"},
+//			"inject": {"meta.source": "gateway"},
+//			"wrap": "data",
+//			"ignore_body": false
+//		}
+//	}
+func NewRequestBodyMiddleware(remote *config.Backend) Middleware {
+	cfg, ok := getRequestBodyCfg(remote.ExtraConfig)
+	if !ok {
+		return EmptyMiddleware
+	}
+	decoder := encoding.NewJSONDecoder(false)
+
+	return func(next ...Proxy) Proxy {
+		if len(next) > 1 {
+			panic(ErrTooManyProxies)
+		}
+		return func(ctx context.Context, request *Request) (*Response, error) {
+			if isBodylessMethod(request.Method) && !cfg.addsFields() {
+				return next[0](ctx, request)
+			}
+			if ct := getHeader(request.Headers, "Content-Type"); len(ct) > 0 && !strings.Contains(ct[0], "json") && !cfg.IgnoreBody {
+				return next[0](ctx, request)
+			}
+
+			data := map[string]interface{}{}
+			if request.Body != nil {
+				b, err := ioutil.ReadAll(request.Body)
+				request.Body.Close()
+				if err != nil {
+					return nil, err
+				}
+				if len(bytes.TrimSpace(b)) > 0 && !cfg.IgnoreBody {
+					if err := decoder(bytes.NewReader(b), &data); err != nil {
+						return nil, ErrInvalidRequestBody
+					}
+				}
+			}
+
+			body, err := json.Marshal(cfg.transform(data, request))
+			if err != nil {
+				return nil, err
+			}
+
+			r := request.Clone()
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			r.Headers = CloneRequestHeaders(request.Headers)
+			delete(r.Headers, "Content-Length")
+			r.Headers["Content-Type"] = []string{"application/json"}
+			return next[0](ctx, &r)
+		}
+	}
+}
+
+type fieldValue struct {
+	Path  []string
+	Value interface{}
+}
+
+type fieldSource struct {
+	Path []string
+	Name string
+}
+
+type requestBodyConfig struct {
+	Remove     propertyFilter
+	Rename     []fieldMapping
+	Params     []fieldSource
+	Query      []fieldSource
+	Inject     []fieldValue
+	Wrap       string
+	IgnoreBody bool
+}
+
+// addsFields returns true if the body gets fields from the request or from the config
+func (r requestBodyConfig) addsFields() bool {
+	return len(r.Params) > 0 || len(r.Query) > 0 || len(r.Inject) > 0
+}
+
+func isBodylessMethod(method string) bool {
+	switch strings.ToUpper(method) {
+	case http.MethodGet, http.MethodHead:
+		return true
+	}
+	return false
+}
+
+func getRequestBodyCfg(extra config.ExtraConfig) (requestBodyConfig, bool) {
+	tmp, ok := getNamespacedConfig(extra, requestBodyKey)
+	if !ok {
+		return requestBodyConfig{}, false
+	}
+	cfg := requestBodyConfig{
+		Wrap:       getString(tmp, "wrap", ""),
+		IgnoreBody: getBool(tmp, "ignore_body", false),
+	}
+	if remove := getStrings(tmp, "remove"); len(remove) > 0 {
+		cfg.Remove = newBlacklistingFilter(remove)
+	}
+	if rename, ok := tmp["rename"].(map[string]interface{}); ok {
+		for _, from := range sortedKeys(rename) {
+			if to, ok := rename[from].(string); ok && to != "" {
+				cfg.Rename = append(cfg.Rename, fieldMapping{From: strings.Split(from, "."), To: strings.Split(to, ".")})
+			}
+		}
+	}
+	cfg.Params = getFieldSources(tmp, "params")
+	cfg.Query = getFieldSources(tmp, "query")
+	if inject, ok := tmp["inject"].(map[string]interface{}); ok {
+		for _, k := range sortedKeys(inject) {
+			cfg.Inject = append(cfg.Inject, fieldValue{Path: strings.Split(k, "."), Value: inject[k]})
+		}
+	}
+	return cfg, true
+}
+
+func getFieldSources(cfg map[string]interface{}, key string) []fieldSource {
+	tmp, ok := cfg[key].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	res := []fieldSource{}
+	for _, k := range sortedKeys(tmp) {
+		if name, ok := tmp[k].(string); ok && name != "" {
+			res = append(res, fieldSource{Path: strings.Split(k, "."), Name: name})
+		}
+	}
+	return res
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (r requestBodyConfig) transform(data map[string]interface{}, request *Request) map[string]interface{} {
+	if r.Remove != nil {
+		r.Remove(&Response{Data: data})
+	}
+	for _, m := range r.Rename {
+		moveField(data, m.From, m.To)
+	}
+	for _, p := range r.Params {
+		v, ok := request.Params[strings.Title(p.Name)]
+		if !ok {
+			v, ok = request.Params[p.Name]
+		}
+		if ok {
+			setField(data, p.Path, v)
+		}
+	}
+	for _, q := range r.Query {
+		switch vs := request.Query[q.Name]; len(vs) {
+		case 0:
+		case 1:
+			setField(data, q.Path, vs[0])
+		default:
+			values := make([]interface{}, len(vs))
+			for i, v := range vs {
+				values[i] = v
+			}
+			setField(data, q.Path, values)
+		}
+	}
+	for _, i := range r.Inject {
+		setField(data, i.Path, cloneValue(i.Value))
+	}
+	if r.Wrap != "" {
+		return map[string]interface{}{r.Wrap: data}
+	}
+	return data
+}
+
+func setField(data map[string]interface{}, path []string, v interface{}) {
+	buildDictPath(data, path[:len(path)-1])[path[len(path)-1]] = v
+}
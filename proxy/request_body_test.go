@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vm-affekt/krakend/config"
+)
+
+func requestBodyCaptureProxy(t *testing.T, body *map[string]interface{}, headers *map[string][]string) Proxy {
+	return func(_ context.Context, r *Request) (*Response, error) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		if err := json.Unmarshal(b, body); err != nil {
+			t.Errorf("unexpected body '%s': %s", string(b), err.Error())
+		}
+		*headers = r.Headers
+		return &Response{IsComplete: true}, nil
+	}
+}
+
+func TestNewRequestBodyMiddleware_ok(t *testing.T) {
+	var body map[string]interface{}
+	var headers map[string][]string
+	remote := &config.Backend{
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				requestBodyKey: map[string]interface{}{
+					"remove": []interface{}{"password_confirmation", "items.internal"},
+					"rename": map[string]interface{}{"user_name": "user.name"},
+					"params": map[string]interface{}{"user.id": "id"},
+					"query":  map[string]interface{}{"page": "p", "tags": "tag"},
+					"inject": map[string]interface{}{"meta.source": "gateway"},
+					"wrap":   "data",
+				},
+			},
+		},
+	}
+	p := NewRequestBodyMiddleware(remote)(requestBodyCaptureProxy(t, &body, &headers))
+
+	originalHeaders := map[string][]string{"Content-Type": {"application/json; charset=utf-8"}, "Content-Length": {"42"}}
+	_, err := p(context.Background(), &Request{
+		Params:  map[string]string{"Id": "42"},
+		Query:   url.Values{"p": {"2"}, "tag": {"a", "b"}},
+		Headers: originalHeaders,
+		Body:    ioutil.NopCloser(strings.NewReader(`{"user_name":"supu","password_confirmation":"x","items":{"internal":1,"a":2}}`)),
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := map[string]interface{}{
+		"data": map[string]interface{}{
+			"user":  map[string]interface{}{"name": "supu", "id": "42"},
+			"items": map[string]interface{}{"a": float64(2)},
+			"page":  "2",
+			"tags":  []interface{}{"a", "b"},
+			"meta":  map[string]interface{}{"source": "gateway"},
+		},
+	}
+	if !reflect.DeepEqual(body, expected) {
+		t.Errorf("unexpected body: %v", body)
+	}
+	if _, ok := headers["Content-Length"]; ok {
+		t.Errorf("the content length should be removed: %v", headers)
+	}
+	if len(originalHeaders) != 2 {
+		t.Errorf("the headers of the received request have been modified: %v", originalHeaders)
+	}
+}
+
+func TestNewRequestBodyMiddleware_ignoreBody(t *testing.T) {
+	var body map[string]interface{}
+	var headers map[string][]string
+	remote := &config.Backend{
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				requestBodyKey: map[string]interface{}{
+					"params":      map[string]interface{}{"id": "id"},
+					"ignore_body": true,
+				},
+			},
+		},
+	}
+	p := NewRequestBodyMiddleware(remote)(requestBodyCaptureProxy(t, &body, &headers))
+
+	if _, err := p(context.Background(), &Request{
+		Params: map[string]string{"Id": "42"},
+		Body:   ioutil.NopCloser(strings.NewReader(`not a json`)),
+	}); err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(body, map[string]interface{}{"id": "42"}) {
+		t.Errorf("unexpected body: %v", body)
+	}
+	if ct := headers["Content-Type"]; len(ct) != 1 || ct[0] != "application/json" {
+		t.Errorf("unexpected content type: %v", ct)
+	}
+}
+
+func TestNewRequestBodyMiddleware_invalidBody(t *testing.T) {
+	remote := &config.Backend{
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				requestBodyKey: map[string]interface{}{"wrap": "data"},
+			},
+		},
+	}
+	p := NewRequestBodyMiddleware(remote)(explosiveProxy(t))
+	_, err := p(context.Background(), &Request{Body: ioutil.NopCloser(strings.NewReader(`[1, 2`))})
+	if err != ErrInvalidRequestBody {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNewRequestBodyMiddleware_nonJSON(t *testing.T) {
+	remote := &config.Backend{
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				requestBodyKey: map[string]interface{}{"wrap": "data"},
+			},
+		},
+	}
+	p := NewRequestBodyMiddleware(remote)(func(_ context.Context, r *Request) (*Response, error) {
+		b, _ := ioutil.ReadAll(r.Body)
+		if string(b) != "a=1" {
+			t.Errorf("unexpected body: %s", string(b))
+		}
+		return nil, nil
+	})
+	p(context.Background(), &Request{
+		Headers: map[string][]string{"Content-Type": {"application/x-www-form-urlencoded"}},
+		Body:    ioutil.NopCloser(strings.NewReader(`a=1`)),
+	})
+}
+
+func TestNewRequestBodyMiddleware_bodylessMethod(t *testing.T) {
+	remote := &config.Backend{
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				requestBodyKey: map[string]interface{}{"wrap": "data"},
+			},
+		},
+	}
+	p := NewRequestBodyMiddleware(remote)(func(_ context.Context, r *Request) (*Response, error) {
+		if r.Body != nil || len(r.Headers["Content-Type"]) != 0 {
+			t.Errorf("unexpected request: %+v", r)
+		}
+		return nil, nil
+	})
+	for _, method := range []string{"GET", "HEAD"} {
+		p(context.Background(), &Request{Method: method, Headers: map[string][]string{}})
+	}
+
+	var body map[string]interface{}
+	var headers map[string][]string
+	remote.ExtraConfig[Namespace].(map[string]interface{})[requestBodyKey] = map[string]interface{}{
+		"query": map[string]interface{}{"page": "p"},
+	}
+	p = NewRequestBodyMiddleware(remote)(requestBodyCaptureProxy(t, &body, &headers))
+	if _, err := p(context.Background(), &Request{Method: "GET", Query: map[string][]string{"p": {"2"}}}); err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(body, map[string]interface{}{"page": "2"}) {
+		t.Errorf("unexpected body: %v", body)
+	}
+}
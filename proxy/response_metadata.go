@@ -0,0 +1,165 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/textproto"
+
+	"github.com/vm-affekt/krakend/config"
+)
+
+const (
+	responseMetadataKey = "response_metadata"
+	metadataMergeKey    = "metadata_merge"
+
+	metadataPolicyFirst = "first"
+	metadataPolicyMerge = "merge"
+	metadataPolicyWorst = "worst"
+	metadataPolicyNone  = "none"
+)
+
+// NewResponseMetadataParser decorates the received HTTPResponseParser, so the parsed responses keep
+// the status code and the whitelisted headers of the backend response. The routers send them to the
+// client along with the complete responses.
+//
+//	"github.com/vm-affekt/krakend/proxy": {
+//		"response_metadata": {
+//			"headers": ["Location", "ETag"],
+//			"status_code": true
+//		}
+//	}
+func NewResponseMetadataParser(remote *config.Backend, rp HTTPResponseParser) HTTPResponseParser {
+	tmp, ok := getNamespacedConfig(remote.ExtraConfig, responseMetadataKey)
+	if !ok {
+		return rp
+	}
+	headers := getStrings(tmp, "headers")
+	for i, h := range headers {
+		headers[i] = textproto.CanonicalMIMEHeaderKey(h)
+	}
+	withStatus := getBool(tmp, "status_code", false)
+	if len(headers) == 0 && !withStatus {
+		return rp
+	}
+
+	return func(ctx context.Context, resp *http.Response) (*Response, error) {
+		statusCode, header := resp.StatusCode, resp.Header
+		r, err := rp(ctx, resp)
+		if err != nil || r == nil {
+			return r, err
+		}
+		if withStatus {
+			r.Metadata.StatusCode = statusCode
+		}
+		for _, h := range headers {
+			vs, ok := header[h]
+			if !ok {
+				continue
+			}
+			if r.Metadata.Headers == nil {
+				r.Metadata.Headers = map[string][]string{}
+			}
+			r.Metadata.Headers[h] = append([]string{}, vs...)
+		}
+		return r, nil
+	}
+}
+
+// metadataMergePolicy defines how the metadata of the responses of several backends is aggregated.
+// The headers can be taken from the first response, merged or dropped and the status code can be
+// the one of the first response, the worst (highest) one or dropped.
+//
+//	"github.com/vm-affekt/krakend/proxy": {
+//		"metadata_merge": {
+//			"headers": "merge",
+//			"status": "worst"
+//		}
+//	}
+type metadataMergePolicy struct {
+	Headers string
+	Status  string
+}
+
+func getMetadataMergePolicy(extra config.ExtraConfig) metadataMergePolicy {
+	policy := metadataMergePolicy{Headers: metadataPolicyMerge, Status: metadataPolicyWorst}
+	tmp, ok := getNamespacedConfig(extra, metadataMergeKey)
+	if !ok {
+		return policy
+	}
+	policy.Headers = getString(tmp, "headers", policy.Headers)
+	policy.Status = getString(tmp, "status", policy.Status)
+	return policy
+}
+
+// Wrap returns a combiner applying the policy to the metadata of the responses merged by the
+// received one. The degraded responses flag is always kept.
+func (m metadataMergePolicy) Wrap(rc errorAwareCombiner) errorAwareCombiner {
+	return func(total int, parts []*Response) (*Response, error) {
+		metadata := m.merge(parts)
+		degraded := false
+		for _, p := range parts {
+			if p != nil && isDegraded(p) {
+				degraded = true
+			}
+		}
+		res, err := rc(total, parts)
+		if res == nil {
+			return res, err
+		}
+		res.Metadata = metadata
+		if degraded {
+			markDegraded(res)
+		}
+		return res, err
+	}
+}
+
+func (m metadataMergePolicy) merge(parts []*Response) Metadata {
+	metadata := Metadata{}
+	for _, p := range parts {
+		if p == nil {
+			continue
+		}
+		switch m.Status {
+		case metadataPolicyFirst:
+			if metadata.StatusCode == 0 {
+				metadata.StatusCode = p.Metadata.StatusCode
+			}
+		case metadataPolicyWorst:
+			if p.Metadata.StatusCode > metadata.StatusCode {
+				metadata.StatusCode = p.Metadata.StatusCode
+			}
+		}
+
+		switch m.Headers {
+		case metadataPolicyFirst:
+			if metadata.Headers == nil && len(p.Metadata.Headers) > 0 {
+				metadata.Headers = CloneRequestHeaders(p.Metadata.Headers)
+			}
+		case metadataPolicyMerge:
+			for k, vs := range p.Metadata.Headers {
+				if metadata.Headers == nil {
+					metadata.Headers = map[string][]string{}
+				}
+				metadata.Headers[k] = appendMissing(metadata.Headers[k], vs)
+			}
+		}
+	}
+	return metadata
+}
+
+func appendMissing(dst, src []string) []string {
+	for _, v := range src {
+		found := false
+		for _, d := range dst {
+			if d == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			dst = append(dst, v)
+		}
+	}
+	return dst
+}
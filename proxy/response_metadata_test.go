@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vm-affekt/krakend/config"
+	"github.com/vm-affekt/krakend/encoding"
+)
+
+func TestNewResponseMetadataParser(t *testing.T) {
+	backend := &config.Backend{
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				responseMetadataKey: map[string]interface{}{
+					"headers":     []interface{}{"location", "ETag", "X-Missing"},
+					"status_code": true,
+				},
+			},
+		},
+	}
+	rp := NewResponseMetadataParser(backend, DefaultHTTPResponseParserFactory(HTTPResponseParserConfig{
+		encoding.JSONDecoder,
+		EntityFormatterFunc(func(r Response) Response { return r }),
+	}))
+
+	resp, err := rp(context.Background(), &http.Response{
+		StatusCode: http.StatusCreated,
+		Header: http.Header{
+			"Location":   []string{"/users/42"},
+			"Etag":       []string{"abc"},
+			"Set-Cookie": []string{"secret"},
+		},
+		Body: ioutil.NopCloser(bytes.NewBufferString(`{"id":42}`)),
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if resp.Metadata.StatusCode != http.StatusCreated {
+		t.Errorf("unexpected status code: %d", resp.Metadata.StatusCode)
+	}
+	expected := map[string][]string{"Location": {"/users/42"}, "Etag": {"abc"}}
+	if !reflect.DeepEqual(resp.Metadata.Headers, expected) {
+		t.Errorf("unexpected headers: %v", resp.Metadata.Headers)
+	}
+	if !resp.IsComplete || resp.Data["id"] == nil {
+		t.Errorf("unexpected response: %v", resp)
+	}
+}
+
+func TestNewResponseMetadataParser_disabled(t *testing.T) {
+	rp := func(_ context.Context, _ *http.Response) (*Response, error) {
+		return &Response{IsComplete: true}, nil
+	}
+	resp, _ := NewResponseMetadataParser(&config.Backend{}, rp)(context.Background(), &http.Response{
+		StatusCode: http.StatusCreated,
+		Header:     http.Header{"Location": []string{"/users/42"}},
+	})
+	if resp.Metadata.StatusCode != 0 || resp.Metadata.Headers != nil {
+		t.Errorf("unexpected metadata: %v", resp.Metadata)
+	}
+}
+
+func TestNewMergeDataMiddleware_metadataPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		policy          map[string]interface{}
+		expectedStatus  int
+		expectedHeaders map[string][]string
+	}{
+		{
+			name:           "default",
+			expectedStatus: http.StatusAccepted,
+			expectedHeaders: map[string][]string{
+				"Vary":      {"Accept", "Origin"},
+				"X-Backend": {"a"},
+			},
+		},
+		{
+			name:            "first",
+			policy:          map[string]interface{}{"headers": "first", "status": "first"},
+			expectedStatus:  http.StatusOK,
+			expectedHeaders: map[string][]string{"Vary": {"Accept"}},
+		},
+		{
+			name:           "none",
+			policy:         map[string]interface{}{"headers": "none", "status": "none"},
+			expectedStatus: 0,
+		},
+	} {
+		extra := config.ExtraConfig{}
+		if tc.policy != nil {
+			extra[Namespace] = map[string]interface{}{metadataMergeKey: tc.policy}
+		}
+		endpoint := config.EndpointConfig{
+			Backend:     []*config.Backend{{}, {}},
+			Timeout:     time.Duration(100) * time.Millisecond,
+			ExtraConfig: extra,
+		}
+		mw := NewMergeDataMiddleware(&endpoint)
+		p := mw(
+			dummyProxy(&Response{
+				Data:       map[string]interface{}{"a": 1},
+				IsComplete: true,
+				Metadata: Metadata{
+					StatusCode: http.StatusOK,
+					Headers:    map[string][]string{"Vary": {"Accept"}},
+				},
+			}),
+			delayedProxy(t, 10*time.Millisecond, &Response{
+				Data:       map[string]interface{}{"b": 1},
+				IsComplete: true,
+				Metadata: Metadata{
+					StatusCode: http.StatusAccepted,
+					Headers:    map[string][]string{"Vary": {"Accept", "Origin"}, "X-Backend": {"a"}},
+				},
+			}),
+		)
+		out, err := p(context.Background(), &Request{})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.name, err.Error())
+			continue
+		}
+		if len(out.Data) != 2 || !out.IsComplete {
+			t.Errorf("%s: unexpected response: %v", tc.name, out)
+		}
+		if out.Metadata.StatusCode != tc.expectedStatus {
+			t.Errorf("%s: unexpected status code: %d", tc.name, out.Metadata.StatusCode)
+		}
+		if !reflect.DeepEqual(out.Metadata.Headers, tc.expectedHeaders) {
+			t.Errorf("%s: unexpected headers: %v", tc.name, out.Metadata.Headers)
+		}
+	}
+}
+
+func TestMetadataMergePolicy_keepsDegraded(t *testing.T) {
+	degraded := &Response{Data: map[string]interface{}{"a": 1}}
+	markDegraded(degraded)
+	policy := metadataMergePolicy{Headers: metadataPolicyNone, Status: metadataPolicyNone}
+	res, _ := policy.Wrap(newErrorAwareCombiner(combineData))(2, []*Response{
+		degraded,
+		{Data: map[string]interface{}{"b": 1}},
+	})
+	if !isDegraded(res) {
+		t.Errorf("the degraded flag has been lost: %v", res.Metadata)
+	}
+}
@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vm-affekt/krakend/config"
+)
+
+const retryKey = "retry"
+
+// NewRetryMiddleware creates a proxy middleware that re-executes the request when the backend call
+// fails because of a network error or when it returns one of the configured status codes. The
+// delay between attempts grows exponentially (with full jitter) and the middleware never sleeps
+// beyond the deadline of the received context. Every attempt asks the balancer for a host not used
+// by the previous ones, so the middleware must wrap the load balancing one.
+//
+// By default, only idempotent methods are retried. The request bodies are kept in memory up to the max
+// size of the body buffering options of the backend and the requests with bigger bodies are not
+// retried. Status codes are read from the response metadata
+// (see the return_error_details option of the http client) or from the errors exposing the status
+// code of the backend, like the ones returned by the status handlers of the http client.
+//
+//	"github.com/vm-affekt/krakend/proxy": {
+//		"retry": {
+//			"max_retries": 3,
+//			"backoff": "50ms",
+//			"max_backoff": "1s",
+//			"status_codes": [502, 503, 504],
+//			"all_methods": false
+//		}
+//	}
+func NewRetryMiddleware(remote *config.Backend) Middleware {
+	cfg, ok := getRetryCfg(remote.ExtraConfig)
+	if !ok {
+		return EmptyMiddleware
+	}
+	maxSize := getBodyMaxSize(remote.ExtraConfig)
+	return func(next ...Proxy) Proxy {
+		if len(next) > 1 {
+			panic(ErrTooManyProxies)
+		}
+		return func(ctx context.Context, request *Request) (*Response, error) {
+			if !cfg.AllMethods && !isIdempotentMethod(request.Method) {
+				return next[0](ctx, request)
+			}
+
+			request, ok, err := bufferRequestBody(request, maxSize)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				// the body can not be replayed, so the request is not retried
+				return next[0](ctx, request)
+			}
+
+			ctx = withHostTracker(ctx)
+
+			var result *Response
+			for attempt := 0; ; attempt++ {
+				r := request.Clone()
+
+				result, err = next[0](ctx, &r)
+				if attempt >= cfg.MaxRetries || !cfg.shouldRetry(result, err) || ctx.Err() != nil {
+					return result, err
+				}
+
+				wait := cfg.backoff(attempt)
+				if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= wait {
+					return result, err
+				}
+
+				select {
+				case <-ctx.Done():
+					return result, err
+				case <-time.After(wait):
+				}
+			}
+		}
+	}
+}
+
+type retryConfig struct {
+	MaxRetries  int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	StatusCodes map[int]struct{}
+	AllMethods  bool
+}
+
+func getRetryCfg(extra config.ExtraConfig) (retryConfig, bool) {
+	tmp, ok := getNamespacedConfig(extra, retryKey)
+	if !ok {
+		return retryConfig{}, false
+	}
+	cfg := retryConfig{
+		MaxRetries:  getInt(tmp, "max_retries", 2),
+		Backoff:     getDuration(tmp, "backoff", 50*time.Millisecond),
+		MaxBackoff:  getDuration(tmp, "max_backoff", time.Second),
+		StatusCodes: map[int]struct{}{},
+		AllMethods:  getBool(tmp, "all_methods", false),
+	}
+	for _, code := range getInts(tmp, "status_codes") {
+		cfg.StatusCodes[code] = struct{}{}
+	}
+	return cfg, cfg.MaxRetries > 0
+}
+
+// backoff returns a random delay between 0 and the exponential backoff of the attempt
+func (r retryConfig) backoff(attempt int) time.Duration {
+	d := r.Backoff << uint(attempt)
+	if d <= 0 || (r.MaxBackoff > 0 && d > r.MaxBackoff) {
+		d = r.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+func (r retryConfig) shouldRetry(result *Response, err error) bool {
+	if err != nil {
+		if t, ok := err.(backendStatusError); ok {
+			_, ok = r.StatusCodes[t.BackendStatusCode()]
+			return ok
+		}
+		if t, ok := err.(responseError); ok {
+			_, ok = r.StatusCodes[t.StatusCode()]
+			return ok
+		}
+		return isNetworkError(err)
+	}
+	if result == nil {
+		return false
+	}
+	_, ok := r.StatusCodes[result.Metadata.StatusCode]
+	return ok
+}
+
+func isNetworkError(err error) bool {
+	switch err.(type) {
+	case *url.Error, net.Error:
+		return true
+	}
+	return false
+}
+
+func isIdempotentMethod(method string) bool {
+	switch strings.ToUpper(method) {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace, "":
+		return true
+	}
+	return false
+}
+
+type backendStatusError interface {
+	error
+	BackendStatusCode() int
+}
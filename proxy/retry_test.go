@@ -0,0 +1,285 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/vm-affekt/krakend/config"
+	"github.com/vm-affekt/krakend/encoding"
+	"github.com/vm-affekt/krakend/sd"
+	"github.com/vm-affekt/krakend/transport/http/client"
+)
+
+func TestNewRetryMiddleware_disabled(t *testing.T) {
+	calls := 0
+	p := NewRetryMiddleware(&config.Backend{})(func(_ context.Context, _ *Request) (*Response, error) {
+		calls++
+		return nil, &url.Error{Op: "Get", URL: "http://example.com", Err: errors.New("connection refused")}
+	})
+	if _, err := p(context.Background(), &Request{Method: "GET"}); err == nil {
+		t.Error("expecting an error")
+	}
+	if calls != 1 {
+		t.Errorf("unexpected number of calls: %d", calls)
+	}
+}
+
+func TestNewRetryMiddleware_hostFailover(t *testing.T) {
+	backend := &config.Backend{
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				retryKey: map[string]interface{}{
+					"max_retries": 2,
+					"backoff":     "1ms",
+				},
+			},
+		},
+	}
+	hosts := []string{}
+	failing := map[string]bool{"http://a": true, "http://b": true}
+	lb := sd.NewRandomLB(sd.FixedSubscriber{"http://a", "http://b", "http://c"}, 1)
+
+	p := NewRetryMiddleware(backend)(newLoadBalancedMiddleware(lb)(func(_ context.Context, r *Request) (*Response, error) {
+		host := r.URL.Scheme + "://" + r.URL.Host
+		hosts = append(hosts, host)
+		if failing[host] {
+			return nil, &url.Error{Op: "Get", URL: host, Err: errors.New("connection refused")}
+		}
+		return &Response{IsComplete: true}, nil
+	}))
+
+	resp, err := p(context.Background(), &Request{Method: "GET", Path: "/supu", Body: newDummyReadCloser("")})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if resp == nil || !resp.IsComplete {
+		t.Errorf("unexpected response: %v", resp)
+	}
+	seen := map[string]bool{}
+	for _, h := range hosts {
+		if seen[h] {
+			t.Errorf("host %s used twice: %v", h, hosts)
+		}
+		seen[h] = true
+	}
+}
+
+func TestNewRetryMiddleware_consistentHashFailover(t *testing.T) {
+	backend := &config.Backend{
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				retryKey: map[string]interface{}{
+					"max_retries": 1,
+					"backoff":     "1ms",
+				},
+			},
+		},
+	}
+	hosts := []string{}
+	lb := sd.NewConsistentHashLB(sd.FixedSubscriber{"http://a", "http://b"}, sd.NewHashKeyFunc("path"), 0)
+
+	p := NewRetryMiddleware(backend)(newLoadBalancedMiddleware(lb)(func(_ context.Context, r *Request) (*Response, error) {
+		host := r.URL.Scheme + "://" + r.URL.Host
+		hosts = append(hosts, host)
+		if len(hosts) == 1 {
+			return nil, &url.Error{Op: "Get", URL: host, Err: errors.New("connection refused")}
+		}
+		return &Response{IsComplete: true}, nil
+	}))
+
+	if _, err := p(context.Background(), &Request{Method: "GET", Path: "/supu", Body: newDummyReadCloser("")}); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if len(hosts) != 2 || hosts[0] == hosts[1] {
+		t.Errorf("the retry has not failed over: %v", hosts)
+	}
+}
+
+func TestNewRetryMiddleware_statusCodes(t *testing.T) {
+	backend := &config.Backend{
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				retryKey: map[string]interface{}{
+					"max_retries":  3,
+					"backoff":      "1ms",
+					"status_codes": []interface{}{503.0},
+				},
+			},
+		},
+	}
+	calls := 0
+	p := NewRetryMiddleware(backend)(func(_ context.Context, _ *Request) (*Response, error) {
+		calls++
+		if calls < 3 {
+			return &Response{Metadata: Metadata{StatusCode: 503}}, nil
+		}
+		return &Response{IsComplete: true, Metadata: Metadata{StatusCode: 200}}, nil
+	})
+	resp, err := p(context.Background(), &Request{Method: "GET"})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if resp.Metadata.StatusCode != 200 || calls != 3 {
+		t.Errorf("unexpected result after %d calls: %v", calls, resp)
+	}
+}
+
+func TestNewRetryMiddleware_mappedStatusCodes(t *testing.T) {
+	backend := &config.Backend{
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				retryKey: map[string]interface{}{
+					"max_retries":  3,
+					"backoff":      "1ms",
+					"status_codes": []interface{}{503.0},
+				},
+			},
+		},
+	}
+	calls := 0
+	p := NewRetryMiddleware(backend)(func(_ context.Context, _ *Request) (*Response, error) {
+		calls++
+		if calls < 3 {
+			return nil, client.InvalidStatusCodeError{Code: 503, Status: 502}
+		}
+		return &Response{IsComplete: true}, nil
+	})
+	resp, err := p(context.Background(), &Request{Method: "GET"})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if resp == nil || !resp.IsComplete || calls != 3 {
+		t.Errorf("unexpected result after %d calls: %v", calls, resp)
+	}
+}
+
+func TestNewRetryMiddleware_defaultStatusHandler(t *testing.T) {
+	calls := 0
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"supu":"tupu"}`))
+	}))
+	defer backendServer.Close()
+
+	backend := &config.Backend{
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				retryKey: map[string]interface{}{
+					"max_retries":  3,
+					"backoff":      "1ms",
+					"status_codes": []interface{}{503.0},
+				},
+			},
+		},
+	}
+	backend.Decoder = encoding.JSONDecoder
+	rpURL, _ := url.Parse(backendServer.URL)
+
+	p := NewRetryMiddleware(backend)(httpProxy(backend))
+	resp, err := p(context.Background(), &Request{Method: "GET", URL: rpURL, Body: newDummyReadCloser("")})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if resp == nil || resp.Data["supu"] != "tupu" || calls != 3 {
+		t.Errorf("unexpected result after %d calls: %v", calls, resp)
+	}
+}
+
+func TestNewRetryMiddleware_nonIdempotent(t *testing.T) {
+	backend := &config.Backend{
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				retryKey: map[string]interface{}{"max_retries": 3, "backoff": "1ms"},
+			},
+		},
+	}
+	calls := 0
+	p := NewRetryMiddleware(backend)(func(_ context.Context, _ *Request) (*Response, error) {
+		calls++
+		return nil, &url.Error{Op: "Post", URL: "http://example.com", Err: errors.New("connection refused")}
+	})
+	p(context.Background(), &Request{Method: "POST"})
+	if calls != 1 {
+		t.Errorf("non idempotent methods should not be retried. calls: %d", calls)
+	}
+}
+
+func TestNewRetryMiddleware_bodyIsReplayed(t *testing.T) {
+	backend := &config.Backend{
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				retryKey: map[string]interface{}{"max_retries": 1, "backoff": "1ms", "all_methods": true},
+			},
+		},
+	}
+	bodies := []string{}
+	p := NewRetryMiddleware(backend)(func(_ context.Context, r *Request) (*Response, error) {
+		b := make([]byte, 4)
+		n, _ := r.Body.Read(b)
+		bodies = append(bodies, string(b[:n]))
+		return nil, &url.Error{Op: "Post", URL: "http://example.com", Err: errors.New("connection refused")}
+	})
+	p(context.Background(), &Request{Method: "POST", Body: newDummyReadCloser("supu")})
+	if len(bodies) != 2 || bodies[0] != "supu" || bodies[1] != "supu" {
+		t.Errorf("unexpected bodies: %v", bodies)
+	}
+}
+
+func TestNewRetryMiddleware_bodyTooLarge(t *testing.T) {
+	backend := &config.Backend{
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				retryKey:         map[string]interface{}{"max_retries": 1, "backoff": "1ms", "all_methods": true},
+				bodyBufferingKey: map[string]interface{}{"max_size": 2},
+			},
+		},
+	}
+	bodies := []string{}
+	p := NewRetryMiddleware(backend)(func(_ context.Context, r *Request) (*Response, error) {
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		return nil, &url.Error{Op: "Post", URL: "http://example.com", Err: errors.New("connection refused")}
+	})
+	p(context.Background(), &Request{Method: "POST", Body: newDummyReadCloser("supu")})
+	if len(bodies) != 1 || bodies[0] != "supu" {
+		t.Errorf("unexpected bodies: %v", bodies)
+	}
+}
+
+func TestNewRetryMiddleware_deadline(t *testing.T) {
+	backend := &config.Backend{
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				retryKey: map[string]interface{}{"max_retries": 5, "backoff": "1s", "max_backoff": "10s"},
+			},
+		},
+	}
+	calls := 0
+	p := NewRetryMiddleware(backend)(func(_ context.Context, _ *Request) (*Response, error) {
+		calls++
+		return nil, &url.Error{Op: "Get", URL: "http://example.com", Err: errors.New("connection refused")}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	if _, err := p(ctx, &Request{Method: "GET"}); err == nil {
+		t.Error("expecting an error")
+	}
+	if time.Since(begin) > 100*time.Millisecond {
+		t.Errorf("the middleware did not respect the deadline: %s", time.Since(begin))
+	}
+}
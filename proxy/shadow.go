@@ -2,16 +2,37 @@ package proxy
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/vm-affekt/krakend/config"
+	"github.com/vm-affekt/krakend/logging"
 )
 
 const (
 	shadowKey = "shadow"
+
+	shadowCompareStatus = "status"
+	shadowCompareFields = "fields"
+	shadowCompareData   = "data"
+
+	defaultShadowWorkers   = 10
+	defaultShadowQueueSize = 100
 )
 
 type shadowFactory struct {
-	f Factory
+	f        Factory
+	logger   logging.Logger
+	counters *shadowCounterSet
 }
 
 // New check the Backends for an ExtraConfig with the "shadow" param to true
@@ -40,7 +61,9 @@ func (s shadowFactory) New(cfg *config.EndpointConfig) (p Proxy, err error) {
 	if len(shadow) > 0 {
 		cfg.Backend = shadow
 		pShadow, _ := s.f.New(cfg)
-		p = ShadowMiddleware(p, pShadow)
+		sCfg := getShadowCfg(shadow, cfg.Timeout)
+		p = newShadowProxy(p, pShadow, sCfg, newShadowReporter(cfg.Endpoint, s.logger, s.counters.get(cfg.Endpoint)))
+		p = NewBodyBufferingMiddleware(cfg)(p)
 	}
 
 	return
@@ -48,7 +71,36 @@ func (s shadowFactory) New(cfg *config.EndpointConfig) (p Proxy, err error) {
 
 // NewShadowFactory creates a new shadowFactory using the provided Factory
 func NewShadowFactory(f Factory) Factory {
-	return shadowFactory{f}
+	return NewShadowFactoryWithLogger(f, logging.NoOp)
+}
+
+// NewShadowFactoryWithLogger creates a new shadowFactory using the provided Factory. The differences
+// between the responses of the regular and the shadow backends are reported through the logger and
+// the counters of the factory (see ShadowCountersReporter).
+//
+// The shadow param accepts a map with the comparison options instead of a boolean. The compare
+// option can be status, fields (the ones listed in the fields option) or data. The ignored paths
+// are dot separated paths where '*' matches any key or array index.
+//
+//	"github.com/vm-affekt/krakend/proxy": {
+//		"shadow": {
+//			"compare": "data",
+//			"fields": ["user.id"],
+//			"ignored_paths": ["timestamp", "items.*.updated_at"],
+//			"sampling": 10,
+//			"workers": 10,
+//			"queue_size": 100,
+//			"timeout": "1s"
+//		}
+//	}
+func NewShadowFactoryWithLogger(f Factory, logger logging.Logger) Factory {
+	return shadowFactory{f, logger, newShadowCounterSet()}
+}
+
+// ShadowCounters returns a snapshot of the counters of the shadow traffic of the endpoints created
+// by the factory, indexed by endpoint
+func (s shadowFactory) ShadowCounters() map[string]ShadowCounters {
+	return s.counters.snapshot()
 }
 
 // ShadowMiddleware is a Middleware that creates a shadowProxy
@@ -66,26 +118,338 @@ func ShadowMiddleware(next ...Proxy) Proxy {
 }
 
 // NewShadowProxy returns a Proxy that sends requests to p1 and p2 but ignores
-// the response of p2
+// the response of p2. The requests to p2 are executed by a bounded pool of workers
+// and they are dropped when the pool is saturated. The workers only live while
+// they have a request to execute.
 func NewShadowProxy(p1, p2 Proxy) Proxy {
-	return func(ctx context.Context, request *Request) (*Response, error) {
-		go p2(newcontextWrapper(ctx), CloneRequest(request))
-		return p1(ctx, request)
-	}
+	return newShadowProxy(p1, p2, defaultShadowConfig(), nil)
 }
 
 func isShadowBackend(c *config.Backend) bool {
 	if v, ok := c.ExtraConfig[Namespace]; ok {
 		if e, ok := v.(map[string]interface{}); ok {
 			if v, ok := e[shadowKey]; ok {
-				c, ok := v.(bool)
-				return ok && c
+				switch t := v.(type) {
+				case bool:
+					return t
+				case map[string]interface{}:
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+type shadowConfig struct {
+	Sampling     float64
+	Compare      string
+	Fields       [][]string
+	IgnoredPaths [][]string
+	Workers      int
+	QueueSize    int
+	Timeout      time.Duration
+}
+
+func defaultShadowConfig() shadowConfig {
+	return shadowConfig{
+		Sampling:  100,
+		Workers:   defaultShadowWorkers,
+		QueueSize: defaultShadowQueueSize,
+	}
+}
+
+// getShadowCfg returns the options declared by the first shadow backend with a map of options
+func getShadowCfg(backends []*config.Backend, timeout time.Duration) shadowConfig {
+	cfg := defaultShadowConfig()
+	cfg.Timeout = timeout
+	for _, b := range backends {
+		tmp, ok := getNamespacedConfig(b.ExtraConfig, shadowKey)
+		if !ok {
+			continue
+		}
+		cfg.Sampling = getFloat(tmp, "sampling", cfg.Sampling)
+		cfg.Compare = getString(tmp, "compare", shadowCompareData)
+		for _, f := range getStrings(tmp, "fields") {
+			cfg.Fields = append(cfg.Fields, strings.Split(f, "."))
+		}
+		for _, f := range getStrings(tmp, "ignored_paths") {
+			cfg.IgnoredPaths = append(cfg.IgnoredPaths, strings.Split(f, "."))
+		}
+		cfg.Workers = getInt(tmp, "workers", cfg.Workers)
+		cfg.QueueSize = getInt(tmp, "queue_size", cfg.QueueSize)
+		cfg.Timeout = getDuration(tmp, "timeout", cfg.Timeout)
+		break
+	}
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	if cfg.QueueSize < 0 {
+		cfg.QueueSize = 0
+	}
+	return cfg
+}
+
+func (s shadowConfig) sample() bool {
+	return s.Sampling >= 100 || rand.Float64()*100 < s.Sampling
+}
+
+type shadowResult struct {
+	resp *Response
+	err  error
+}
+
+// errShadowPrimaryAborted is sent to the shadow workers when the primary proxy panics, so they
+// can release their slots without comparing the results
+var errShadowPrimaryAborted = errors.New("the primary request was aborted")
+
+type shadowJob struct {
+	ctx     context.Context
+	request *Request
+	primary chan shadowResult
+}
+
+func newShadowProxy(p1, p2 Proxy, cfg shadowConfig, reporter *shadowReporter) Proxy {
+	// accepted holds a slot per queued or running job, while running limits the concurrent ones
+	accepted := make(chan struct{}, cfg.Workers+cfg.QueueSize)
+	running := make(chan struct{}, cfg.Workers)
+	compare := cfg.Compare != "" && reporter != nil
+
+	return func(ctx context.Context, request *Request) (*Response, error) {
+		if !cfg.sample() {
+			return p1(ctx, request)
+		}
+		job := shadowJob{ctx: newcontextWrapper(ctx), request: CloneRequest(request)}
+		if compare {
+			job.primary = make(chan shadowResult, 1)
+		}
+		select {
+		case accepted <- struct{}{}:
+			reporter.mirrored()
+			go func() {
+				running <- struct{}{}
+				runShadowJob(p2, cfg, reporter, job)
+				<-running
+				<-accepted
+			}()
+		default:
+			reporter.dropped()
+			return p1(ctx, request)
+		}
+
+		if job.primary == nil {
+			return p1(ctx, request)
+		}
+		// the result is sent from a defer, so the worker waiting for it is always released
+		result := shadowResult{err: errShadowPrimaryAborted}
+		defer func() { job.primary <- result }()
+		resp, err := p1(ctx, request)
+		result = shadowResult{CloneResponse(resp), err}
+		return resp, err
+	}
+}
+
+func runShadowJob(p Proxy, cfg shadowConfig, reporter *shadowReporter, job shadowJob) {
+	ctx, cancel := job.ctx, func() {}
+	if cfg.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(job.ctx, cfg.Timeout)
+	}
+	resp, err := p(ctx, job.request)
+	cancel()
+
+	if job.primary == nil {
+		return
+	}
+	primary := <-job.primary
+	if primary.err == errShadowPrimaryAborted {
+		return
+	}
+	reporter.report(err, compareShadowResults(cfg, primary, shadowResult{resp, err}))
+}
+
+// compareShadowResults returns the list of differences between the primary and the shadow results
+func compareShadowResults(cfg shadowConfig, primary, shadow shadowResult) []string {
+	diffs := []string{}
+	if a, b := shadowStatus(primary), shadowStatus(shadow); a != b {
+		diffs = append(diffs, fmt.Sprintf("status (%d != %d)", a, b))
+	}
+	if cfg.Compare == shadowCompareStatus || primary.resp == nil || shadow.resp == nil {
+		return diffs
+	}
+
+	if cfg.Compare == shadowCompareFields {
+		for _, f := range cfg.Fields {
+			a, okA := lookupPath(primary.resp.Data, f)
+			b, okB := lookupPath(shadow.resp.Data, f)
+			if okA != okB || !reflect.DeepEqual(a, b) {
+				diffs = append(diffs, strings.Join(f, "."))
 			}
 		}
+		return diffs
+	}
+
+	dataDiffs := diffShadowData([]string{}, primary.resp.Data, shadow.resp.Data, cfg.IgnoredPaths, []string{})
+	sort.Strings(dataDiffs)
+	return append(diffs, dataDiffs...)
+}
+
+func shadowStatus(r shadowResult) int {
+	if r.err != nil {
+		if t, ok := r.err.(responseError); ok {
+			return t.StatusCode()
+		}
+		return http.StatusInternalServerError
+	}
+	if r.resp == nil || r.resp.Metadata.StatusCode == 0 {
+		return http.StatusOK
+	}
+	return r.resp.Metadata.StatusCode
+}
+
+func diffShadowData(path []string, a, b interface{}, ignored [][]string, diffs []string) []string {
+	if isIgnoredShadowPath(path, ignored) {
+		return diffs
+	}
+	switch ta := a.(type) {
+	case map[string]interface{}:
+		tb, ok := b.(map[string]interface{})
+		if !ok {
+			return append(diffs, strings.Join(path, "."))
+		}
+		for k, va := range ta {
+			diffs = diffShadowData(append(path[:len(path):len(path)], k), va, tb[k], ignored, diffs)
+		}
+		for k, vb := range tb {
+			if _, ok := ta[k]; !ok {
+				diffs = diffShadowData(append(path[:len(path):len(path)], k), nil, vb, ignored, diffs)
+			}
+		}
+	case []interface{}:
+		tb, ok := b.([]interface{})
+		if !ok || len(ta) != len(tb) {
+			return append(diffs, strings.Join(path, "."))
+		}
+		for i := range ta {
+			diffs = diffShadowData(append(path[:len(path):len(path)], strconv.Itoa(i)), ta[i], tb[i], ignored, diffs)
+		}
+	default:
+		if !reflect.DeepEqual(a, b) {
+			diffs = append(diffs, strings.Join(path, "."))
+		}
+	}
+	return diffs
+}
+
+func isIgnoredShadowPath(path []string, ignored [][]string) bool {
+	for _, pattern := range ignored {
+		if len(pattern) != len(path) {
+			continue
+		}
+		match := true
+		for i, p := range pattern {
+			if p != pathWildcard && p != path[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
 	}
 	return false
 }
 
+// ShadowCounters contains the counters of the shadow traffic of an endpoint
+type ShadowCounters struct {
+	// Mirrored is the number of requests sent to the shadow backends
+	Mirrored uint64
+	// Dropped is the number of sampled requests not mirrored because the worker pool was saturated
+	Dropped uint64
+	// Failed is the number of shadow requests returning an error
+	Failed uint64
+	// Matched is the number of shadow responses equivalent to the primary ones
+	Matched uint64
+	// Mismatched is the number of shadow responses different from the primary ones
+	Mismatched uint64
+}
+
+// ShadowCountersReporter is implemented by the factories reporting the counters of the shadow traffic
+type ShadowCountersReporter interface {
+	ShadowCounters() map[string]ShadowCounters
+}
+
+// shadowCounterSet keeps the counters of the endpoints created by a factory
+type shadowCounterSet struct {
+	mu   sync.RWMutex
+	data map[string]*ShadowCounters
+}
+
+func newShadowCounterSet() *shadowCounterSet {
+	return &shadowCounterSet{data: map[string]*ShadowCounters{}}
+}
+
+func (s *shadowCounterSet) get(name string) *ShadowCounters {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.data[name]
+	if !ok {
+		c = &ShadowCounters{}
+		s.data[name] = c
+	}
+	return c
+}
+
+func (s *shadowCounterSet) snapshot() map[string]ShadowCounters {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make(map[string]ShadowCounters, len(s.data))
+	for k, c := range s.data {
+		res[k] = ShadowCounters{
+			Mirrored:   atomic.LoadUint64(&c.Mirrored),
+			Dropped:    atomic.LoadUint64(&c.Dropped),
+			Failed:     atomic.LoadUint64(&c.Failed),
+			Matched:    atomic.LoadUint64(&c.Matched),
+			Mismatched: atomic.LoadUint64(&c.Mismatched),
+		}
+	}
+	return res
+}
+
+type shadowReporter struct {
+	name     string
+	logger   logging.Logger
+	counters *ShadowCounters
+}
+
+func newShadowReporter(name string, logger logging.Logger, counters *ShadowCounters) *shadowReporter {
+	return &shadowReporter{name: name, logger: logger, counters: counters}
+}
+
+func (s *shadowReporter) mirrored() {
+	if s != nil {
+		atomic.AddUint64(&s.counters.Mirrored, 1)
+	}
+}
+
+func (s *shadowReporter) dropped() {
+	if s != nil {
+		atomic.AddUint64(&s.counters.Dropped, 1)
+	}
+}
+
+func (s *shadowReporter) report(err error, diffs []string) {
+	if err != nil {
+		atomic.AddUint64(&s.counters.Failed, 1)
+		s.logger.Debug("shadow:", s.name, "the shadow request failed:", err.Error())
+	}
+	if len(diffs) == 0 {
+		atomic.AddUint64(&s.counters.Matched, 1)
+		return
+	}
+	atomic.AddUint64(&s.counters.Mismatched, 1)
+	s.logger.Warning("shadow:", s.name, "the responses differ at", strings.Join(diffs, ", "))
+}
+
 type contextWrapper struct {
 	context.Context
 	data context.Context
@@ -163,3 +163,199 @@ func TestShadowMiddleware_partialTimeout(t *testing.T) {
 		return
 	}
 }
+
+func TestIsShadowBackend_withOptions(t *testing.T) {
+	cfg := &config.Backend{ExtraConfig: config.ExtraConfig{
+		Namespace: map[string]interface{}{shadowKey: map[string]interface{}{"sampling": 10}},
+	}}
+	if !isShadowBackend(cfg) {
+		t.Error("The shadow backend should be true")
+	}
+}
+
+func TestNewShadowFactoryWithLogger_comparison(t *testing.T) {
+	buff := bytes.NewBuffer(make([]byte, 1024))
+	logger, err := logging.NewLogger("WARNING", buff, "pref")
+	if err != nil {
+		t.Error("building the logger:", err.Error())
+		return
+	}
+	primary := &config.Backend{URLPattern: "/primary", Host: []string{"dummy"}}
+	shadow := &config.Backend{
+		URLPattern: "/shadow",
+		Host:       []string{"dummy"},
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{shadowKey: map[string]interface{}{
+				"ignored_paths": []interface{}{"items.*.ts"},
+			}},
+		},
+	}
+	var calls uint64
+	factory := NewDefaultFactory(func(b *config.Backend) Proxy {
+		return func(_ context.Context, _ *Request) (*Response, error) {
+			n := atomic.AddUint64(&calls, 1)
+			if b.URLPattern == "/primary" {
+				return &Response{Data: map[string]interface{}{
+					"id":    1,
+					"items": []interface{}{map[string]interface{}{"ts": n}},
+				}, IsComplete: true}, nil
+			}
+			return &Response{Data: map[string]interface{}{
+				"id":    2,
+				"extra": true,
+				"items": []interface{}{map[string]interface{}{"ts": n}},
+			}, IsComplete: true}, nil
+		}
+	}, logger)
+	endpoint := &config.EndpointConfig{
+		Endpoint: "/shadow/compare",
+		Timeout:  time.Second,
+		Backend:  []*config.Backend{primary, shadow},
+	}
+	shadowFactory := NewShadowFactoryWithLogger(factory, logger)
+	p, err := shadowFactory.New(endpoint)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := p(context.Background(), &Request{}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var counters ShadowCounters
+	for i := 0; i < 100; i++ {
+		counters = shadowFactory.(ShadowCountersReporter).ShadowCounters()["/shadow/compare"]
+		if counters.Mismatched > 0 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if counters.Mirrored != 1 || counters.Mismatched != 1 || counters.Matched != 0 {
+		t.Errorf("unexpected counters: %+v", counters)
+	}
+}
+
+func TestCompareShadowResults(t *testing.T) {
+	primary := shadowResult{resp: &Response{Data: map[string]interface{}{
+		"user":  map[string]interface{}{"id": 1, "name": "a"},
+		"items": []interface{}{map[string]interface{}{"id": 1, "ts": 1}},
+		"ts":    1,
+	}}}
+	shadow := shadowResult{resp: &Response{Data: map[string]interface{}{
+		"user":  map[string]interface{}{"id": 1, "name": "b"},
+		"items": []interface{}{map[string]interface{}{"id": 1, "ts": 2}},
+		"ts":    2,
+		"new":   true,
+	}}}
+	for i, tc := range []struct {
+		cfg   shadowConfig
+		diffs []string
+	}{
+		{
+			cfg:   shadowConfig{Compare: shadowCompareData},
+			diffs: []string{"items.0.ts", "new", "ts", "user.name"},
+		},
+		{
+			cfg: shadowConfig{
+				Compare:      shadowCompareData,
+				IgnoredPaths: [][]string{{"items", "*", "ts"}, {"ts"}, {"new"}},
+			},
+			diffs: []string{"user.name"},
+		},
+		{
+			cfg:   shadowConfig{Compare: shadowCompareFields, Fields: [][]string{{"user", "id"}, {"ts"}}},
+			diffs: []string{"ts"},
+		},
+		{
+			cfg:   shadowConfig{Compare: shadowCompareStatus},
+			diffs: []string{},
+		},
+	} {
+		diffs := compareShadowResults(tc.cfg, primary, shadow)
+		if len(diffs) != len(tc.diffs) {
+			t.Errorf("#%d: unexpected diffs: %v", i, diffs)
+			continue
+		}
+		for j := range diffs {
+			if diffs[j] != tc.diffs[j] {
+				t.Errorf("#%d: unexpected diffs: %v", i, diffs)
+				break
+			}
+		}
+	}
+
+	diffs := compareShadowResults(shadowConfig{Compare: shadowCompareData}, primary, shadowResult{err: errors.New("boom")})
+	if len(diffs) != 1 || diffs[0] != "status (200 != 500)" {
+		t.Errorf("unexpected diffs: %v", diffs)
+	}
+}
+
+func TestNewShadowProxy_saturatedPool(t *testing.T) {
+	block := make(chan struct{})
+	var calls uint64
+	cfg := shadowConfig{Sampling: 100, Workers: 1, QueueSize: 1}
+	reporter := &shadowReporter{name: "test", logger: logging.NoOp, counters: &ShadowCounters{}}
+	p := newShadowProxy(
+		dummyProxy(&Response{IsComplete: true}),
+		func(_ context.Context, _ *Request) (*Response, error) {
+			atomic.AddUint64(&calls, 1)
+			<-block
+			return nil, nil
+		},
+		cfg,
+		reporter,
+	)
+	for i := 0; i < 5; i++ {
+		p(context.Background(), &Request{})
+	}
+	close(block)
+	if mirrored, dropped := atomic.LoadUint64(&reporter.counters.Mirrored), atomic.LoadUint64(&reporter.counters.Dropped); mirrored+dropped != 5 || mirrored > 2 {
+		t.Errorf("unexpected counters. mirrored: %d, dropped: %d", mirrored, dropped)
+	}
+}
+
+func TestNewShadowProxy_panickingPrimary(t *testing.T) {
+	cfg := shadowConfig{Sampling: 100, Compare: shadowCompareStatus, Workers: 1, QueueSize: 0}
+	reporter := &shadowReporter{name: "test", logger: logging.NoOp, counters: &ShadowCounters{}}
+	var panics uint64
+	p := newShadowProxy(
+		func(_ context.Context, _ *Request) (*Response, error) {
+			if atomic.AddUint64(&panics, 1) == 1 {
+				panic("boom")
+			}
+			return &Response{IsComplete: true}, nil
+		},
+		dummyProxy(&Response{IsComplete: true}),
+		cfg,
+		reporter,
+	)
+	func() {
+		defer func() { recover() }()
+		p(context.Background(), &Request{})
+	}()
+
+	for i := 0; i < 100; i++ {
+		if _, err := p(context.Background(), &Request{}); err != nil {
+			t.Error("unexpected error:", err.Error())
+			return
+		}
+		if atomic.LoadUint64(&reporter.counters.Matched) == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if matched := atomic.LoadUint64(&reporter.counters.Matched); matched != 1 {
+		t.Errorf("the slot of the aborted job has not been released. matched: %d, dropped: %d", matched, atomic.LoadUint64(&reporter.counters.Dropped))
+	}
+}
+
+func TestShadowConfig_sample(t *testing.T) {
+	cfg := shadowConfig{Sampling: 0}
+	for i := 0; i < 100; i++ {
+		if cfg.sample() {
+			t.Error("the request should not be sampled")
+			return
+		}
+	}
+}
@@ -116,14 +116,14 @@ func (r chiRouter) registerKrakendEndpoints(endpoints []*config.EndpointConfig)
 			continue
 		}
 
-		r.registerKrakendEndpoint(c.Method, c.Endpoint, r.cfg.HandlerFactory(c, proxyStack), len(c.Backend))
+		r.registerKrakendEndpoint(c.Method, c.Endpoint, r.cfg.HandlerFactory(c, proxyStack), len(c.Backend), proxy.IsBodyBufferingEnabled(c))
 	}
 }
 
-func (r chiRouter) registerKrakendEndpoint(method, path string, handler http.HandlerFunc, totBackends int) {
+func (r chiRouter) registerKrakendEndpoint(method, path string, handler http.HandlerFunc, totBackends int, bufferedBody bool) {
 	method = strings.ToTitle(method)
-	if method != http.MethodGet && totBackends > 1 {
-		r.cfg.Logger.Error(method, "endpoints must have a single backend! Ignoring", path)
+	if method != http.MethodGet && totBackends > 1 && !bufferedBody {
+		r.cfg.Logger.Error(method, "endpoints must have a single backend or buffer the request body! Ignoring", path)
 		return
 	}
 
@@ -2,7 +2,6 @@ package gin
 
 import (
 	"context"
-	"fmt"
 	"net/textproto"
 	"strings"
 
@@ -26,10 +25,10 @@ func EndpointHandler(configuration *config.EndpointConfig, proxy proxy.Proxy) gi
 
 // CustomErrorEndpointHandler implements the HandleFactory interface
 func CustomErrorEndpointHandler(configuration *config.EndpointConfig, prxy proxy.Proxy, errF router.ToHTTPError) gin.HandlerFunc {
-	cacheControlHeaderValue := fmt.Sprintf("public, max-age=%d", int(configuration.CacheTTL.Seconds()))
-	isCacheEnabled := configuration.CacheTTL.Seconds() != 0
+	cacheControlHeaderValue, isCacheEnabled := proxy.CacheControlHeaderValue(configuration)
 	requestGenerator := NewRequest(configuration.HeadersToPass)
 	render := getRender(configuration)
+	problems, withProblems := proxy.NewProblemFactory(configuration)
 
 	return func(c *gin.Context) {
 		requestCtx, cancel := context.WithTimeout(c, configuration.Timeout)
@@ -54,6 +53,9 @@ func CustomErrorEndpointHandler(configuration *config.EndpointConfig, prxy proxy
 				if isCacheEnabled {
 					c.Header("Cache-Control", cacheControlHeaderValue)
 				}
+				if response.Metadata.StatusCode != 0 {
+					c.Status(response.Metadata.StatusCode)
+				}
 			}
 
 			for k, vs := range response.Metadata.Headers {
@@ -68,11 +70,24 @@ func CustomErrorEndpointHandler(configuration *config.EndpointConfig, prxy proxy
 		if err != nil {
 			c.Error(err)
 
-			if response == nil {
+			if response == nil || (withProblems && len(response.Data) == 0) {
+				if t, ok := err.(headersError); ok {
+					for k, vs := range t.Headers() {
+						for _, v := range vs {
+							c.Writer.Header().Add(k, v)
+						}
+					}
+				}
+				var status int
 				if t, ok := err.(responseError); ok {
-					c.Status(t.StatusCode())
+					status = t.StatusCode()
+				} else {
+					status = errF(err)
+				}
+				if withProblems {
+					problems(err, status, c.Request.URL.Path).Write(c.Writer)
 				} else {
-					c.Status(errF(err))
+					c.Status(status)
 				}
 				cancel()
 				return
@@ -140,3 +155,8 @@ type responseError interface {
 	error
 	StatusCode() int
 }
+
+type headersError interface {
+	error
+	Headers() map[string][]string
+}
@@ -88,6 +88,52 @@ var ctxContent = map[string]interface{}{
 	"string": "supu",
 }
 
+func TestEndpointHandler_okStatusCode(t *testing.T) {
+	p := func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+		return &proxy.Response{
+			IsComplete: true,
+			Data:       map[string]interface{}{"id": 42},
+			Metadata: proxy.Metadata{
+				Headers:    map[string][]string{"Location": {"/users/42"}},
+				StatusCode: http.StatusCreated,
+			},
+		}, nil
+	}
+	endpointHandlerTestCase{
+		timeout:            10,
+		proxy:              p,
+		method:             "GET",
+		expectedBody:       `{"id":42}`,
+		expectedCache:      "public, max-age=21600",
+		expectedContent:    "application/json; charset=utf-8",
+		expectedStatusCode: http.StatusCreated,
+		completed:          true,
+		expectedHeaders:    map[string][]string{"Location": {"/users/42"}},
+	}.test(t)
+	time.Sleep(5 * time.Millisecond)
+}
+
+func TestEndpointHandler_incompleteStatusCode(t *testing.T) {
+	p := func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+		return &proxy.Response{
+			IsComplete: false,
+			Data:       map[string]interface{}{"foo": "bar"},
+			Metadata:   proxy.Metadata{StatusCode: http.StatusTooManyRequests},
+		}, nil
+	}
+	endpointHandlerTestCase{
+		timeout:            10,
+		proxy:              p,
+		method:             "GET",
+		expectedBody:       "{\"foo\":\"bar\"}",
+		expectedCache:      "",
+		expectedContent:    "application/json; charset=utf-8",
+		expectedStatusCode: http.StatusOK,
+		completed:          false,
+	}.test(t)
+	time.Sleep(5 * time.Millisecond)
+}
+
 func TestEndpointHandler_incomplete(t *testing.T) {
 	p := func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
 		return &proxy.Response{
@@ -142,6 +188,24 @@ func TestEndpointHandler_errored_responseError(t *testing.T) {
 	time.Sleep(5 * time.Millisecond)
 }
 
+func TestEndpointHandler_errored_rateLimit(t *testing.T) {
+	p := func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+		return nil, proxy.RateLimitError{RetryAfter: 1500 * time.Millisecond}
+	}
+	endpointHandlerTestCase{
+		timeout:            10,
+		proxy:              p,
+		method:             "GET",
+		expectedBody:       "",
+		expectedCache:      "",
+		expectedContent:    "",
+		expectedHeaders:    map[string][]string{"Retry-After": {"2"}},
+		expectedStatusCode: http.StatusTooManyRequests,
+		completed:          false,
+	}.test(t)
+	time.Sleep(5 * time.Millisecond)
+}
+
 type dummyResponseError struct {
 	err    string
 	status int
@@ -228,6 +292,26 @@ func TestEndpointHandler_noop(t *testing.T) {
 	time.Sleep(5 * time.Millisecond)
 }
 
+func TestEndpointHandler_problemDetails(t *testing.T) {
+	p := func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+		return nil, context.DeadlineExceeded
+	}
+	endpointHandlerTestCase{
+		timeout:            10,
+		proxy:              p,
+		method:             "GET",
+		expectedBody:       `{"type":"urn:krakend:problem:timeout","title":"Gateway Timeout","status":504,"instance":"/_gin_endpoint/a","failures":[{"backend":0,"type":"timeout"}]}` + "\n",
+		expectedCache:      "",
+		expectedContent:    proxy.ProblemContentType,
+		expectedStatusCode: http.StatusGatewayTimeout,
+		completed:          false,
+		extraConfig: config.ExtraConfig{
+			proxy.Namespace: map[string]interface{}{"problem_details": true},
+		},
+	}.test(t)
+	time.Sleep(5 * time.Millisecond)
+}
+
 type endpointHandlerTestCase struct {
 	timeout            time.Duration
 	proxy              proxy.Proxy
@@ -240,6 +324,7 @@ type endpointHandlerTestCase struct {
 	completed          bool
 	queryString        []string
 	headers            []string
+	extraConfig        config.ExtraConfig
 }
 
 func (tc endpointHandlerTestCase) test(t *testing.T) {
@@ -255,6 +340,9 @@ func (tc endpointHandlerTestCase) test(t *testing.T) {
 	if len(tc.headers) > 0 {
 		endpoint.HeadersToPass = tc.headers
 	}
+	if tc.extraConfig != nil {
+		endpoint.ExtraConfig = tc.extraConfig
+	}
 
 	server := startGinServer(EndpointHandler(endpoint, tc.proxy))
 
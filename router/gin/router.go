@@ -115,14 +115,14 @@ func (r ginRouter) registerKrakendEndpoints(endpoints []*config.EndpointConfig)
 			continue
 		}
 
-		r.registerKrakendEndpoint(c.Method, c.Endpoint, r.cfg.HandlerFactory(c, proxyStack), len(c.Backend))
+		r.registerKrakendEndpoint(c.Method, c.Endpoint, r.cfg.HandlerFactory(c, proxyStack), len(c.Backend), proxy.IsBodyBufferingEnabled(c))
 	}
 }
 
-func (r ginRouter) registerKrakendEndpoint(method, path string, handler gin.HandlerFunc, totBackends int) {
+func (r ginRouter) registerKrakendEndpoint(method, path string, handler gin.HandlerFunc, totBackends int, bufferedBody bool) {
 	method = strings.ToTitle(method)
-	if method != http.MethodGet && totBackends > 1 {
-		r.cfg.Logger.Error(method, "endpoints must have a single backend! Ignoring", path)
+	if method != http.MethodGet && totBackends > 1 && !bufferedBody {
+		r.cfg.Logger.Error(method, "endpoints must have a single backend or buffer the request body! Ignoring", path)
 		return
 	}
 	switch method {
@@ -81,6 +81,18 @@ func TestDefaultFactory_ok(t *testing.T) {
 					{},
 				},
 			},
+			{
+				Endpoint: "/multi",
+				Method:   "POST",
+				Timeout:  10,
+				Backend: []*config.Backend{
+					{},
+					{},
+				},
+				ExtraConfig: config.ExtraConfig{
+					proxy.Namespace: map[string]interface{}{"body_buffering": true},
+				},
+			},
 		},
 	}
 
@@ -2,7 +2,6 @@ package mux
 
 import (
 	"context"
-	"fmt"
 	"net/http"
 	"net/textproto"
 	"regexp"
@@ -31,9 +30,9 @@ func CustomEndpointHandler(rb RequestBuilder) HandlerFactory {
 // CustomEndpointHandlerWithHTTPError returns a HandlerFactory with the received RequestBuilder
 func CustomEndpointHandlerWithHTTPError(rb RequestBuilder, errF router.ToHTTPError) HandlerFactory {
 	return func(configuration *config.EndpointConfig, prxy proxy.Proxy) http.HandlerFunc {
-		cacheControlHeaderValue := fmt.Sprintf("public, max-age=%d", int(configuration.CacheTTL.Seconds()))
-		isCacheEnabled := configuration.CacheTTL.Seconds() != 0
+		cacheControlHeaderValue, isCacheEnabled := proxy.CacheControlHeaderValue(configuration)
 		render := getRender(configuration)
+		problems, withProblems := proxy.NewProblemFactory(configuration)
 
 		headersToSend := configuration.HeadersToPass
 		if len(headersToSend) == 0 {
@@ -79,10 +78,23 @@ func CustomEndpointHandlerWithHTTPError(rb RequestBuilder, errF router.ToHTTPErr
 			} else {
 				w.Header().Set(router.CompleteResponseHeaderName, router.HeaderIncompleteResponseValue)
 				if err != nil {
+					if t, ok := err.(headersError); ok {
+						for k, vs := range t.Headers() {
+							for _, v := range vs {
+								w.Header().Add(k, v)
+							}
+						}
+					}
+					var status int
 					if t, ok := err.(responseError); ok {
-						http.Error(w, err.Error(), t.StatusCode())
+						status = t.StatusCode()
+					} else {
+						status = errF(err)
+					}
+					if withProblems {
+						problems(err, status, r.URL.Path).Write(w)
 					} else {
-						http.Error(w, err.Error(), errF(err))
+						http.Error(w, err.Error(), status)
 					}
 					cancel()
 					return
@@ -164,3 +176,8 @@ type responseError interface {
 	error
 	StatusCode() int
 }
+
+type headersError interface {
+	error
+	Headers() map[string][]string
+}
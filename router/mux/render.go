@@ -71,6 +71,7 @@ func jsonRender(w http.ResponseWriter, response *proxy.Response) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	writeStatus(w, response)
 	w.Write(js)
 }
 
@@ -90,9 +91,18 @@ func stringRender(w http.ResponseWriter, response *proxy.Response) {
 		w.Write([]byte{})
 		return
 	}
+	writeStatus(w, response)
 	w.Write([]byte(msg))
 }
 
+// writeStatus sends the status code of the backend, if the response is complete and it has been
+// propagated
+func writeStatus(w http.ResponseWriter, response *proxy.Response) {
+	if response.IsComplete && response.Metadata.StatusCode != 0 {
+		w.WriteHeader(response.Metadata.StatusCode)
+	}
+}
+
 func noopRender(w http.ResponseWriter, response *proxy.Response) {
 	if response == nil {
 		http.Error(w, "", http.StatusInternalServerError)
@@ -0,0 +1,362 @@
+// Package consul defines a service discovery subscriber resolving the hosts with the health API of
+// a Consul compatible catalog
+//
+// The name of the service is the first host of the backend (the scheme added by the host sanitization
+// is ignored) and the rest of the options are set in its extra config:
+//
+//	"sd": "consul",
+//	"host": ["users"],
+//	"extra_config": {
+//		"github.com/vm-affekt/krakend/sd/consul": {
+//			"address": "http://127.0.0.1:8500",
+//			"datacenter": "dc1",
+//			"tags": ["production"],
+//			"passing_only": true,
+//			"scheme": "http",
+//			"token": "secret"
+//		}
+//	}
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vm-affekt/krakend/config"
+	"github.com/vm-affekt/krakend/sd"
+)
+
+const (
+	// Namespace is the key for the consul sd module
+	Namespace = "consul"
+	// ConfigNamespace is the key of the options of the consul subscriber in the extra config of the
+	// backends
+	ConfigNamespace = "github.com/vm-affekt/krakend/sd/consul"
+
+	indexHeader = "X-Consul-Index"
+	tokenHeader = "X-Consul-Token"
+)
+
+var (
+	// DefaultAddress is the address of the catalog used when the backend does not define one
+	DefaultAddress = "http://127.0.0.1:8500"
+	// DefaultWait is the max duration of the blocking queries
+	DefaultWait = 5 * time.Minute
+	// RetryInterval is the time to wait after a failed query
+	RetryInterval = time.Second
+	// InitialTimeout is the max duration of the first query, executed while building the subscriber
+	InitialTimeout = 5 * time.Second
+)
+
+// Register registers the consul sd subscriber factory. The subscribers it creates keep a blocking
+// query open against the catalog until they are closed, so the proxy factories must close them when
+// the proxies are discarded (see proxy.NewDefaultFactoryWithContext).
+func Register() error {
+	return sd.RegisterSubscriberFactory(Namespace, SubscriberFactory)
+}
+
+// RegisterWithContext registers a consul sd subscriber factory whose subscribers stop watching the
+// catalog when the context is canceled
+func RegisterWithContext(ctx context.Context) error {
+	return sd.RegisterSubscriberFactory(Namespace, SubscriberFactoryWithContext(ctx))
+}
+
+// SubscriberFactory builds a consul Subscriber with the received config
+func SubscriberFactory(cfg *config.Backend) sd.Subscriber {
+	return SubscriberFactoryWithContext(context.Background())(cfg)
+}
+
+// SubscriberFactoryWithContext returns a SubscriberFactory building subscribers bound to the context
+func SubscriberFactoryWithContext(ctx context.Context) sd.SubscriberFactory {
+	return func(cfg *config.Backend) sd.Subscriber {
+		return New(ctx, parseConfig(cfg))
+	}
+}
+
+// Config contains the options of the consul subscriber
+type Config struct {
+	// Service is the name of the service in the catalog
+	Service string
+	// Address is the base URL of the catalog API. Defaults to DefaultAddress.
+	Address string
+	// Datacenter is the datacenter to query. The one of the agent is used if empty.
+	Datacenter string
+	// Tags are the tags required to the instances of the service
+	Tags []string
+	// PassingOnly discards the instances with failing checks
+	PassingOnly bool
+	// Scheme is the scheme of the hosts. Defaults to http.
+	Scheme string
+	// Token is the ACL token sent with the queries
+	Token string
+	// Wait is the max duration of the blocking queries. Defaults to DefaultWait.
+	Wait time.Duration
+	// RetryInterval is the time to wait after a failed query. Defaults to RetryInterval.
+	RetryInterval time.Duration
+	// InitialTimeout is the max duration of the first query. Defaults to InitialTimeout.
+	InitialTimeout time.Duration
+	// Client is the http client used for the queries. Defaults to a client with a timeout of
+	// Wait + Wait/16, since the catalog adds up to Wait/16 of jitter to the blocking queries.
+	Client *http.Client
+}
+
+// New creates a consul subscriber with the received config. The first query is executed before
+// returning (bounded by the InitialTimeout), so the hosts are available right away. Then, the
+// catalog is watched with blocking queries until the context is canceled or the subscriber is
+// closed. If a query fails, the last known hosts are kept.
+func New(ctx context.Context, cfg Config) *Subscriber {
+	if cfg.Address == "" {
+		cfg.Address = DefaultAddress
+	}
+	cfg.Address = strings.TrimRight(cfg.Address, "/")
+	if cfg.Scheme == "" {
+		cfg.Scheme = "http"
+	}
+	if cfg.Wait <= 0 {
+		cfg.Wait = DefaultWait
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = RetryInterval
+	}
+	if cfg.InitialTimeout <= 0 {
+		cfg.InitialTimeout = InitialTimeout
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: cfg.Wait + cfg.Wait/16}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Subscriber{cfg: cfg, cancel: cancel}
+	initCtx, cancelInit := context.WithTimeout(ctx, cfg.InitialTimeout)
+	err := s.update(initCtx)
+	cancelInit()
+	go s.loop(ctx, err)
+	return s
+}
+
+// Subscriber is a subscriber watching the instances of a service in a Consul compatible catalog
+type Subscriber struct {
+	cfg    Config
+	cancel context.CancelFunc
+	mutex  sync.RWMutex
+	hosts  []sd.WeightedHost
+	index  uint64
+}
+
+// Hosts implements the sd.Subscriber interface
+func (s *Subscriber) Hosts() ([]string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	hosts := make([]string, len(s.hosts))
+	for i, h := range s.hosts {
+		hosts[i] = h.Host
+	}
+	return hosts, nil
+}
+
+// WeightedHosts implements the sd.WeightedSubscriber interface. The weights are the passing
+// weights of the instances.
+func (s *Subscriber) WeightedHosts() ([]sd.WeightedHost, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	hosts := make([]sd.WeightedHost, len(s.hosts))
+	copy(hosts, s.hosts)
+	return hosts, nil
+}
+
+// Close stops watching the catalog
+func (s *Subscriber) Close() error {
+	s.cancel()
+	return nil
+}
+
+// loop keeps executing blocking queries, waiting for the RetryInterval after every failure
+func (s *Subscriber) loop(ctx context.Context, err error) {
+	for {
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.cfg.RetryInterval):
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		err = s.update(ctx)
+	}
+}
+
+type serviceEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+		Tags    []string
+		Weights struct {
+			Passing int
+		}
+	}
+	Checks []struct {
+		Status string
+	}
+}
+
+// update executes a blocking query with the last known index and stores the received hosts
+func (s *Subscriber) update(ctx context.Context) error {
+	s.mutex.RLock()
+	index := s.index
+	s.mutex.RUnlock()
+
+	req, err := http.NewRequest(http.MethodGet, s.queryURL(index), nil)
+	if err != nil {
+		return err
+	}
+	if s.cfg.Token != "" {
+		req.Header.Set(tokenHeader, s.cfg.Token)
+	}
+	resp, err := s.cfg.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("consul: unexpected status code %d", resp.StatusCode)
+	}
+
+	var entries []serviceEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return err
+	}
+
+	newIndex, err := strconv.ParseUint(resp.Header.Get(indexHeader), 10, 64)
+	if err != nil {
+		return fmt.Errorf("consul: invalid index: %s", err.Error())
+	}
+	// the index is reset if it goes backwards and it must be greater than 0, so the next query blocks
+	if newIndex < index {
+		newIndex = 0
+	}
+	if newIndex == 0 {
+		newIndex = 1
+	}
+
+	hosts := s.parse(entries)
+	s.mutex.Lock()
+	s.index = newIndex
+	s.hosts = hosts
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *Subscriber) queryURL(index uint64) string {
+	q := url.Values{}
+	if s.cfg.Datacenter != "" {
+		q.Set("dc", s.cfg.Datacenter)
+	}
+	if s.cfg.PassingOnly {
+		q.Set("passing", "1")
+	}
+	for _, tag := range s.cfg.Tags {
+		q.Add("tag", tag)
+	}
+	if index > 0 {
+		q.Set("index", strconv.FormatUint(index, 10))
+		q.Set("wait", s.cfg.Wait.String())
+	}
+	return s.cfg.Address + "/v1/health/service/" + url.PathEscape(s.cfg.Service) + "?" + q.Encode()
+}
+
+// parse builds the hosts of the entries, checking again the tags and the checks, since the old
+// versions of the catalog only filter by a single tag
+func (s *Subscriber) parse(entries []serviceEntry) []sd.WeightedHost {
+	hosts := make([]sd.WeightedHost, 0, len(entries))
+	for _, e := range entries {
+		if !hasTags(e.Service.Tags, s.cfg.Tags) || (s.cfg.PassingOnly && !isPassing(e)) {
+			continue
+		}
+		address := e.Service.Address
+		if address == "" {
+			address = e.Node.Address
+		}
+		weight := e.Service.Weights.Passing
+		if weight <= 0 {
+			weight = 1
+		}
+		hosts = append(hosts, sd.WeightedHost{
+			Host:   s.cfg.Scheme + "://" + net.JoinHostPort(address, strconv.Itoa(e.Service.Port)),
+			Weight: weight,
+		})
+	}
+	return hosts
+}
+
+func hasTags(tags, required []string) bool {
+	for _, r := range required {
+		found := false
+		for _, t := range tags {
+			if t == r {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func isPassing(e serviceEntry) bool {
+	for _, c := range e.Checks {
+		if c.Status != "passing" {
+			return false
+		}
+	}
+	return true
+}
+
+func parseConfig(remote *config.Backend) Config {
+	cfg := Config{Service: sd.ServiceName(remote.Host[0]), PassingOnly: true}
+	tmp, ok := remote.ExtraConfig[ConfigNamespace].(map[string]interface{})
+	if !ok {
+		return cfg
+	}
+	if v, ok := tmp["address"].(string); ok {
+		cfg.Address = v
+	}
+	if v, ok := tmp["datacenter"].(string); ok {
+		cfg.Datacenter = v
+	}
+	if v, ok := tmp["tags"].([]interface{}); ok {
+		for _, tag := range v {
+			if t, ok := tag.(string); ok {
+				cfg.Tags = append(cfg.Tags, t)
+			}
+		}
+	}
+	if v, ok := tmp["passing_only"].(bool); ok {
+		cfg.PassingOnly = v
+	}
+	if v, ok := tmp["scheme"].(string); ok {
+		cfg.Scheme = v
+	}
+	if v, ok := tmp["token"].(string); ok {
+		cfg.Token = v
+	}
+	if v, ok := tmp["wait"].(string); ok {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.Wait = d
+		}
+	}
+	return cfg
+}
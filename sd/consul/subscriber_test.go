@@ -0,0 +1,331 @@
+package consul
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vm-affekt/krakend/config"
+	"github.com/vm-affekt/krakend/sd"
+)
+
+const entries = `[
+	{
+		"Node": {"Address": "10.0.0.1"},
+		"Service": {"Address": "", "Port": 8080, "Tags": ["production", "v1"], "Weights": {"Passing": 3}},
+		"Checks": [{"Status": "passing"}, {"Status": "passing"}]
+	},
+	{
+		"Node": {"Address": "10.0.0.2"},
+		"Service": {"Address": "10.0.1.2", "Port": 8081, "Tags": ["production"]},
+		"Checks": [{"Status": "passing"}]
+	},
+	{
+		"Node": {"Address": "10.0.0.3"},
+		"Service": {"Address": "", "Port": 8082, "Tags": ["production"]},
+		"Checks": [{"Status": "passing"}, {"Status": "critical"}]
+	},
+	{
+		"Node": {"Address": "10.0.0.4"},
+		"Service": {"Address": "", "Port": 8083, "Tags": ["staging"]},
+		"Checks": [{"Status": "passing"}]
+	}
+]`
+
+func TestSubscriber_New(t *testing.T) {
+	var query string
+	var token string
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1/health/service/users" {
+			http.NotFound(rw, req)
+			return
+		}
+		if req.URL.Query().Get("index") != "" {
+			<-req.Context().Done()
+			return
+		}
+		query = req.URL.RawQuery
+		token = req.Header.Get(tokenHeader)
+		rw.Header().Set(indexHeader, "42")
+		fmt.Fprint(rw, entries)
+	}))
+	defer ts.Close()
+
+	if err := Register(); err != nil {
+		t.Error("registering the consul module:", err.Error())
+	}
+
+	s := sd.GetSubscriber(&config.Backend{
+		Host: []string{"users"},
+		SD:   Namespace,
+		ExtraConfig: config.ExtraConfig{
+			ConfigNamespace: map[string]interface{}{
+				"address":    ts.URL + "/",
+				"datacenter": "dc1",
+				"tags":       []interface{}{"production"},
+				"token":      "secret",
+			},
+		},
+	})
+	defer s.(*Subscriber).Close()
+
+	if query != "dc=dc1&passing=1&tag=production" {
+		t.Error("unexpected query:", query)
+	}
+	if token != "secret" {
+		t.Error("unexpected token:", token)
+	}
+
+	hosts, err := s.(sd.WeightedSubscriber).WeightedHosts()
+	if err != nil {
+		t.Error("getting the hosts:", err.Error())
+		return
+	}
+	expected := []sd.WeightedHost{
+		{Host: "http://10.0.0.1:8080", Weight: 3},
+		{Host: "http://10.0.1.2:8081", Weight: 1},
+	}
+	if !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("unexpected hosts: %v", hosts)
+	}
+}
+
+func TestSubscriberFactory_sanitizedHost(t *testing.T) {
+	paths := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		select {
+		case paths <- req.URL.Path:
+		default:
+		}
+		rw.Header().Set(indexHeader, "10")
+		fmt.Fprint(rw, `[{"Node": {"Address": "10.0.0.1"}, "Service": {"Port": 8080}}]`)
+	}))
+	defer ts.Close()
+
+	cfg := config.ServiceConfig{
+		Version: config.ConfigVersion,
+		Endpoints: []*config.EndpointConfig{
+			{
+				Endpoint: "/users",
+				Method:   "GET",
+				Backend: []*config.Backend{
+					{
+						SD:         Namespace,
+						Host:       []string{"users"},
+						URLPattern: "/",
+						ExtraConfig: config.ExtraConfig{
+							ConfigNamespace: map[string]interface{}{"address": ts.URL},
+						},
+					},
+				},
+			},
+		},
+	}
+	if err := cfg.Init(); err != nil {
+		t.Error("initializing the config:", err.Error())
+		return
+	}
+
+	s := SubscriberFactory(cfg.Endpoints[0].Backend[0])
+	defer s.(*Subscriber).Close()
+
+	if path := <-paths; path != "/v1/health/service/users" {
+		t.Error("unexpected path:", path)
+	}
+	if hosts, _ := s.Hosts(); !reflect.DeepEqual(hosts, []string{"http://10.0.0.1:8080"}) {
+		t.Errorf("unexpected hosts: %v", hosts)
+	}
+}
+
+func TestSubscriber_allInstances(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("index") != "" {
+			<-req.Context().Done()
+			return
+		}
+		rw.Header().Set(indexHeader, "42")
+		fmt.Fprint(rw, entries)
+	}))
+	defer ts.Close()
+
+	s := New(context.Background(), Config{Service: "users", Address: ts.URL, Scheme: "https"})
+	defer s.Close()
+
+	hosts, _ := s.Hosts()
+	expected := []string{"https://10.0.0.1:8080", "https://10.0.1.2:8081", "https://10.0.0.3:8082", "https://10.0.0.4:8083"}
+	if !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("unexpected hosts: %v", hosts)
+	}
+}
+
+func TestSubscriber_blockingQueries(t *testing.T) {
+	updates := make(chan string)
+	mu := sync.Mutex{}
+	indexes := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		mu.Lock()
+		indexes = append(indexes, q.Get("index"))
+		mu.Unlock()
+
+		switch q.Get("index") {
+		case "":
+			rw.Header().Set(indexHeader, "10")
+			fmt.Fprint(rw, `[{"Node": {"Address": "10.0.0.1"}, "Service": {"Port": 8080}}]`)
+			return
+		case "10":
+			if q.Get("wait") != "1m0s" {
+				t.Error("unexpected wait:", q.Get("wait"))
+			}
+			select {
+			case body := <-updates:
+				rw.Header().Set(indexHeader, "11")
+				fmt.Fprint(rw, body)
+			case <-req.Context().Done():
+			}
+			return
+		}
+		<-req.Context().Done()
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := New(ctx, Config{Service: "users", Address: ts.URL, Wait: time.Minute})
+
+	if hosts, _ := s.Hosts(); !reflect.DeepEqual(hosts, []string{"http://10.0.0.1:8080"}) {
+		t.Errorf("unexpected hosts: %v", hosts)
+	}
+
+	updates <- `[{"Node": {"Address": "10.0.0.1"}, "Service": {"Port": 8080}}, {"Node": {"Address": "10.0.0.2"}, "Service": {"Port": 8080}}]`
+	waitForHosts(t, s, []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"})
+
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(indexes, []string{"", "10", "11"}) {
+		t.Errorf("unexpected indexes: %v", indexes)
+	}
+}
+
+func TestSubscriber_Close(t *testing.T) {
+	aborted := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("index") == "" {
+			rw.Header().Set(indexHeader, "10")
+			fmt.Fprint(rw, `[{"Node": {"Address": "10.0.0.1"}, "Service": {"Port": 8080}}]`)
+			return
+		}
+		<-req.Context().Done()
+		close(aborted)
+	}))
+	defer ts.Close()
+
+	s := New(context.Background(), Config{Service: "users", Address: ts.URL, Wait: time.Minute})
+	time.Sleep(10 * time.Millisecond)
+	s.Close()
+
+	select {
+	case <-aborted:
+	case <-time.After(time.Second):
+		t.Error("the blocking query was not aborted")
+	}
+}
+
+func TestSubscriber_unresponsiveCatalog(t *testing.T) {
+	mu := sync.Mutex{}
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		calls++
+		c := calls
+		mu.Unlock()
+		if c == 2 {
+			rw.Header().Set(indexHeader, "10")
+			fmt.Fprint(rw, `[{"Node": {"Address": "10.0.0.1"}, "Service": {"Port": 8080}}]`)
+			return
+		}
+		<-req.Context().Done()
+	}))
+	defer ts.Close()
+
+	start := time.Now()
+	s := New(context.Background(), Config{
+		Service:        "users",
+		Address:        ts.URL,
+		Wait:           16 * time.Millisecond,
+		RetryInterval:  time.Millisecond,
+		InitialTimeout: 10 * time.Millisecond,
+	})
+	defer s.Close()
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("the first query has not been bounded: %s", elapsed)
+	}
+	if hosts, _ := s.Hosts(); len(hosts) != 0 {
+		t.Errorf("unexpected hosts: %v", hosts)
+	}
+	waitForHosts(t, s, []string{"http://10.0.0.1:8080"})
+
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	c := calls
+	mu.Unlock()
+	if c < 4 {
+		t.Error("the blocking queries have not been bounded:", c)
+	}
+}
+
+func TestSubscriber_keepsTheLastHosts(t *testing.T) {
+	mu := sync.Mutex{}
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		calls++
+		c := calls
+		mu.Unlock()
+
+		if c == 1 {
+			rw.Header().Set(indexHeader, "10")
+			fmt.Fprint(rw, `[{"Node": {"Address": "10.0.0.1"}, "Service": {"Port": 8080}}]`)
+			return
+		}
+		http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	s := New(context.Background(), Config{Service: "users", Address: ts.URL, RetryInterval: time.Millisecond})
+	defer s.Close()
+
+	time.Sleep(20 * time.Millisecond)
+
+	mu.Lock()
+	c := calls
+	mu.Unlock()
+	if c < 3 {
+		t.Error("the subscriber is not retrying the queries:", c)
+	}
+	if hosts, _ := s.Hosts(); !reflect.DeepEqual(hosts, []string{"http://10.0.0.1:8080"}) {
+		t.Errorf("unexpected hosts: %v", hosts)
+	}
+}
+
+func waitForHosts(t *testing.T, s sd.Subscriber, expected []string) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		hosts, _ := s.Hosts()
+		if reflect.DeepEqual(hosts, expected) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("unexpected hosts. have: %v, want: %v", hosts, expected)
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
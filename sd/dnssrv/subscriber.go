@@ -2,8 +2,10 @@
 package dnssrv
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"strings"
 	"sync"
 	"time"
 
@@ -11,24 +13,81 @@ import (
 	"github.com/vm-affekt/krakend/sd"
 )
 
-// Namespace is the key for the dns sd module
-const Namespace = "dns"
+const (
+	// Namespace is the key for the dns sd module
+	Namespace = "dns"
+	// ConfigNamespace is the key of the options of the dns subscriber in the extra config of the
+	// backends
+	ConfigNamespace = "github.com/vm-affekt/krakend/sd/dnssrv"
 
-// Register registers the dns sd subscriber factory
+	// ModeSRV resolves the hosts with SRV records
+	ModeSRV = "srv"
+	// ModeA resolves the hosts with A and AAAA records
+	ModeA = "a"
+)
+
+// Register registers the dns sd subscriber factory. The subscribers it creates resolve their hosts
+// every TTL until they are closed, so the proxy factories must close them when the proxies are
+// discarded (see proxy.NewDefaultFactoryWithContext).
 func Register() error {
 	return sd.RegisterSubscriberFactory(Namespace, SubscriberFactory)
 }
 
+// RegisterWithContext registers a dns sd subscriber factory whose subscribers stop refreshing
+// their hosts when the context is canceled
+func RegisterWithContext(ctx context.Context) error {
+	return sd.RegisterSubscriberFactory(Namespace, SubscriberFactoryWithContext(ctx))
+}
+
 var (
 	// TTL is the duration of the cached data
 	TTL = 30 * time.Second
 	// DefaultLookup id the function for the DNS resolution
 	DefaultLookup = net.LookupSRV
+	// DefaultLookupHost is the function for the DNS resolution of A and AAAA records
+	DefaultLookupHost = net.LookupHost
 )
 
+// SRVLookup resolves the SRV records of a name
+type SRVLookup func(service, proto, name string) (cname string, addrs []*net.SRV, err error)
+
+// HostLookup resolves the addresses of a host
+type HostLookup func(host string) (addrs []string, err error)
+
+// Config contains the options of the dns subscriber
+type Config struct {
+	// Name is the name to resolve. In ModeA, it can contain the port of the hosts.
+	Name string
+	// Scheme is the scheme of the hosts. Defaults to http.
+	Scheme string
+	// Mode is the kind of DNS records to resolve: ModeSRV (default) or ModeA
+	Mode string
+	// TTL is the time between two resolutions
+	TTL time.Duration
+	// LookupSRV resolves the SRV records. Defaults to DefaultLookup.
+	LookupSRV SRVLookup
+	// LookupHost resolves the A and AAAA records. Defaults to DefaultLookupHost.
+	LookupHost HostLookup
+}
+
 // SubscriberFactory builds a DNS_SRV Subscriber with the received config
 func SubscriberFactory(cfg *config.Backend) sd.Subscriber {
-	return New(cfg.Host[0])
+	return SubscriberFactoryWithContext(context.Background())(cfg)
+}
+
+// SubscriberFactoryWithContext returns a SubscriberFactory building subscribers bound to the
+// context. The scheme, the mode and the ttl of the subscribers can be set in the extra config
+// of the backend:
+//
+//	"github.com/vm-affekt/krakend/sd/dnssrv": {
+//		"scheme": "https",
+//		"mode": "a",
+//		"ttl": "10s"
+//	}
+func SubscriberFactoryWithContext(ctx context.Context) sd.SubscriberFactory {
+	return func(cfg *config.Backend) sd.Subscriber {
+		return NewWithConfig(ctx, parseConfig(cfg))
+	}
 }
 
 // New creates a DNS subscriber with the default values
@@ -37,55 +96,175 @@ func New(name string) sd.Subscriber {
 }
 
 // NewDetailed creates a DNS subscriber with the received values
-func NewDetailed(name string, lookup lookup, ttl time.Duration) sd.Subscriber {
-	s := subscriber{name, &sd.FixedSubscriber{}, &sync.Mutex{}, ttl, lookup}
+func NewDetailed(name string, lookup SRVLookup, ttl time.Duration) sd.Subscriber {
+	return NewWithConfig(context.Background(), Config{Name: name, LookupSRV: lookup, TTL: ttl})
+}
+
+// NewWithConfig creates a DNS subscriber with the received config. The hosts are resolved every
+// TTL until the context is canceled or the subscriber is closed. If a resolution fails, the last
+// resolved hosts are kept.
+func NewWithConfig(ctx context.Context, cfg Config) *Subscriber {
+	if cfg.Scheme == "" {
+		cfg.Scheme = "http"
+	}
+	if cfg.Mode == "" {
+		cfg.Mode = ModeSRV
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = TTL
+	}
+	if cfg.LookupSRV == nil {
+		cfg.LookupSRV = DefaultLookup
+	}
+	if cfg.LookupHost == nil {
+		cfg.LookupHost = DefaultLookupHost
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Subscriber{cfg: cfg, cancel: cancel}
 	s.update()
-	go s.loop()
+	go s.loop(ctx)
 	return s
 }
 
-type lookup func(service, proto, name string) (cname string, addrs []*net.SRV, err error)
-
-type subscriber struct {
-	name   string
-	cache  *sd.FixedSubscriber
-	mutex  *sync.Mutex
-	ttl    time.Duration
-	lookup lookup
+// Subscriber is a subscriber resolving the hosts with DNS queries. In ModeSRV, the targets are
+// grouped by the priority of their records and weighted with their weights. The records with a
+// weight of 0 get the minimum weight. The Hosts and WeightedHosts methods return only the hosts of
+// the preferred group, as the lower priority targets are meant to be backups. The decorators aware
+// of the health of the hosts, like sd.HealthCheckSubscriber, use the priorities for failing over
+// to the next groups when the preferred one is unhealthy.
+type Subscriber struct {
+	cfg       Config
+	cancel    context.CancelFunc
+	mutex     sync.RWMutex
+	instances []sd.PrioritizedHost
 }
 
 // Hosts implements the subscriber interface
-func (s subscriber) Hosts() ([]string, error) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	return s.cache.Hosts()
+func (s *Subscriber) Hosts() ([]string, error) {
+	hosts, _ := s.WeightedHosts()
+	res := make([]string, len(hosts))
+	for i, h := range hosts {
+		res[i] = h.Host
+	}
+	return res, nil
+}
+
+// WeightedHosts implements the sd.WeightedSubscriber interface
+func (s *Subscriber) WeightedHosts() ([]sd.WeightedHost, error) {
+	s.mutex.RLock()
+	groups := sd.GroupByPriority(s.instances)
+	s.mutex.RUnlock()
+	if len(groups) == 0 {
+		return []sd.WeightedHost{}, nil
+	}
+	return groups[0], nil
+}
+
+// PrioritizedHosts implements the sd.PrioritizedSubscriber interface
+func (s *Subscriber) PrioritizedHosts() ([]sd.PrioritizedHost, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	res := make([]sd.PrioritizedHost, len(s.instances))
+	copy(res, s.instances)
+	return res, nil
 }
 
-func (s subscriber) loop() {
+// Close stops refreshing the hosts
+func (s *Subscriber) Close() error {
+	s.cancel()
+	return nil
+}
+
+func (s *Subscriber) loop(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.TTL)
+	defer ticker.Stop()
 	for {
-		<-time.After(s.ttl)
-		s.update()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.update()
+		}
 	}
 }
 
-func (s subscriber) update() {
-	instances, err := s.resolve()
+func (s *Subscriber) update() {
+	var instances []sd.PrioritizedHost
+	var err error
+	if s.cfg.Mode == ModeA {
+		instances, err = s.resolveHosts()
+	} else {
+		instances, err = s.resolveSRV()
+	}
 	if err != nil {
 		return
 	}
 	s.mutex.Lock()
-	*(s.cache) = sd.FixedSubscriber(instances)
+	s.instances = instances
 	s.mutex.Unlock()
 }
 
-func (s subscriber) resolve() ([]string, error) {
-	_, addrs, err := s.lookup("", "", s.name)
+func (s *Subscriber) resolveSRV() ([]sd.PrioritizedHost, error) {
+	_, addrs, err := s.cfg.LookupSRV("", "", s.cfg.Name)
 	if err != nil {
-		return []string{}, err
+		return nil, err
 	}
-	instances := make([]string, len(addrs))
+	instances := make([]sd.PrioritizedHost, len(addrs))
 	for i, addr := range addrs {
-		instances[i] = fmt.Sprintf("http://%s", net.JoinHostPort(addr.Target, fmt.Sprint(addr.Port)))
+		weight := int(addr.Weight)
+		if weight == 0 {
+			weight = 1
+		}
+		instances[i] = sd.PrioritizedHost{
+			Host:     s.url(strings.TrimSuffix(addr.Target, "."), fmt.Sprint(addr.Port)),
+			Weight:   weight,
+			Priority: int(addr.Priority),
+		}
 	}
 	return instances, nil
 }
+
+func (s *Subscriber) resolveHosts() ([]sd.PrioritizedHost, error) {
+	name, port := s.cfg.Name, ""
+	if h, p, err := net.SplitHostPort(name); err == nil {
+		name, port = h, p
+	}
+	addrs, err := s.cfg.LookupHost(name)
+	if err != nil {
+		return nil, err
+	}
+	instances := make([]sd.PrioritizedHost, len(addrs))
+	for i, addr := range addrs {
+		instances[i] = sd.PrioritizedHost{Host: s.url(addr, port), Weight: 1}
+	}
+	return instances, nil
+}
+
+func (s *Subscriber) url(host, port string) string {
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return s.cfg.Scheme + "://" + host
+}
+
+func parseConfig(remote *config.Backend) Config {
+	cfg := Config{Name: remote.Host[0]}
+	tmp, ok := remote.ExtraConfig[ConfigNamespace].(map[string]interface{})
+	if !ok {
+		return cfg
+	}
+	if v, ok := tmp["scheme"].(string); ok {
+		cfg.Scheme = v
+	}
+	if v, ok := tmp["mode"].(string); ok {
+		cfg.Mode = strings.ToLower(v)
+	}
+	if v, ok := tmp["ttl"].(string); ok {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.TTL = d
+		}
+	}
+	return cfg
+}
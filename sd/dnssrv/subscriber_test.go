@@ -1,8 +1,11 @@
 package dnssrv
 
 import (
+	"context"
 	"errors"
 	"net"
+	"reflect"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -59,3 +62,108 @@ func TestSubscriber_LoockupError(t *testing.T) {
 		t.Error("Wrong number of hosts:", len(hosts))
 	}
 }
+
+func TestSubscriber_priorityAndWeight(t *testing.T) {
+	lookup := func(service, proto, name string) (cname string, addrs []*net.SRV, err error) {
+		return "cname", []*net.SRV{
+			{Target: "backup.example.tld.", Port: 8080, Priority: 20, Weight: 0},
+			{Target: "a.example.tld.", Port: 8080, Priority: 10, Weight: 60},
+			{Target: "b.example.tld.", Port: 8081, Priority: 10, Weight: 40},
+		}, nil
+	}
+	s := NewWithConfig(context.Background(), Config{Name: "some.example.tld", Scheme: "https", LookupSRV: lookup, TTL: time.Minute})
+	defer s.Close()
+
+	hosts, err := s.WeightedHosts()
+	if err != nil {
+		t.Error("getting the hosts:", err.Error())
+		return
+	}
+	expected := []sd.WeightedHost{
+		{Host: "https://a.example.tld:8080", Weight: 60},
+		{Host: "https://b.example.tld:8081", Weight: 40},
+	}
+	if !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("unexpected hosts: %v", hosts)
+	}
+
+	prioritized, _ := s.PrioritizedHosts()
+	if len(prioritized) != 3 {
+		t.Errorf("unexpected hosts: %v", prioritized)
+		return
+	}
+	if prioritized[0] != (sd.PrioritizedHost{Host: "https://backup.example.tld:8080", Weight: 1, Priority: 20}) {
+		t.Errorf("unexpected host: %v", prioritized[0])
+	}
+
+	h := sd.NewHealthCheckSubscriber(context.Background(), s, sd.HealthCheckConfig{MaxFailures: 1, CoolDown: time.Minute})
+	defer h.Close()
+	if hosts, _ := h.Hosts(); !reflect.DeepEqual(hosts, []string{"https://a.example.tld:8080", "https://b.example.tld:8081"}) {
+		t.Errorf("unexpected hosts before the failover: %v", hosts)
+	}
+	h.ReportFailure("https://a.example.tld:8080")
+	h.ReportFailure("https://b.example.tld:8081")
+	if hosts, _ := h.Hosts(); !reflect.DeepEqual(hosts, []string{"https://backup.example.tld:8080"}) {
+		t.Errorf("unexpected hosts after the failover: %v", hosts)
+	}
+}
+
+func TestSubscriber_modeA(t *testing.T) {
+	if err := RegisterWithContext(context.Background()); err != nil {
+		t.Error("registering the dns module:", err.Error())
+	}
+	defaultLookupHost := DefaultLookupHost
+	defer func() { DefaultLookupHost = defaultLookupHost }()
+
+	var resolved string
+	DefaultLookupHost = func(host string) ([]string, error) {
+		resolved = host
+		return []string{"10.0.0.1", "fe80::1"}, nil
+	}
+
+	for name, expected := range map[string][]string{
+		"some.example.tld:8080": {"https://10.0.0.1:8080", "https://[fe80::1]:8080"},
+		"some.example.tld":      {"https://10.0.0.1", "https://[fe80::1]"},
+	} {
+		s := sd.GetSubscriber(&config.Backend{
+			Host: []string{name},
+			SD:   Namespace,
+			ExtraConfig: config.ExtraConfig{
+				ConfigNamespace: map[string]interface{}{"scheme": "https", "mode": "A", "ttl": "1m"},
+			},
+		})
+		hosts, err := s.Hosts()
+		if err != nil {
+			t.Error("getting the hosts:", err.Error())
+		}
+		if !reflect.DeepEqual(hosts, expected) {
+			t.Errorf("%s: unexpected hosts: %v", name, hosts)
+		}
+		if resolved != "some.example.tld" {
+			t.Errorf("%s: unexpected name resolved: %s", name, resolved)
+		}
+		s.(*Subscriber).Close()
+	}
+}
+
+func TestSubscriber_Close(t *testing.T) {
+	var calls int32
+	lookup := func(service, proto, name string) (cname string, addrs []*net.SRV, err error) {
+		atomic.AddInt32(&calls, 1)
+		return "cname", []*net.SRV{{Target: "127.0.0.1", Port: 80}}, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	NewWithConfig(ctx, Config{Name: "some.example.tld", LookupSRV: lookup, TTL: time.Millisecond})
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+
+	c := atomic.LoadInt32(&calls)
+	if c < 2 {
+		t.Error("the hosts have not been refreshed:", c)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if atomic.LoadInt32(&calls) != c {
+		t.Error("the subscriber is still refreshing the hosts")
+	}
+}
@@ -0,0 +1,276 @@
+// Package file defines a service discovery subscriber reading the hosts from a local file
+//
+// The file contains a JSON (or YAML, if its extension is .yml or .yaml) list of hosts or an object
+// with the list under the hosts key. Every host can be a string or an object with its weight and
+// some metadata:
+//
+//	{
+//		"hosts": [
+//			"http://10.0.0.1:8080",
+//			{"host": "http://10.0.0.2:8080", "weight": 3, "metadata": {"zone": "eu-west-1a"}}
+//		]
+//	}
+//
+// The path of the file is the first host of the backend, so the host sanitization must be disabled:
+//
+//	"sd": "file",
+//	"host": ["/etc/krakend/users.json"],
+//	"disable_host_sanitize": true
+package file
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/vm-affekt/krakend/config"
+	"github.com/vm-affekt/krakend/encoding"
+	"github.com/vm-affekt/krakend/logging"
+	"github.com/vm-affekt/krakend/sd"
+)
+
+// Namespace is the key for the file sd module
+const Namespace = "file"
+
+// Register registers the file sd subscriber factory. The subscribers it creates poll their file until
+// they are closed, so the proxy factories must close them when the proxies are discarded (see
+// proxy.NewDefaultFactoryWithContext).
+func Register() error {
+	return sd.RegisterSubscriberFactory(Namespace, SubscriberFactory)
+}
+
+// RegisterWithLogger registers a file sd subscriber factory logging the problems with the files
+func RegisterWithLogger(logger logging.Logger) error {
+	return sd.RegisterSubscriberFactory(Namespace, SubscriberFactoryWithLogger(logger))
+}
+
+// PollInterval is the time between two checks of the file
+var PollInterval = time.Second
+
+var errInvalidFormat = errors.New("invalid hosts file format")
+
+// SubscriberFactory builds a file Subscriber with the received config. The subscriber must be closed
+// when it is no longer used.
+func SubscriberFactory(cfg *config.Backend) sd.Subscriber {
+	return New(cfg.Host[0])
+}
+
+// SubscriberFactoryWithLogger returns a SubscriberFactory injecting the logger into the subscribers
+func SubscriberFactoryWithLogger(logger logging.Logger) sd.SubscriberFactory {
+	return func(cfg *config.Backend) sd.Subscriber {
+		return NewDetailed(context.Background(), cfg.Host[0], PollInterval, logger)
+	}
+}
+
+// New creates a file subscriber with the default values
+func New(path string) *Subscriber {
+	return NewDetailed(context.Background(), path, PollInterval, logging.NoOp)
+}
+
+// NewDetailed creates a file subscriber with the received values. The file is checked every
+// interval until the context is canceled or the subscriber is closed and it is reloaded when its
+// modification time or its size change. If the file can not be read or parsed, the subscriber keeps
+// the last good list of hosts.
+func NewDetailed(ctx context.Context, path string, interval time.Duration, logger logging.Logger) *Subscriber {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Subscriber{
+		path:   path,
+		logger: logger,
+		cancel: cancel,
+	}
+	s.update()
+	if interval > 0 {
+		go s.loop(ctx, interval)
+	}
+	return s
+}
+
+// Instance is a host defined in the file
+type Instance struct {
+	Host     string
+	Weight   int
+	Metadata map[string]interface{}
+}
+
+// Subscriber is a subscriber reading the hosts from a file
+type Subscriber struct {
+	path      string
+	logger    logging.Logger
+	cancel    context.CancelFunc
+	mu        sync.RWMutex
+	instances []Instance
+	modTime   time.Time
+	size      int64
+}
+
+// Hosts implements the sd.Subscriber interface
+func (s *Subscriber) Hosts() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hosts := make([]string, len(s.instances))
+	for i, instance := range s.instances {
+		hosts[i] = instance.Host
+	}
+	return hosts, nil
+}
+
+// WeightedHosts implements the sd.WeightedSubscriber interface
+func (s *Subscriber) WeightedHosts() ([]sd.WeightedHost, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hosts := make([]sd.WeightedHost, len(s.instances))
+	for i, instance := range s.instances {
+		hosts[i] = sd.WeightedHost{Host: instance.Host, Weight: instance.Weight}
+	}
+	return hosts, nil
+}
+
+// Instances returns the hosts defined in the file with their weights and metadata
+func (s *Subscriber) Instances() []Instance {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make([]Instance, len(s.instances))
+	copy(res, s.instances)
+	return res
+}
+
+// Close stops watching the file
+func (s *Subscriber) Close() error {
+	s.cancel()
+	return nil
+}
+
+func (s *Subscriber) loop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.update()
+		}
+	}
+}
+
+func (s *Subscriber) update() {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		s.logger.Error("file sd: unable to access", s.path, err.Error())
+		return
+	}
+	s.mu.RLock()
+	changed := !info.ModTime().Equal(s.modTime) || info.Size() != s.size
+	s.mu.RUnlock()
+	if !changed {
+		return
+	}
+
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		s.logger.Error("file sd: unable to read", s.path, err.Error())
+		return
+	}
+	instances, err := parse(b, isYAML(s.path))
+
+	s.mu.Lock()
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+	if err == nil {
+		s.instances = instances
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		s.logger.Error("file sd: unable to parse", s.path, err.Error(), "- keeping the last good list of hosts")
+		return
+	}
+	s.logger.Debug("file sd:", len(instances), "hosts loaded from", s.path)
+}
+
+func isYAML(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		return true
+	}
+	return false
+}
+
+func parse(b []byte, isYAML bool) ([]Instance, error) {
+	var data interface{}
+	if isYAML {
+		if err := yaml.Unmarshal(b, &data); err != nil {
+			return nil, err
+		}
+		data = encoding.SanitizeYAML(data)
+	} else {
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.UseNumber()
+		if err := d.Decode(&data); err != nil {
+			return nil, err
+		}
+	}
+
+	if m, ok := data.(map[string]interface{}); ok {
+		data = m["hosts"]
+	}
+	list, ok := data.([]interface{})
+	if !ok {
+		return nil, errInvalidFormat
+	}
+
+	instances := make([]Instance, 0, len(list))
+	for _, v := range list {
+		switch t := v.(type) {
+		case string:
+			instances = append(instances, Instance{Host: cleanHost(t), Weight: 1})
+		case map[string]interface{}:
+			host, ok := t["host"].(string)
+			if !ok || host == "" {
+				return nil, errInvalidFormat
+			}
+			weight, err := toInt(t["weight"])
+			if err != nil {
+				return nil, err
+			}
+			metadata, _ := t["metadata"].(map[string]interface{})
+			instances = append(instances, Instance{Host: cleanHost(host), Weight: weight, Metadata: metadata})
+		default:
+			return nil, errInvalidFormat
+		}
+	}
+	return instances, nil
+}
+
+func toInt(v interface{}) (int, error) {
+	switch t := v.(type) {
+	case nil:
+		return 1, nil
+	case int:
+		return t, nil
+	case float64:
+		return int(t), nil
+	case json.Number:
+		i, err := t.Int64()
+		return int(i), err
+	}
+	return 0, fmt.Errorf("invalid weight: %v", v)
+}
+
+func cleanHost(host string) string {
+	host = strings.TrimRight(strings.TrimSpace(host), "/")
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	return host
+}
@@ -0,0 +1,179 @@
+package file
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vm-affekt/krakend/config"
+	"github.com/vm-affekt/krakend/logging"
+	"github.com/vm-affekt/krakend/sd"
+)
+
+func TestSubscriber_New(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "hosts.json")
+	writeFile(t, path, `["127.0.0.1:8080", "https://127.0.0.1:8081/"]`)
+
+	if err := Register(); err != nil {
+		t.Error("registering the file module:", err.Error())
+	}
+
+	s := sd.GetSubscriber(&config.Backend{Host: []string{path}, SD: Namespace})
+	hosts, err := s.Hosts()
+	if err != nil {
+		t.Error("getting the hosts:", err.Error())
+		return
+	}
+	if len(hosts) != 2 {
+		t.Errorf("wrong number of hosts: %v", hosts)
+		return
+	}
+	if hosts[0] != "http://127.0.0.1:8080" {
+		t.Error("wrong host #0:", hosts[0])
+	}
+	if hosts[1] != "https://127.0.0.1:8081" {
+		t.Error("wrong host #1:", hosts[1])
+	}
+	s.(*Subscriber).Close()
+}
+
+func TestSubscriber_weightsAndMetadata(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	for name, content := range map[string]string{
+		"hosts.json": `{"hosts": [
+			"http://127.0.0.1:8080",
+			{"host": "http://127.0.0.1:8081", "weight": 3, "metadata": {"zone": "a"}}
+		]}`,
+		"hosts.yml": `
+hosts:
+  - http://127.0.0.1:8080
+  - host: http://127.0.0.1:8081
+    weight: 3
+    metadata:
+      zone: a
+`,
+	} {
+		path := filepath.Join(dir, name)
+		writeFile(t, path, content)
+
+		s := NewDetailed(context.Background(), path, 0, logging.NoOp)
+		hosts, err := s.WeightedHosts()
+		if err != nil {
+			t.Errorf("%s: getting the hosts: %s", name, err.Error())
+			continue
+		}
+		expected := []sd.WeightedHost{
+			{Host: "http://127.0.0.1:8080", Weight: 1},
+			{Host: "http://127.0.0.1:8081", Weight: 3},
+		}
+		if len(hosts) != len(expected) {
+			t.Errorf("%s: unexpected hosts: %v", name, hosts)
+			continue
+		}
+		for i, h := range expected {
+			if hosts[i] != h {
+				t.Errorf("%s: unexpected host #%d: %v", name, i, hosts[i])
+			}
+		}
+		instances := s.Instances()
+		if v, ok := instances[1].Metadata["zone"]; !ok || v != "a" {
+			t.Errorf("%s: unexpected metadata: %v", name, instances[1].Metadata)
+		}
+		if instances[0].Metadata != nil {
+			t.Errorf("%s: unexpected metadata: %v", name, instances[0].Metadata)
+		}
+	}
+}
+
+func TestSubscriber_reload(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "hosts.json")
+	writeFile(t, path, `["http://127.0.0.1:8080"]`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := NewDetailed(ctx, path, 10*time.Millisecond, logging.NoOp)
+
+	if hosts, _ := s.Hosts(); len(hosts) != 1 {
+		t.Errorf("unexpected hosts: %v", hosts)
+		return
+	}
+
+	writeFile(t, path, `["http://127.0.0.1:8080", "http://127.0.0.1:8081"]`)
+	if !waitForHosts(s, 2) {
+		hosts, _ := s.Hosts()
+		t.Errorf("the file has not been reloaded: %v", hosts)
+		return
+	}
+
+	writeFile(t, path, `["http://127.0.0.1:8080", `)
+	time.Sleep(50 * time.Millisecond)
+	if hosts, _ := s.Hosts(); len(hosts) != 2 {
+		t.Errorf("the last good list of hosts has not been kept: %v", hosts)
+	}
+
+	writeFile(t, path, `["http://127.0.0.1:8082"]`)
+	if !waitForHosts(s, 1) {
+		hosts, _ := s.Hosts()
+		t.Errorf("the file has not been reloaded: %v", hosts)
+	}
+}
+
+func TestSubscriber_invalidFile(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	for _, content := range []string{
+		`{"hosts": "http://127.0.0.1:8080"}`,
+		`[42]`,
+		`[{"weight": 2}]`,
+		`[{"host": "http://127.0.0.1:8080", "weight": "heavy"}]`,
+		`not json`,
+	} {
+		path := filepath.Join(dir, "hosts.json")
+		writeFile(t, path, content)
+		s := NewDetailed(context.Background(), path, 0, logging.NoOp)
+		if hosts, err := s.Hosts(); err != nil || len(hosts) != 0 {
+			t.Errorf("%s: unexpected result: %v, %v", content, hosts, err)
+		}
+	}
+
+	s := NewDetailed(context.Background(), filepath.Join(dir, "unknown.json"), 0, logging.NoOp)
+	if hosts, err := s.Hosts(); err != nil || len(hosts) != 0 {
+		t.Errorf("unexpected result: %v, %v", hosts, err)
+	}
+}
+
+func waitForHosts(s *Subscriber, n int) bool {
+	for i := 0; i < 100; i++ {
+		if hosts, _ := s.Hosts(); len(hosts) == n {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "krakend-sd-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func writeFile(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,212 @@
+package sd
+
+import (
+	"hash/crc32"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultHashReplicas is the default number of points of every host in the hash ring
+const DefaultHashReplicas = 100
+
+// HashKeyFunc extracts the key used for selecting the host from the request
+type HashKeyFunc func(*Request) string
+
+// NewHashKeyFunc returns a HashKeyFunc for the received source. The supported sources are
+// "header:<name>", "param:<name>", "cookie:<name>", "ip" and "path". The client IP is taken from
+// the X-Forwarded-For header added by the routers. The headers and the cookies are only available
+// if they are passed to the backends (see the headers_to_pass option of the endpoint).
+func NewHashKeyFunc(source string) HashKeyFunc {
+	kind, name := source, ""
+	if i := strings.Index(source, ":"); i > 0 {
+		kind, name = source[:i], source[i+1:]
+	}
+	switch strings.ToLower(kind) {
+	case "header":
+		return func(r *Request) string {
+			return firstHeader(r, name)
+		}
+	case "param":
+		return func(r *Request) string {
+			if r == nil {
+				return ""
+			}
+			if v, ok := r.Params[name]; ok {
+				return v
+			}
+			return r.Params[strings.Title(name)]
+		}
+	case "cookie":
+		return func(r *Request) string {
+			if r == nil {
+				return ""
+			}
+			c, err := (&http.Request{Header: r.Headers}).Cookie(name)
+			if err != nil {
+				return ""
+			}
+			return c.Value
+		}
+	case "ip":
+		return func(r *Request) string {
+			ip := firstHeader(r, "X-Forwarded-For")
+			if i := strings.Index(ip, ","); i >= 0 {
+				ip = ip[:i]
+			}
+			return strings.TrimSpace(ip)
+		}
+	case "path":
+		return func(r *Request) string {
+			if r == nil {
+				return ""
+			}
+			return r.Path
+		}
+	}
+	return func(_ *Request) string { return "" }
+}
+
+func firstHeader(r *Request, name string) string {
+	if r == nil {
+		return ""
+	}
+	vs, ok := r.Headers[name]
+	if !ok {
+		vs = r.Headers[textproto.CanonicalMIMEHeaderKey(name)]
+	}
+	if len(vs) == 0 {
+		return ""
+	}
+	return vs[0]
+}
+
+// NewConsistentHashLB returns a new balancer selecting the host with a consistent hash of the key
+// extracted from the request, so the requests with the same key are sent to the same host while it
+// is available. Every host gets as many points in the ring as the number of replicas times its
+// weight. The requests without key are balanced with a round robin strategy. The hosts excluded by
+// the request are skipped, moving to the next host of the ring.
+func NewConsistentHashLB(subscriber Subscriber, key HashKeyFunc, replicas int) Balancer {
+	if replicas <= 0 {
+		replicas = DefaultHashReplicas
+	}
+	return &consistentHashLB{
+		subscriber: subscriber,
+		key:        key,
+		replicas:   replicas,
+		fallback:   NewRoundRobinLB(subscriber),
+	}
+}
+
+type consistentHashLB struct {
+	subscriber Subscriber
+	key        HashKeyFunc
+	replicas   int
+	fallback   Balancer
+
+	mu   sync.RWMutex
+	ring *hashRing
+}
+
+// Host implements the balancer interface
+func (c *consistentHashLB) Host(r *Request) (string, error) {
+	key := c.key(r)
+	if key == "" {
+		return c.fallback.Host(r)
+	}
+	hosts, err := getWeightedHosts(c.subscriber)
+	if err != nil {
+		return "", err
+	}
+	if len(hosts) <= 0 {
+		return "", ErrNoHosts
+	}
+	var excluded map[string]struct{}
+	if r != nil {
+		excluded = r.Excluded
+	}
+	return c.getRing(hosts).Get(key, excluded), nil
+}
+
+// getRing returns the ring of the received hosts, rebuilding it if the hosts have changed
+func (c *consistentHashLB) getRing(hosts []WeightedHost) *hashRing {
+	c.mu.RLock()
+	ring := c.ring
+	c.mu.RUnlock()
+	if ring != nil && ring.builtWith(hosts) {
+		return ring
+	}
+
+	ring = newHashRing(hosts, c.replicas)
+	c.mu.Lock()
+	c.ring = ring
+	c.mu.Unlock()
+	return ring
+}
+
+type hashRing struct {
+	source []WeightedHost
+	points []uint32
+	hosts  map[uint32]string
+}
+
+func newHashRing(hosts []WeightedHost, replicas int) *hashRing {
+	r := &hashRing{source: make([]WeightedHost, len(hosts)), hosts: map[uint32]string{}}
+	copy(r.source, hosts)
+	sorted := make([]WeightedHost, len(hosts))
+	copy(sorted, hosts)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Host < sorted[j].Host })
+	for _, h := range sorted {
+		for i := 0; i < replicas*h.Weight; i++ {
+			p := hashKey(strconv.Itoa(i) + h.Host)
+			if _, ok := r.hosts[p]; ok {
+				continue
+			}
+			r.hosts[p] = h.Host
+			r.points = append(r.points, p)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// builtWith reports whether the ring was built with the received hosts, in the same order
+func (r *hashRing) builtWith(hosts []WeightedHost) bool {
+	if len(hosts) != len(r.source) {
+		return false
+	}
+	for i, h := range hosts {
+		if h != r.source[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Get returns the host owning the first point of the ring after the hash of the key, skipping the
+// excluded hosts unless all of them are excluded
+func (r *hashRing) Get(key string, excluded map[string]struct{}) string {
+	h := hashKey(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	first := r.hosts[r.points[i]]
+	if len(excluded) == 0 {
+		return first
+	}
+	for j := 0; j < len(r.points); j++ {
+		host := r.hosts[r.points[(i+j)%len(r.points)]]
+		if _, ok := excluded[host]; !ok {
+			return host
+		}
+	}
+	return first
+}
+
+func hashKey(key string) uint32 {
+	return crc32.ChecksumIEEE([]byte(key))
+}
@@ -0,0 +1,122 @@
+package sd
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestConsistentHashLB(t *testing.T) {
+	subscriber := FixedSubscriber{"a", "b", "c", "d"}
+	balancer := NewConsistentHashLB(subscriber, NewHashKeyFunc("header:X-User"), 0)
+
+	assigned := map[string]string{}
+	counts := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		user := fmt.Sprintf("user-%d", i)
+		host, err := balancer.Host(&Request{Headers: map[string][]string{"X-User": {user}}})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		assigned[user] = host
+		counts[host]++
+	}
+	for _, h := range subscriber {
+		if counts[h] < 150 {
+			t.Errorf("unbalanced distribution: %v", counts)
+		}
+	}
+
+	for user, host := range assigned {
+		h, _ := balancer.Host(&Request{Headers: map[string][]string{"X-User": {user}}})
+		if h != host {
+			t.Errorf("%s: unexpected host %s, want %s", user, h, host)
+		}
+	}
+
+	// removing a host only moves the keys it owned
+	balancer = NewConsistentHashLB(FixedSubscriber{"a", "b", "c"}, NewHashKeyFunc("header:X-User"), 0)
+	for user, host := range assigned {
+		h, _ := balancer.Host(&Request{Headers: map[string][]string{"X-User": {user}}})
+		if host != "d" && h != host {
+			t.Errorf("%s: moved from %s to %s", user, host, h)
+		}
+	}
+}
+
+func TestConsistentHashLB_excluded(t *testing.T) {
+	balancer := NewConsistentHashLB(FixedSubscriber{"a", "b", "c"}, NewHashKeyFunc("header:X-User"), 0)
+	r := &Request{Headers: map[string][]string{"X-User": {"user-1"}}}
+	excluded := map[string]struct{}{}
+	for i := 0; i < 3; i++ {
+		r.Excluded = excluded
+		host, err := balancer.Host(r)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if _, ok := excluded[host]; ok {
+			t.Errorf("#%d: excluded host returned: %s", i, host)
+		}
+		excluded[host] = struct{}{}
+	}
+
+	r.Excluded = excluded
+	if _, err := balancer.Host(r); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
+
+func TestConsistentHashLB_noKey(t *testing.T) {
+	balancer := NewConsistentHashLB(FixedSubscriber{"a", "b"}, NewHashKeyFunc("header:X-User"), 0)
+	counts := map[string]int{}
+	for i := 0; i < 10; i++ {
+		host, err := balancer.Host(nil)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		counts[host]++
+	}
+	if counts["a"] != 5 || counts["b"] != 5 {
+		t.Errorf("unexpected distribution: %v", counts)
+	}
+}
+
+func TestConsistentHashLB_noEndpoints(t *testing.T) {
+	balancer := NewConsistentHashLB(FixedSubscriber{}, NewHashKeyFunc("ip"), 0)
+	_, err := balancer.Host(&Request{Headers: map[string][]string{"X-Forwarded-For": {"10.0.0.1"}}})
+	if err != ErrNoHosts {
+		t.Errorf("want %v, have %v", ErrNoHosts, err)
+	}
+}
+
+func TestNewHashKeyFunc(t *testing.T) {
+	request := &Request{
+		Path:   "/users/42",
+		Params: map[string]string{"Id": "42"},
+		Headers: map[string][]string{
+			"X-User":          {"bob"},
+			"Cookie":          {"session=abc; theme=dark"},
+			"X-Forwarded-For": {"10.0.0.1, 10.0.0.2"},
+		},
+	}
+	for source, expected := range map[string]string{
+		"header:x-user":   "bob",
+		"param:id":        "42",
+		"param:Id":        "42",
+		"cookie:session":  "abc",
+		"cookie:missing":  "",
+		"ip":              "10.0.0.1",
+		"path":            "/users/42",
+		"unknown:source":  "",
+		"header:X-Absent": "",
+	} {
+		if v := NewHashKeyFunc(source)(request); v != expected {
+			t.Errorf("%s: want %q, have %q", source, expected, v)
+		}
+		if v := NewHashKeyFunc(source)(nil); v != "" {
+			t.Errorf("%s: unexpected value for a nil request: %q", source, v)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package sd
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HealthReporter is implemented by the subscribers tracking the health of their hosts with the
+// results of the requests sent to them
+type HealthReporter interface {
+	ReportSuccess(host string)
+	ReportFailure(host string)
+}
+
+// HealthCheckConfig contains the options of the HealthCheckSubscriber.
+//
+// The hosts are probed with a GET request to the Path every Interval if the Path is not empty. The
+// probes failing or returning a status code out of the 2xx and 3xx ranges mark the host as
+// unhealthy until a probe succeeds.
+//
+// A host is also ejected for the CoolDown period after MaxFailures consecutive failures reported
+// by the proxy layer. A MaxFailures value of 0 disables the passive ejection.
+//
+// If the percentage of healthy hosts falls below the PanicThreshold, all the hosts are considered
+// healthy, so the traffic is spread over all of them instead of overloading the remaining ones.
+type HealthCheckConfig struct {
+	Path           string
+	Interval       time.Duration
+	Timeout        time.Duration
+	MaxFailures    int
+	CoolDown       time.Duration
+	PanicThreshold float64
+	Client         *http.Client
+}
+
+// NewHealthCheckSubscriber decorates the received subscriber, so it only returns the healthy hosts.
+// The active probing runs in background until the context is canceled or the subscriber is closed.
+func NewHealthCheckSubscriber(ctx context.Context, subscriber Subscriber, cfg HealthCheckConfig) *HealthCheckSubscriber {
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: cfg.Timeout}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	h := &HealthCheckSubscriber{
+		subscriber: subscriber,
+		cfg:        cfg,
+		hosts:      map[string]*hostHealth{},
+		cancel:     cancel,
+		now:        time.Now,
+	}
+	if cfg.Path != "" && cfg.Interval > 0 {
+		go h.loop(ctx)
+	}
+	return h
+}
+
+// HealthCheckSubscriber is a subscriber filtering the unhealthy hosts of the decorated one
+type HealthCheckSubscriber struct {
+	subscriber Subscriber
+	cfg        HealthCheckConfig
+	mu         sync.RWMutex
+	hosts      map[string]*hostHealth
+	cancel     context.CancelFunc
+	now        func() time.Time
+}
+
+type hostHealth struct {
+	probeFailed  bool
+	failures     int
+	ejectedUntil time.Time
+}
+
+// Hosts implements the Subscriber interface
+func (h *HealthCheckSubscriber) Hosts() ([]string, error) {
+	if ps, ok := h.subscriber.(PrioritizedSubscriber); ok {
+		hosts, err := h.prioritizedHosts(ps)
+		if err != nil {
+			return nil, err
+		}
+		res := make([]string, len(hosts))
+		for i, host := range hosts {
+			res[i] = host.Host
+		}
+		return res, nil
+	}
+	hosts, err := h.subscriber.Hosts()
+	if err != nil {
+		return hosts, err
+	}
+	healthy := make([]string, 0, len(hosts))
+	h.mu.RLock()
+	now := h.now()
+	for _, host := range hosts {
+		if h.isHealthy(host, now) {
+			healthy = append(healthy, host)
+		}
+	}
+	h.mu.RUnlock()
+	if h.isPanicking(len(healthy), len(hosts)) {
+		return hosts, nil
+	}
+	return healthy, nil
+}
+
+// WeightedHosts implements the WeightedSubscriber interface, keeping the weights of the decorated
+// subscriber
+func (h *HealthCheckSubscriber) WeightedHosts() ([]WeightedHost, error) {
+	if ps, ok := h.subscriber.(PrioritizedSubscriber); ok {
+		return h.prioritizedHosts(ps)
+	}
+	hosts, err := getWeightedHosts(h.subscriber)
+	if err != nil {
+		return hosts, err
+	}
+	healthy, _ := h.filter(hosts)
+	return healthy, nil
+}
+
+// prioritizedHosts returns the healthy hosts of the preferred priority group not in panic mode. If
+// every group is panicking, all the hosts of the preferred one are returned.
+func (h *HealthCheckSubscriber) prioritizedHosts(ps PrioritizedSubscriber) ([]WeightedHost, error) {
+	hosts, err := ps.PrioritizedHosts()
+	if err != nil {
+		return nil, err
+	}
+	groups := GroupByPriority(hosts)
+	if len(groups) == 0 {
+		return []WeightedHost{}, nil
+	}
+	for _, group := range groups {
+		if healthy, panicking := h.filter(group); !panicking {
+			return healthy, nil
+		}
+	}
+	return groups[0], nil
+}
+
+func (h *HealthCheckSubscriber) filter(hosts []WeightedHost) ([]WeightedHost, bool) {
+	healthy := make([]WeightedHost, 0, len(hosts))
+	h.mu.RLock()
+	now := h.now()
+	for _, host := range hosts {
+		if h.isHealthy(host.Host, now) {
+			healthy = append(healthy, host)
+		}
+	}
+	h.mu.RUnlock()
+	if h.isPanicking(len(healthy), len(hosts)) {
+		return hosts, true
+	}
+	return healthy, false
+}
+
+// ReportSuccess implements the HealthReporter interface
+func (h *HealthCheckSubscriber) ReportSuccess(host string) {
+	if h.cfg.MaxFailures <= 0 {
+		return
+	}
+	h.mu.Lock()
+	if s, ok := h.hosts[host]; ok {
+		s.failures = 0
+	}
+	h.mu.Unlock()
+}
+
+// ReportFailure implements the HealthReporter interface
+func (h *HealthCheckSubscriber) ReportFailure(host string) {
+	if h.cfg.MaxFailures <= 0 {
+		return
+	}
+	h.mu.Lock()
+	s := h.get(host)
+	s.failures++
+	if s.failures >= h.cfg.MaxFailures {
+		s.failures = 0
+		s.ejectedUntil = h.now().Add(h.cfg.CoolDown)
+	}
+	h.mu.Unlock()
+}
+
+// Close stops the active probing
+func (h *HealthCheckSubscriber) Close() error {
+	h.cancel()
+	return nil
+}
+
+func (h *HealthCheckSubscriber) get(host string) *hostHealth {
+	s, ok := h.hosts[host]
+	if !ok {
+		s = &hostHealth{}
+		h.hosts[host] = s
+	}
+	return s
+}
+
+func (h *HealthCheckSubscriber) isHealthy(host string, now time.Time) bool {
+	s, ok := h.hosts[host]
+	return !ok || (!s.probeFailed && !now.Before(s.ejectedUntil))
+}
+
+// isPanicking reports whether there are too few healthy hosts. It never allows to eject all of them.
+func (h *HealthCheckSubscriber) isPanicking(healthy, total int) bool {
+	if total == 0 {
+		return false
+	}
+	return healthy == 0 || float64(healthy*100) < h.cfg.PanicThreshold*float64(total)
+}
+
+func (h *HealthCheckSubscriber) loop(ctx context.Context) {
+	ticker := time.NewTicker(h.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		h.probe(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe checks all the hosts of the decorated subscriber and forgets the ones no longer listed
+func (h *HealthCheckSubscriber) probe(ctx context.Context) {
+	hosts, err := h.allHosts()
+	if err != nil {
+		return
+	}
+	results := make([]bool, len(hosts))
+	wg := sync.WaitGroup{}
+	wg.Add(len(hosts))
+	for i, host := range hosts {
+		go func(i int, host string) {
+			results[i] = h.check(ctx, host)
+			wg.Done()
+		}(i, host)
+	}
+	wg.Wait()
+
+	h.mu.Lock()
+	current := make(map[string]*hostHealth, len(hosts))
+	for i, host := range hosts {
+		s := h.get(host)
+		s.probeFailed = !results[i]
+		current[host] = s
+	}
+	h.hosts = current
+	h.mu.Unlock()
+}
+
+// allHosts returns the hosts of the decorated subscriber, including all the priority groups
+func (h *HealthCheckSubscriber) allHosts() ([]string, error) {
+	ps, ok := h.subscriber.(PrioritizedSubscriber)
+	if !ok {
+		return h.subscriber.Hosts()
+	}
+	hosts, err := ps.PrioritizedHosts()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, len(hosts))
+	for i, host := range hosts {
+		res[i] = host.Host
+	}
+	return res, nil
+}
+
+func (h *HealthCheckSubscriber) check(ctx context.Context, host string) bool {
+	req, err := http.NewRequest(http.MethodGet, host+h.cfg.Path, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := h.cfg.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusBadRequest
+}
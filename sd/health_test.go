@@ -0,0 +1,164 @@
+package sd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthCheckSubscriber_passiveEjection(t *testing.T) {
+	h := NewHealthCheckSubscriber(context.Background(), FixedSubscriber{"a", "b", "c"}, HealthCheckConfig{
+		MaxFailures: 2,
+		CoolDown:    time.Minute,
+	})
+	defer h.Close()
+	now := time.Now()
+	h.now = func() time.Time { return now }
+
+	h.ReportFailure("a")
+	h.ReportSuccess("a")
+	h.ReportFailure("a")
+	assertHosts(t, h, []string{"a", "b", "c"})
+
+	h.ReportFailure("a")
+	assertHosts(t, h, []string{"b", "c"})
+
+	now = now.Add(time.Minute)
+	assertHosts(t, h, []string{"a", "b", "c"})
+}
+
+func TestHealthCheckSubscriber_panicThreshold(t *testing.T) {
+	h := NewHealthCheckSubscriber(context.Background(), FixedSubscriber{"a", "b", "c", "d"}, HealthCheckConfig{
+		MaxFailures:    1,
+		CoolDown:       time.Minute,
+		PanicThreshold: 50,
+	})
+	defer h.Close()
+
+	h.ReportFailure("a")
+	h.ReportFailure("b")
+	assertHosts(t, h, []string{"c", "d"})
+
+	h.ReportFailure("c")
+	assertHosts(t, h, []string{"a", "b", "c", "d"})
+}
+
+func TestHealthCheckSubscriber_neverEjectsEverything(t *testing.T) {
+	h := NewHealthCheckSubscriber(context.Background(), FixedSubscriber{"a", "b"}, HealthCheckConfig{
+		MaxFailures: 1,
+		CoolDown:    time.Minute,
+	})
+	defer h.Close()
+
+	h.ReportFailure("a")
+	h.ReportFailure("b")
+	assertHosts(t, h, []string{"a", "b"})
+}
+
+func TestHealthCheckSubscriber_activeProbing(t *testing.T) {
+	var healthy int32 = 1
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		if atomic.LoadInt32(&healthy) == 1 {
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ok.Close()
+	unreachable := "http://127.0.0.1:1"
+
+	h := NewHealthCheckSubscriber(context.Background(), FixedSubscriber{failing.URL, ok.URL, unreachable}, HealthCheckConfig{
+		Path:     "/health",
+		Interval: 10 * time.Millisecond,
+		Timeout:  100 * time.Millisecond,
+	})
+	defer h.Close()
+
+	waitForHosts(t, h, []string{failing.URL, ok.URL})
+
+	atomic.StoreInt32(&healthy, 0)
+	waitForHosts(t, h, []string{ok.URL})
+
+	atomic.StoreInt32(&healthy, 1)
+	waitForHosts(t, h, []string{failing.URL, ok.URL})
+}
+
+func TestHealthCheckSubscriber_weightedHosts(t *testing.T) {
+	h := NewHealthCheckSubscriber(context.Background(), FixedWeightedSubscriber{
+		{Host: "a", Weight: 3},
+		{Host: "b", Weight: 1},
+	}, HealthCheckConfig{MaxFailures: 1, CoolDown: time.Minute})
+	defer h.Close()
+
+	h.ReportFailure("b")
+	hosts, err := h.WeightedHosts()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if !reflect.DeepEqual(hosts, []WeightedHost{{Host: "a", Weight: 3}}) {
+		t.Errorf("unexpected hosts: %v", hosts)
+	}
+}
+
+func TestHealthCheckSubscriber_priorityFailover(t *testing.T) {
+	h := NewHealthCheckSubscriber(context.Background(), prioritizedSubscriber{
+		{Host: "c", Weight: 1, Priority: 2},
+		{Host: "a", Weight: 2, Priority: 1},
+		{Host: "b", Weight: 1, Priority: 1},
+	}, HealthCheckConfig{MaxFailures: 1, CoolDown: time.Minute, PanicThreshold: 50})
+	defer h.Close()
+
+	assertHosts(t, h, []string{"a", "b"})
+
+	h.ReportFailure("a")
+	assertHosts(t, h, []string{"b"})
+
+	h.ReportFailure("b")
+	assertHosts(t, h, []string{"c"})
+
+	h.ReportFailure("c")
+	assertHosts(t, h, []string{"a", "b"})
+}
+
+type prioritizedSubscriber []PrioritizedHost
+
+func (p prioritizedSubscriber) Hosts() ([]string, error) { return nil, nil }
+
+func (p prioritizedSubscriber) WeightedHosts() ([]WeightedHost, error) { return nil, nil }
+
+func (p prioritizedSubscriber) PrioritizedHosts() ([]PrioritizedHost, error) { return p, nil }
+
+func assertHosts(t *testing.T, s Subscriber, expected []string) {
+	hosts, err := s.Hosts()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("unexpected hosts. have: %v, want: %v", hosts, expected)
+	}
+}
+
+func waitForHosts(t *testing.T, s Subscriber, expected []string) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		hosts, _ := s.Hosts()
+		if reflect.DeepEqual(hosts, expected) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("unexpected hosts. have: %v, want: %v", hosts, expected)
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
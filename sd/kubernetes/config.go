@@ -0,0 +1,221 @@
+package kubernetes
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	defaultNamespace  = "default"
+)
+
+// ErrNotInCluster is returned when the in-cluster config is requested out of a pod
+var ErrNotInCluster = errors.New("kubernetes: not running inside a cluster")
+
+// ClusterConfig contains the location of the API server and the credentials of the subscriber
+type ClusterConfig struct {
+	// Server is the base URL of the API server
+	Server string
+	// Token is the bearer token sent with the requests
+	Token string
+	// TokenFile is a file containing the bearer token. It is read before every request, so the
+	// rotated tokens are picked up. It takes precedence over the Token.
+	TokenFile string
+	// Namespace is the namespace of the services without an explicit one
+	Namespace string
+	// TLSConfig is the TLS config of the connections to the API server
+	TLSConfig *tls.Config
+}
+
+// DefaultClusterConfig returns the in-cluster config when running inside a pod. Otherwise, it
+// loads the kubeconfig at the received path or, if empty, the one defined by the KUBECONFIG env
+// var or the default ~/.kube/config.
+func DefaultClusterConfig(kubeconfig string) (ClusterConfig, error) {
+	cfg, err := InClusterConfig()
+	if err != ErrNotInCluster {
+		return cfg, err
+	}
+	if kubeconfig == "" {
+		kubeconfig = os.Getenv("KUBECONFIG")
+	}
+	if kubeconfig == "" {
+		kubeconfig = filepath.Join(os.Getenv("HOME"), ".kube", "config")
+	}
+	return LoadKubeconfig(kubeconfig)
+}
+
+// InClusterConfig returns the config of the service account of the pod running the gateway
+func InClusterConfig() (ClusterConfig, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return ClusterConfig{}, ErrNotInCluster
+	}
+	cfg := ClusterConfig{
+		Server:    "https://" + net.JoinHostPort(host, port),
+		TokenFile: filepath.Join(serviceAccountDir, "token"),
+		Namespace: defaultNamespace,
+	}
+	if _, err := os.Stat(cfg.TokenFile); err != nil {
+		return ClusterConfig{}, err
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "namespace")); err == nil {
+		cfg.Namespace = strings.TrimSpace(string(b))
+	}
+	ca, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return ClusterConfig{}, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return ClusterConfig{}, errors.New("kubernetes: invalid CA of the service account")
+	}
+	cfg.TLSConfig = &tls.Config{RootCAs: pool}
+	return cfg, nil
+}
+
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Contexts       []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			User      string `yaml:"user"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Clusters []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string `yaml:"token"`
+			TokenFile             string `yaml:"tokenFile"`
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+// LoadKubeconfig returns the config of the current context of a kubeconfig file. Only the token
+// and the client certificate authentications are supported.
+func LoadKubeconfig(path string) (ClusterConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ClusterConfig{}, err
+	}
+	kc := kubeconfig{}
+	if err := yaml.Unmarshal(b, &kc); err != nil {
+		return ClusterConfig{}, err
+	}
+	base := filepath.Dir(path)
+
+	cfg := ClusterConfig{Namespace: defaultNamespace}
+	var clusterName, userName string
+	found := false
+	for _, c := range kc.Contexts {
+		if c.Name == kc.CurrentContext {
+			clusterName, userName = c.Context.Cluster, c.Context.User
+			if c.Context.Namespace != "" {
+				cfg.Namespace = c.Context.Namespace
+			}
+			found = true
+			break
+		}
+	}
+	if !found {
+		return ClusterConfig{}, fmt.Errorf("kubernetes: context %q not found in %s", kc.CurrentContext, path)
+	}
+
+	tlsConfig := &tls.Config{}
+	found = false
+	for _, c := range kc.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+		cfg.Server = strings.TrimRight(c.Cluster.Server, "/")
+		tlsConfig.InsecureSkipVerify = c.Cluster.InsecureSkipTLSVerify
+		ca, err := readData(c.Cluster.CertificateAuthorityData, c.Cluster.CertificateAuthority, base)
+		if err != nil {
+			return ClusterConfig{}, err
+		}
+		if ca != nil {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(ca) {
+				return ClusterConfig{}, fmt.Errorf("kubernetes: invalid CA of the cluster %q", clusterName)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		found = true
+		break
+	}
+	if !found {
+		return ClusterConfig{}, fmt.Errorf("kubernetes: cluster %q not found in %s", clusterName, path)
+	}
+
+	for _, u := range kc.Users {
+		if u.Name != userName {
+			continue
+		}
+		cfg.Token = u.User.Token
+		if u.User.TokenFile != "" {
+			cfg.TokenFile = resolvePath(u.User.TokenFile, base)
+		}
+		cert, err := readData(u.User.ClientCertificateData, u.User.ClientCertificate, base)
+		if err != nil {
+			return ClusterConfig{}, err
+		}
+		key, err := readData(u.User.ClientKeyData, u.User.ClientKey, base)
+		if err != nil {
+			return ClusterConfig{}, err
+		}
+		if cert != nil && key != nil {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return ClusterConfig{}, err
+			}
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+		break
+	}
+	cfg.TLSConfig = tlsConfig
+	return cfg, nil
+}
+
+// readData returns the decoded inline data or the content of the file, if any
+func readData(data, file, base string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if file != "" {
+		return ioutil.ReadFile(resolvePath(file, base))
+	}
+	return nil, nil
+}
+
+// resolvePath resolves the paths relative to the kubeconfig file
+func resolvePath(path, base string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(base, path)
+}
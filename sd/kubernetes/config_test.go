@@ -0,0 +1,126 @@
+package kubernetes
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const kubeconfigTmpl = `apiVersion: v1
+kind: Config
+current-context: dev
+contexts:
+- name: other
+  context:
+    cluster: other
+    user: other
+- name: dev
+  context:
+    cluster: local
+    user: krakend
+    namespace: production
+clusters:
+- name: other
+  cluster:
+    server: https://other.example.tld
+- name: local
+  cluster:
+    server: %s/
+    insecure-skip-tls-verify: true
+users:
+- name: other
+  user:
+    token: other
+- name: krakend
+  user:
+    token: secret
+    tokenFile: token
+`
+
+func TestLoadKubeconfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "krakend-sd-kubernetes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config")
+	writeFile(t, path, fmt.Sprintf(kubeconfigTmpl, "https://127.0.0.1:6443"))
+
+	cfg, err := LoadKubeconfig(path)
+	if err != nil {
+		t.Error("unexpected error:", err.Error())
+		return
+	}
+	if cfg.Server != "https://127.0.0.1:6443" {
+		t.Error("unexpected server:", cfg.Server)
+	}
+	if cfg.Namespace != "production" {
+		t.Error("unexpected namespace:", cfg.Namespace)
+	}
+	if cfg.Token != "secret" {
+		t.Error("unexpected token:", cfg.Token)
+	}
+	if cfg.TokenFile != filepath.Join(dir, "token") {
+		t.Error("unexpected token file:", cfg.TokenFile)
+	}
+	if cfg.TLSConfig == nil || !cfg.TLSConfig.InsecureSkipVerify {
+		t.Errorf("unexpected tls config: %v", cfg.TLSConfig)
+	}
+}
+
+func TestLoadKubeconfig_ko(t *testing.T) {
+	dir, err := ioutil.TempDir("", "krakend-sd-kubernetes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i, content := range []string{
+		"current-context: unknown\n",
+		"current-context: dev\ncontexts:\n- name: dev\n  context:\n    cluster: unknown\n",
+		"current-context: dev\ncontexts:\n- name: dev\n  context:\n    cluster: local\nclusters:\n- name: local\n  cluster:\n    certificate-authority-data: bm90IGEgY2VydA==\n",
+		"not: [valid",
+	} {
+		path := filepath.Join(dir, fmt.Sprintf("config%d", i))
+		writeFile(t, path, content)
+		if _, err := LoadKubeconfig(path); err == nil {
+			t.Errorf("#%d: error expected", i)
+		}
+	}
+
+	if _, err := LoadKubeconfig(filepath.Join(dir, "unknown")); err == nil {
+		t.Error("error expected")
+	}
+}
+
+func TestDefaultClusterConfig(t *testing.T) {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		t.Skip("running inside a cluster")
+	}
+	if _, err := InClusterConfig(); err != ErrNotInCluster {
+		t.Error("unexpected error:", err)
+	}
+
+	dir, err := ioutil.TempDir("", "krakend-sd-kubernetes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config")
+	writeFile(t, path, fmt.Sprintf(kubeconfigTmpl, "https://127.0.0.1:6443"))
+
+	kubeconfig := os.Getenv("KUBECONFIG")
+	defer os.Setenv("KUBECONFIG", kubeconfig)
+	os.Setenv("KUBECONFIG", path)
+
+	cfg, err := DefaultClusterConfig("")
+	if err != nil {
+		t.Error("unexpected error:", err.Error())
+		return
+	}
+	if cfg.Server != "https://127.0.0.1:6443" {
+		t.Error("unexpected server:", cfg.Server)
+	}
+}
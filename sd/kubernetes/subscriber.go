@@ -0,0 +1,433 @@
+// Package kubernetes defines a service discovery subscriber watching the EndpointSlices of a
+// Kubernetes service
+//
+// The service is the first host of the backend, optionally followed by its namespace
+// (service.namespace). The scheme added by the host sanitization is ignored. The rest of the options
+// are set in the extra config of the backend:
+//
+//	"sd": "kubernetes",
+//	"host": ["users.production"],
+//	"extra_config": {
+//		"github.com/vm-affekt/krakend/sd/kubernetes": {
+//			"port": "http",
+//			"scheme": "http",
+//			"kubeconfig": "/home/krakend/.kube/config"
+//		}
+//	}
+//
+// The in-cluster credentials of the service account are used when running inside a pod.
+// Otherwise, the current context of the kubeconfig is used.
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vm-affekt/krakend/config"
+	"github.com/vm-affekt/krakend/sd"
+)
+
+const (
+	// Namespace is the key for the kubernetes sd module
+	Namespace = "kubernetes"
+	// ConfigNamespace is the key of the options of the kubernetes subscriber in the extra config of
+	// the backends
+	ConfigNamespace = "github.com/vm-affekt/krakend/sd/kubernetes"
+
+	serviceNameLabel = "kubernetes.io/service-name"
+)
+
+var (
+	// RetryInterval is the time to wait after a failed request to the API server
+	RetryInterval = time.Second
+	// ListTimeout is the max duration of the requests listing the EndpointSlices
+	ListTimeout = 10 * time.Second
+	// WatchTimeout is the max duration of the watch requests. The API server closes the watches
+	// after this time, so they are resumed with a new request.
+	WatchTimeout = 5 * time.Minute
+)
+
+var errGone = errors.New("kubernetes: resource version too old")
+
+// Register registers the kubernetes sd subscriber factory. The subscribers it creates keep a watch
+// open against the API server until they are closed, so the proxy factories must close them when
+// the proxies are discarded (see proxy.NewDefaultFactoryWithContext).
+func Register() error {
+	return sd.RegisterSubscriberFactory(Namespace, SubscriberFactory)
+}
+
+// RegisterWithContext registers a kubernetes sd subscriber factory whose subscribers stop
+// watching the API server when the context is canceled
+func RegisterWithContext(ctx context.Context) error {
+	return sd.RegisterSubscriberFactory(Namespace, SubscriberFactoryWithContext(ctx))
+}
+
+// SubscriberFactory builds a kubernetes Subscriber with the received config
+func SubscriberFactory(cfg *config.Backend) sd.Subscriber {
+	return SubscriberFactoryWithContext(context.Background())(cfg)
+}
+
+// SubscriberFactoryWithContext returns a SubscriberFactory building subscribers bound to the
+// context. If the credentials can not be loaded, the subscribers return the error.
+func SubscriberFactoryWithContext(ctx context.Context) sd.SubscriberFactory {
+	return func(remote *config.Backend) sd.Subscriber {
+		cfg, kubeconfig := parseConfig(remote)
+		cluster, err := DefaultClusterConfig(kubeconfig)
+		if err != nil {
+			return sd.SubscriberFunc(func() ([]string, error) { return nil, err })
+		}
+		return New(ctx, cluster, cfg)
+	}
+}
+
+// Config contains the options of the kubernetes subscriber
+type Config struct {
+	// Service is the name of the service
+	Service string
+	// Namespace is the namespace of the service. Defaults to the namespace of the cluster config.
+	Namespace string
+	// Port is the name or the number of the port of the endpoints. Defaults to the first one.
+	Port string
+	// Scheme is the scheme of the hosts. Defaults to http.
+	Scheme string
+	// RetryInterval is the time to wait after a failed request. Defaults to RetryInterval.
+	RetryInterval time.Duration
+	// ListTimeout is the max duration of the list requests. Defaults to ListTimeout.
+	ListTimeout time.Duration
+	// WatchTimeout is the max duration of the watch requests. Defaults to WatchTimeout.
+	WatchTimeout time.Duration
+	// Client is the http client used for the requests. It is built with the TLS config of the
+	// cluster if empty.
+	Client *http.Client
+}
+
+// New creates a kubernetes subscriber with the received configs. The EndpointSlices of the service
+// are listed before returning (bounded by the ListTimeout) and then they are watched until the context is canceled or the
+// subscriber is closed. Only the ready endpoints are exposed and the hosts are updated as soon as a
+// watch event arrives. If the API server is not available, the last known hosts are kept.
+func New(ctx context.Context, cluster ClusterConfig, cfg Config) *Subscriber {
+	if cfg.Namespace == "" {
+		cfg.Namespace = cluster.Namespace
+	}
+	if cfg.Namespace == "" {
+		cfg.Namespace = defaultNamespace
+	}
+	if cfg.Scheme == "" {
+		cfg.Scheme = "http"
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = RetryInterval
+	}
+	if cfg.ListTimeout <= 0 {
+		cfg.ListTimeout = ListTimeout
+	}
+	if cfg.WatchTimeout < time.Second {
+		cfg.WatchTimeout = WatchTimeout
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: cluster.TLSConfig,
+		}}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Subscriber{
+		cluster: cluster,
+		cfg:     cfg,
+		cancel:  cancel,
+		slices:  map[string]endpointSlice{},
+		hosts:   []string{},
+	}
+	go s.loop(ctx, s.list(ctx))
+	return s
+}
+
+// Subscriber is a subscriber watching the EndpointSlices of a kubernetes service
+type Subscriber struct {
+	cluster ClusterConfig
+	cfg     Config
+	cancel  context.CancelFunc
+
+	// slices and resourceVersion are only accessed by the goroutine watching the API server
+	// after the initial list
+	slices          map[string]endpointSlice
+	resourceVersion string
+
+	mutex sync.RWMutex
+	hosts []string
+}
+
+// Hosts implements the sd.Subscriber interface
+func (s *Subscriber) Hosts() ([]string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	hosts := make([]string, len(s.hosts))
+	copy(hosts, s.hosts)
+	return hosts, nil
+}
+
+// Close stops watching the API server
+func (s *Subscriber) Close() error {
+	s.cancel()
+	return nil
+}
+
+type objectMeta struct {
+	Name            string `json:"name"`
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+type endpointSlice struct {
+	Metadata    objectMeta `json:"metadata"`
+	AddressType string     `json:"addressType"`
+	Endpoints   []struct {
+		Addresses  []string `json:"addresses"`
+		Conditions struct {
+			Ready *bool `json:"ready"`
+		} `json:"conditions"`
+	} `json:"endpoints"`
+	Ports []struct {
+		Name string `json:"name"`
+		Port *int   `json:"port"`
+	} `json:"ports"`
+}
+
+type endpointSliceList struct {
+	Metadata objectMeta      `json:"metadata"`
+	Items    []endpointSlice `json:"items"`
+}
+
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+type status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// loop keeps watching the API server, listing the EndpointSlices again when the watch can not be
+// resumed
+func (s *Subscriber) loop(ctx context.Context, err error) {
+	for {
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.cfg.RetryInterval):
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if s.resourceVersion == "" {
+			if err = s.list(ctx); err != nil {
+				continue
+			}
+		}
+		err = s.watch(ctx)
+		if err == errGone {
+			s.resourceVersion = ""
+			err = nil
+		}
+	}
+}
+
+func (s *Subscriber) list(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.ListTimeout)
+	defer cancel()
+	resp, err := s.get(ctx, url.Values{})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	list := endpointSliceList{}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return err
+	}
+	s.slices = make(map[string]endpointSlice, len(list.Items))
+	for _, slice := range list.Items {
+		s.slices[slice.Metadata.Name] = slice
+	}
+	s.resourceVersion = list.Metadata.ResourceVersion
+	s.refresh()
+	return nil
+}
+
+// watch applies the events received from the API server until it closes the watch. The client
+// gives up a bit after the timeout sent to the server, so a stalled connection can not freeze the
+// hosts.
+func (s *Subscriber) watch(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.WatchTimeout+s.cfg.WatchTimeout/16)
+	defer cancel()
+	resp, err := s.get(ctx, url.Values{
+		"watch":               []string{"1"},
+		"resourceVersion":     []string{s.resourceVersion},
+		"allowWatchBookmarks": []string{"true"},
+		"timeoutSeconds":      []string{strconv.Itoa(int(s.cfg.WatchTimeout / time.Second))},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		event := watchEvent{}
+		if err := decoder.Decode(&event); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		if event.Type == "ERROR" {
+			st := status{}
+			json.Unmarshal(event.Object, &st)
+			if st.Code == http.StatusGone {
+				return errGone
+			}
+			return fmt.Errorf("kubernetes: watch error %d: %s", st.Code, st.Message)
+		}
+
+		slice := endpointSlice{}
+		if err := json.Unmarshal(event.Object, &slice); err != nil {
+			return err
+		}
+		s.resourceVersion = slice.Metadata.ResourceVersion
+
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			s.slices[slice.Metadata.Name] = slice
+		case "DELETED":
+			delete(s.slices, slice.Metadata.Name)
+		default:
+			continue
+		}
+		s.refresh()
+	}
+}
+
+func (s *Subscriber) get(ctx context.Context, q url.Values) (*http.Response, error) {
+	q.Set("labelSelector", serviceNameLabel+"="+s.cfg.Service)
+	u := strings.TrimRight(s.cluster.Server, "/") + "/apis/discovery.k8s.io/v1/namespaces/" +
+		url.PathEscape(s.cfg.Namespace) + "/endpointslices?" + q.Encode()
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	token, err := s.token()
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := s.cfg.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusGone {
+		resp.Body.Close()
+		return nil, errGone
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("kubernetes: unexpected status code %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+func (s *Subscriber) token() (string, error) {
+	if s.cluster.TokenFile == "" {
+		return s.cluster.Token, nil
+	}
+	b, err := ioutil.ReadFile(s.cluster.TokenFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// refresh rebuilds the hosts with the ready endpoints of the known EndpointSlices
+func (s *Subscriber) refresh() {
+	seen := map[string]struct{}{}
+	hosts := []string{}
+	for _, slice := range s.slices {
+		port, ok := s.port(slice)
+		if !ok {
+			continue
+		}
+		for _, e := range slice.Endpoints {
+			// a nil ready condition must be interpreted as ready
+			if e.Conditions.Ready != nil && !*e.Conditions.Ready {
+				continue
+			}
+			for _, address := range e.Addresses {
+				host := s.cfg.Scheme + "://" + net.JoinHostPort(address, port)
+				if _, ok := seen[host]; ok {
+					continue
+				}
+				seen[host] = struct{}{}
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	sort.Strings(hosts)
+
+	s.mutex.Lock()
+	s.hosts = hosts
+	s.mutex.Unlock()
+}
+
+// port returns the port of the slice matching the name or the number of the configured one
+func (s *Subscriber) port(slice endpointSlice) (string, bool) {
+	for _, p := range slice.Ports {
+		if p.Port == nil {
+			continue
+		}
+		port := strconv.Itoa(*p.Port)
+		if s.cfg.Port == "" || s.cfg.Port == p.Name || s.cfg.Port == port {
+			return port, true
+		}
+	}
+	return "", false
+}
+
+func parseConfig(remote *config.Backend) (Config, string) {
+	cfg := Config{Service: sd.ServiceName(remote.Host[0])}
+	if i := strings.Index(cfg.Service, "."); i > 0 {
+		cfg.Service, cfg.Namespace = cfg.Service[:i], cfg.Service[i+1:]
+	}
+	tmp, ok := remote.ExtraConfig[ConfigNamespace].(map[string]interface{})
+	if !ok {
+		return cfg, ""
+	}
+	switch v := tmp["port"].(type) {
+	case string:
+		cfg.Port = v
+	case float64:
+		cfg.Port = strconv.Itoa(int(v))
+	}
+	if v, ok := tmp["scheme"].(string); ok {
+		cfg.Scheme = v
+	}
+	kubeconfig, _ := tmp["kubeconfig"].(string)
+	return cfg, kubeconfig
+}
@@ -0,0 +1,356 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vm-affekt/krakend/config"
+	"github.com/vm-affekt/krakend/sd"
+)
+
+const initialList = `{
+	"kind": "EndpointSliceList",
+	"metadata": {"resourceVersion": "100"},
+	"items": [
+		{
+			"metadata": {"name": "users-abc", "resourceVersion": "90"},
+			"addressType": "IPv4",
+			"endpoints": [
+				{"addresses": ["10.0.0.1"], "conditions": {"ready": true}},
+				{"addresses": ["10.0.0.2"], "conditions": {"ready": false}},
+				{"addresses": ["10.0.0.3"], "conditions": {}}
+			],
+			"ports": [{"name": "metrics", "port": 9090}, {"name": "http", "port": 8080}]
+		}
+	]
+}`
+
+type fakeAPIServer struct {
+	*httptest.Server
+	events chan string
+
+	mu       sync.Mutex
+	requests int
+	lists    int
+	list     string
+	queries  []string
+	tokens   []string
+	watches  int
+}
+
+func newFakeAPIServer(list string) *fakeAPIServer {
+	f := &fakeAPIServer{events: make(chan string), list: list}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	return f
+}
+
+func (f *fakeAPIServer) handle(rw http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	f.requests++
+	f.mu.Unlock()
+	if req.URL.Path != "/apis/discovery.k8s.io/v1/namespaces/production/endpointslices" {
+		http.NotFound(rw, req)
+		return
+	}
+	q := req.URL.Query()
+	f.mu.Lock()
+	f.queries = append(f.queries, req.URL.RawQuery)
+	f.tokens = append(f.tokens, req.Header.Get("Authorization"))
+	list := f.list
+	if q.Get("watch") == "" {
+		f.lists++
+	}
+	f.mu.Unlock()
+
+	rw.Header().Set("Content-Type", "application/json")
+	if q.Get("watch") == "" {
+		fmt.Fprint(rw, list)
+		return
+	}
+
+	f.mu.Lock()
+	f.watches++
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.watches--
+		f.mu.Unlock()
+	}()
+
+	flusher := rw.(http.Flusher)
+	flusher.Flush()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case event := <-f.events:
+			fmt.Fprintln(rw, event)
+			flusher.Flush()
+		}
+	}
+}
+
+func (f *fakeAPIServer) setList(list string) {
+	f.mu.Lock()
+	f.list = list
+	f.mu.Unlock()
+}
+
+func (f *fakeAPIServer) openWatches() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.watches
+}
+
+func (f *fakeAPIServer) listCalls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lists
+}
+
+func TestSubscriber_watch(t *testing.T) {
+	f := newFakeAPIServer(initialList)
+	defer f.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(ctx, ClusterConfig{Server: f.URL, Token: "secret", Namespace: "production"}, Config{
+		Service: "users",
+		Port:    "http",
+	})
+
+	assertHosts(t, s, []string{"http://10.0.0.1:8080", "http://10.0.0.3:8080"})
+
+	f.events <- `{"type": "MODIFIED", "object": {"metadata": {"name": "users-abc", "resourceVersion": "101"}, "endpoints": [
+		{"addresses": ["10.0.0.1"], "conditions": {"ready": true}},
+		{"addresses": ["10.0.0.2"], "conditions": {"ready": true}}
+	], "ports": [{"name": "http", "port": 8080}]}}`
+	waitForHosts(t, s, []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"})
+
+	f.events <- `{"type": "ADDED", "object": {"metadata": {"name": "users-def", "resourceVersion": "102"}, "endpoints": [
+		{"addresses": ["10.0.1.1"], "conditions": {"ready": true}}
+	], "ports": [{"name": "http", "port": 8080}]}}`
+	waitForHosts(t, s, []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080", "http://10.0.1.1:8080"})
+
+	f.events <- `{"type": "BOOKMARK", "object": {"metadata": {"resourceVersion": "110"}}}`
+	f.events <- `{"type": "DELETED", "object": {"metadata": {"name": "users-abc", "resourceVersion": "111"}}}`
+	waitForHosts(t, s, []string{"http://10.0.1.1:8080"})
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	expected := []string{
+		"labelSelector=kubernetes.io%2Fservice-name%3Dusers",
+		"allowWatchBookmarks=true&labelSelector=kubernetes.io%2Fservice-name%3Dusers&resourceVersion=100&timeoutSeconds=300&watch=1",
+	}
+	if !reflect.DeepEqual(f.queries, expected) {
+		t.Errorf("unexpected queries: %v", f.queries)
+	}
+	for _, token := range f.tokens {
+		if token != "Bearer secret" {
+			t.Error("unexpected token:", token)
+		}
+	}
+}
+
+func TestSubscriber_resourceVersionGone(t *testing.T) {
+	f := newFakeAPIServer(initialList)
+	defer f.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(ctx, ClusterConfig{Server: f.URL, Namespace: "production"}, Config{Service: "users", Scheme: "https"})
+
+	assertHosts(t, s, []string{"https://10.0.0.1:9090", "https://10.0.0.3:9090"})
+
+	f.setList(`{"metadata": {"resourceVersion": "200"}, "items": [
+		{"metadata": {"name": "users-xyz"}, "endpoints": [{"addresses": ["10.0.2.1"]}], "ports": [{"port": 8080}]}
+	]}`)
+	f.events <- `{"type": "ERROR", "object": {"kind": "Status", "code": 410, "message": "too old resource version"}}`
+	waitForHosts(t, s, []string{"https://10.0.2.1:8080"})
+
+	if c := f.listCalls(); c != 2 {
+		t.Error("unexpected number of lists:", c)
+	}
+}
+
+func TestSubscriber_Close(t *testing.T) {
+	f := newFakeAPIServer(initialList)
+	defer f.Close()
+
+	s := New(context.Background(), ClusterConfig{Server: f.URL, Namespace: "production"}, Config{Service: "users"})
+	for i := 0; f.openWatches() == 0; i++ {
+		if i == 100 {
+			t.Error("the subscriber is not watching the endpoint slices")
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.Close()
+
+	for i := 0; f.openWatches() != 0; i++ {
+		if i == 100 {
+			t.Error("the watch was not closed")
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSubscriber_stalledServer(t *testing.T) {
+	var lists int32
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("watch") == "" && atomic.AddInt32(&lists, 1) > 1 {
+			fmt.Fprint(rw, initialList)
+			return
+		}
+		<-req.Context().Done()
+	}))
+	defer ts.Close()
+
+	start := time.Now()
+	s := New(context.Background(), ClusterConfig{Server: ts.URL, Namespace: "production"}, Config{
+		Service:       "users",
+		RetryInterval: time.Millisecond,
+		ListTimeout:   10 * time.Millisecond,
+	})
+	defer s.Close()
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("the list has not been bounded: %s", elapsed)
+	}
+	waitForHosts(t, s, []string{"http://10.0.0.1:9090", "http://10.0.0.3:9090"})
+}
+
+func TestSubscriber_unavailableServer(t *testing.T) {
+	f := newFakeAPIServer(initialList)
+	defer f.Close()
+	s := New(context.Background(), ClusterConfig{Server: f.URL, Namespace: "staging"}, Config{
+		Service:       "users",
+		RetryInterval: time.Millisecond,
+	})
+	defer s.Close()
+
+	time.Sleep(20 * time.Millisecond)
+	if f.listCalls() != 0 {
+		t.Error("unexpected list")
+	}
+	f.mu.Lock()
+	calls := f.requests
+	f.mu.Unlock()
+	if calls < 3 {
+		t.Error("the subscriber is not retrying the requests:", calls)
+	}
+	assertHosts(t, s, []string{})
+}
+
+func TestSubscriberFactory(t *testing.T) {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		t.Skip("running inside a cluster")
+	}
+	f := newFakeAPIServer(initialList)
+	defer f.Close()
+
+	dir, err := ioutil.TempDir("", "krakend-sd-kubernetes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	kubeconfig := filepath.Join(dir, "config")
+	writeFile(t, kubeconfig, fmt.Sprintf(kubeconfigTmpl, f.URL))
+	writeFile(t, filepath.Join(dir, "token"), "rotated\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := RegisterWithContext(ctx); err != nil {
+		t.Error("registering the kubernetes module:", err.Error())
+	}
+
+	serviceConfig := config.ServiceConfig{
+		Version: config.ConfigVersion,
+		Endpoints: []*config.EndpointConfig{
+			{
+				Endpoint: "/users",
+				Method:   "GET",
+				Backend: []*config.Backend{
+					{
+						Host:       []string{"users.production"},
+						SD:         Namespace,
+						URLPattern: "/",
+						ExtraConfig: config.ExtraConfig{
+							ConfigNamespace: map[string]interface{}{"port": 8080.0, "kubeconfig": kubeconfig},
+						},
+					},
+				},
+			},
+		},
+	}
+	if err := serviceConfig.Init(); err != nil {
+		t.Error("initializing the config:", err.Error())
+		return
+	}
+
+	s := sd.GetSubscriber(serviceConfig.Endpoints[0].Backend[0])
+	assertHosts(t, s, []string{"http://10.0.0.1:8080", "http://10.0.0.3:8080"})
+
+	f.mu.Lock()
+	token, query := f.tokens[0], f.queries[0]
+	f.mu.Unlock()
+	if token != "Bearer rotated" {
+		t.Error("unexpected token:", token)
+	}
+	if query != "labelSelector=kubernetes.io%2Fservice-name%3Dusers" {
+		t.Error("unexpected query:", query)
+	}
+
+	s = sd.GetSubscriber(&config.Backend{
+		Host: []string{"users"},
+		SD:   Namespace,
+		ExtraConfig: config.ExtraConfig{
+			ConfigNamespace: map[string]interface{}{"kubeconfig": filepath.Join(dir, "unknown")},
+		},
+	})
+	if _, err := s.Hosts(); err == nil {
+		t.Error("error expected")
+	}
+}
+
+func assertHosts(t *testing.T, s sd.Subscriber, expected []string) {
+	hosts, err := s.Hosts()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("unexpected hosts. have: %v, want: %v", hosts, expected)
+	}
+}
+
+func waitForHosts(t *testing.T, s sd.Subscriber, expected []string) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		hosts, _ := s.Hosts()
+		if reflect.DeepEqual(hosts, expected) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("unexpected hosts. have: %v, want: %v", hosts, expected)
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,12 +3,30 @@ package sd
 import (
 	"errors"
 	"math/rand"
+	"sync"
 	"sync/atomic"
 )
 
-// Balancer applys a balancing stategy in order to select the backend host to be used
+// Balancer applys a balancing stategy in order to select the backend host to be used for the
+// received request. The request may be nil.
 type Balancer interface {
-	Host() (string, error)
+	Host(*Request) (string, error)
+}
+
+// HostReleaser is implemented by the balancers tracking the requests in flight. Every host returned
+// by their Host method must be released once the request is done.
+type HostReleaser interface {
+	Release(host string)
+}
+
+// Request contains the attributes of the request to balance
+type Request struct {
+	Path    string
+	Params  map[string]string
+	Headers map[string][]string
+	// Excluded contains the hosts already used by previous attempts of the same request. The
+	// balancers always returning the same host for a request should skip them if possible.
+	Excluded map[string]struct{}
 }
 
 // ErrNoHosts is the error the balancer must return when there are 0 hosts ready
@@ -31,7 +49,7 @@ type roundRobinLB struct {
 }
 
 // Host implements the balancer interface
-func (rr *roundRobinLB) Host() (string, error) {
+func (rr *roundRobinLB) Host(_ *Request) (string, error) {
 	hosts, err := rr.subscriber.Hosts()
 	if err != nil {
 		return "", err
@@ -60,7 +78,7 @@ type randomLB struct {
 }
 
 // Host implements the balancer interface
-func (r *randomLB) Host() (string, error) {
+func (r *randomLB) Host(_ *Request) (string, error) {
 	hosts, err := r.subscriber.Hosts()
 	if err != nil {
 		return "", err
@@ -71,6 +89,105 @@ func (r *randomLB) Host() (string, error) {
 	return hosts[r.rnd.Intn(len(hosts))], nil
 }
 
+// NewWeightedRoundRobinLB returns a new balancer using a smooth weighted round robin strategy. The
+// weights are taken from the subscriber, if it is a WeightedSubscriber. Otherwise, all the hosts
+// have the same weight.
+func NewWeightedRoundRobinLB(subscriber Subscriber) Balancer {
+	if s, ok := subscriber.(FixedSubscriber); ok && len(s) == 1 {
+		return nopBalancer(s[0])
+	}
+	return &weightedRoundRobinLB{
+		subscriber: subscriber,
+		current:    map[string]int{},
+	}
+}
+
+type weightedRoundRobinLB struct {
+	subscriber Subscriber
+	mu         sync.Mutex
+	current    map[string]int
+}
+
+// Host implements the balancer interface
+func (w *weightedRoundRobinLB) Host(_ *Request) (string, error) {
+	hosts, err := getWeightedHosts(w.subscriber)
+	if err != nil {
+		return "", err
+	}
+	if len(hosts) <= 0 {
+		return "", ErrNoHosts
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	current := make(map[string]int, len(hosts))
+	total, best := 0, -1
+	for i, h := range hosts {
+		current[h.Host] = w.current[h.Host] + h.Weight
+		total += h.Weight
+		if best < 0 || current[h.Host] > current[hosts[best].Host] {
+			best = i
+		}
+	}
+	current[hosts[best].Host] -= total
+	w.current = current
+	return hosts[best].Host, nil
+}
+
+// NewLeastConnectionsLB returns a new balancer selecting the host with less requests in flight.
+// The returned balancer implements the HostReleaser interface.
+func NewLeastConnectionsLB(subscriber Subscriber) Balancer {
+	return &leastConnectionsLB{
+		subscriber: subscriber,
+		inFlight:   map[string]int{},
+	}
+}
+
+type leastConnectionsLB struct {
+	subscriber Subscriber
+	mu         sync.Mutex
+	inFlight   map[string]int
+	counter    int
+}
+
+// Host implements the balancer interface
+func (l *leastConnectionsLB) Host(_ *Request) (string, error) {
+	hosts, err := l.subscriber.Hosts()
+	if err != nil {
+		return "", err
+	}
+	if len(hosts) <= 0 {
+		return "", ErrNoHosts
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// the search starts at a different host every time, so the ties are balanced
+	l.counter++
+	best := ""
+	for i := range hosts {
+		h := hosts[(l.counter+i)%len(hosts)]
+		if best == "" || l.inFlight[h] < l.inFlight[best] {
+			best = h
+		}
+	}
+	l.inFlight[best]++
+	return best, nil
+}
+
+// Release implements the HostReleaser interface
+func (l *leastConnectionsLB) Release(host string) {
+	l.mu.Lock()
+	if l.inFlight[host] <= 1 {
+		delete(l.inFlight, host)
+	} else {
+		l.inFlight[host]--
+	}
+	l.mu.Unlock()
+}
+
 type nopBalancer string
 
-func (b nopBalancer) Host() (string, error) { return string(b), nil }
+func (b nopBalancer) Host(_ *Request) (string, error) { return string(b), nil }
@@ -17,7 +17,7 @@ func BenchmarkRoundRobinLB(b *testing.B) {
 			balancer := NewRoundRobinLB(FixedSubscriber(testCase))
 			b.ResetTimer()
 			for i := 0; i < b.N; i++ {
-				balancer.Host()
+				balancer.Host(nil)
 			}
 		})
 	}
@@ -30,7 +30,7 @@ func BenchmarkRoundRobinLB_parallel(b *testing.B) {
 			b.ResetTimer()
 			b.RunParallel(func(pb *testing.PB) {
 				for pb.Next() {
-					balancer.Host()
+					balancer.Host(nil)
 				}
 			})
 		})
@@ -43,7 +43,7 @@ func BenchmarkRandomLB(b *testing.B) {
 			balancer := NewRandomLB(FixedSubscriber(testCase), 1415926)
 			b.ResetTimer()
 			for i := 0; i < b.N; i++ {
-				balancer.Host()
+				balancer.Host(nil)
 			}
 		})
 	}
@@ -27,7 +27,7 @@ func TestRoundRobinLB(t *testing.T) {
 			balancer := NewRoundRobinLB(subscriber)
 
 			for i := 0; i < iterations; i++ {
-				endpoint, err := balancer.Host()
+				endpoint, err := balancer.Host(nil)
 				if err != nil {
 					t.Fail()
 				}
@@ -50,7 +50,7 @@ func TestRoundRobinLB(t *testing.T) {
 func TestRoundRobinLB_noEndpoints(t *testing.T) {
 	subscriber := FixedSubscriber{}
 	balancer := NewRoundRobinLB(subscriber)
-	_, err := balancer.Host()
+	_, err := balancer.Host(nil)
 	if want, have := ErrNoHosts, err; want != have {
 		t.Errorf("want %v, have %v", want, have)
 	}
@@ -75,7 +75,7 @@ func TestRandomLB(t *testing.T) {
 	balancer := NewRandomLB(subscriber, seed)
 
 	for i := 0; i < iterations; i++ {
-		endpoint, err := balancer.Host()
+		endpoint, err := balancer.Host(nil)
 		if err != nil {
 			t.Fail()
 		}
@@ -97,7 +97,7 @@ func TestRandomLB_single(t *testing.T) {
 	balancer := NewRandomLB(subscriber, int64(12345))
 
 	for i := 0; i < iterations; i++ {
-		endpoint, err := balancer.Host()
+		endpoint, err := balancer.Host(nil)
 		if err != nil {
 			t.Fail()
 		}
@@ -110,7 +110,7 @@ func TestRandomLB_single(t *testing.T) {
 func TestRandomLB_noEndpoints(t *testing.T) {
 	subscriber := FixedSubscriberFactory(&config.Backend{})
 	balancer := NewRandomLB(subscriber, 1415926)
-	_, err := balancer.Host()
+	_, err := balancer.Host(nil)
 	if want, have := ErrNoHosts, err; want != have {
 		t.Errorf("want %v, have %v", want, have)
 	}
@@ -123,7 +123,7 @@ func (s erroredSubscriber) Hosts() ([]string, error) { return []string{}, errors
 func TestRoundRobinLB_erroredSubscriber(t *testing.T) {
 	want := "supu"
 	balancer := NewRoundRobinLB(erroredSubscriber(want))
-	host, have := balancer.Host()
+	host, have := balancer.Host(nil)
 	if host != "" || want != have.Error() {
 		t.Errorf("want %s, have %s", want, have.Error())
 	}
@@ -132,8 +132,91 @@ func TestRoundRobinLB_erroredSubscriber(t *testing.T) {
 func TestRandomLB_erroredSubscriber(t *testing.T) {
 	want := "supu"
 	balancer := NewRandomLB(erroredSubscriber(want), 1415926)
-	host, have := balancer.Host()
+	host, have := balancer.Host(nil)
 	if host != "" || want != have.Error() {
 		t.Errorf("want %s, have %s", want, have.Error())
 	}
 }
+
+func TestWeightedRoundRobinLB(t *testing.T) {
+	subscriber := FixedWeightedSubscriber{
+		{Host: "a", Weight: 5},
+		{Host: "b", Weight: 1},
+		{Host: "c", Weight: 1},
+		{Host: "d", Weight: 0},
+	}
+	balancer := NewWeightedRoundRobinLB(subscriber)
+
+	counts := map[string]int{}
+	sequence := ""
+	for i := 0; i < 700; i++ {
+		host, err := balancer.Host(nil)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		counts[host]++
+		if i < 7 {
+			sequence += host
+		}
+	}
+	if counts["a"] != 500 || counts["b"] != 100 || counts["c"] != 100 || counts["d"] != 0 {
+		t.Errorf("unexpected distribution: %v", counts)
+	}
+	// the smooth weighted round robin interleaves the hosts
+	if sequence != "aabacaa" {
+		t.Errorf("unexpected sequence: %s", sequence)
+	}
+}
+
+func TestWeightedRoundRobinLB_unweighted(t *testing.T) {
+	balancer := NewWeightedRoundRobinLB(FixedSubscriber{"a", "b", "c"})
+	counts := map[string]int{}
+	for i := 0; i < 300; i++ {
+		host, _ := balancer.Host(nil)
+		counts[host]++
+	}
+	for _, h := range []string{"a", "b", "c"} {
+		if counts[h] != 100 {
+			t.Errorf("unexpected distribution: %v", counts)
+		}
+	}
+}
+
+func TestLeastConnectionsLB(t *testing.T) {
+	balancer := NewLeastConnectionsLB(FixedSubscriber{"a", "b", "c"})
+	releaser, ok := balancer.(HostReleaser)
+	if !ok {
+		t.Error("the balancer should implement the HostReleaser interface")
+		return
+	}
+
+	busy := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		host, err := balancer.Host(nil)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if busy[host] {
+			t.Errorf("host %s selected while other hosts were idle", host)
+		}
+		busy[host] = true
+	}
+
+	releaser.Release("b")
+	for i := 0; i < 3; i++ {
+		host, _ := balancer.Host(nil)
+		if host != "b" {
+			t.Errorf("unexpected host: %s", host)
+		}
+		releaser.Release(host)
+	}
+}
+
+func TestLeastConnectionsLB_noEndpoints(t *testing.T) {
+	balancer := NewLeastConnectionsLB(FixedSubscriber{})
+	if _, err := balancer.Host(nil); err != ErrNoHosts {
+		t.Errorf("want %v, have %v", ErrNoHosts, err)
+	}
+}
@@ -1,7 +1,12 @@
 // Package sd defines some interfaces and implementations for service discovery
 package sd
 
-import "github.com/vm-affekt/krakend/config"
+import (
+	"sort"
+	"strings"
+
+	"github.com/vm-affekt/krakend/config"
+)
 
 // Subscriber keeps the set of backend hosts up to date
 type Subscriber interface {
@@ -21,6 +26,94 @@ type FixedSubscriber []string
 // Hosts implements the subscriber interface
 func (s FixedSubscriber) Hosts() ([]string, error) { return s, nil }
 
+// WeightedHost is a backend host with its relative weight
+type WeightedHost struct {
+	Host   string
+	Weight int
+}
+
+// WeightedSubscriber is implemented by the subscribers knowing the weight of their hosts
+type WeightedSubscriber interface {
+	Subscriber
+	WeightedHosts() ([]WeightedHost, error)
+}
+
+// FixedWeightedSubscriber has a constant set of weighted backend hosts
+type FixedWeightedSubscriber []WeightedHost
+
+// Hosts implements the subscriber interface
+func (s FixedWeightedSubscriber) Hosts() ([]string, error) {
+	hosts := make([]string, len(s))
+	for i, h := range s {
+		hosts[i] = h.Host
+	}
+	return hosts, nil
+}
+
+// WeightedHosts implements the WeightedSubscriber interface
+func (s FixedWeightedSubscriber) WeightedHosts() ([]WeightedHost, error) { return s, nil }
+
+// PrioritizedHost is a weighted backend host with its priority. The hosts with the lowest priority
+// value are preferred.
+type PrioritizedHost struct {
+	Host     string
+	Weight   int
+	Priority int
+}
+
+// PrioritizedSubscriber is implemented by the subscribers grouping their hosts by priority. Their
+// Hosts and WeightedHosts methods return only the hosts of the preferred group. The decorators
+// aware of the health of the hosts fail over to the next groups when the preferred one is
+// unhealthy (see HealthCheckSubscriber).
+type PrioritizedSubscriber interface {
+	WeightedSubscriber
+	PrioritizedHosts() ([]PrioritizedHost, error)
+}
+
+// GroupByPriority splits the hosts into groups sharing the same priority, sorted from the preferred
+// one. The order of the hosts inside each group is kept.
+func GroupByPriority(hosts []PrioritizedHost) [][]WeightedHost {
+	sorted := make([]PrioritizedHost, len(hosts))
+	copy(sorted, hosts)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Priority < sorted[j].Priority })
+
+	groups := [][]WeightedHost{}
+	for i, h := range sorted {
+		if i == 0 || h.Priority != sorted[i-1].Priority {
+			groups = append(groups, []WeightedHost{})
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], WeightedHost{Host: h.Host, Weight: h.Weight})
+	}
+	return groups
+}
+
+// getWeightedHosts returns the weighted hosts of the subscriber. The hosts of the subscribers not
+// implementing the WeightedSubscriber interface get a weight of 1. Non positive weights are ignored.
+func getWeightedHosts(s Subscriber) ([]WeightedHost, error) {
+	if ws, ok := s.(WeightedSubscriber); ok {
+		hosts, err := ws.WeightedHosts()
+		if err != nil {
+			return hosts, err
+		}
+		res := make([]WeightedHost, 0, len(hosts))
+		for _, h := range hosts {
+			if h.Weight > 0 {
+				res = append(res, h)
+			}
+		}
+		return res, nil
+	}
+	hosts, err := s.Hosts()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]WeightedHost, len(hosts))
+	for i, h := range hosts {
+		res[i] = WeightedHost{Host: h, Weight: 1}
+	}
+	return res, nil
+}
+
 // SubscriberFactory builds subscribers with the received config
 type SubscriberFactory func(*config.Backend) Subscriber
 
@@ -28,3 +121,12 @@ type SubscriberFactory func(*config.Backend) Subscriber
 func FixedSubscriberFactory(cfg *config.Backend) Subscriber {
 	return FixedSubscriber(cfg.Host)
 }
+
+// ServiceName returns the name of the service declared as the host of a backend, removing the scheme
+// added by the host sanitization of the config parser (see config.URI.CleanHost)
+func ServiceName(host string) string {
+	if i := strings.Index(host, "://"); i >= 0 {
+		return host[i+3:]
+	}
+	return host
+}
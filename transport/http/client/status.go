@@ -6,6 +6,8 @@ import (
 	"errors"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/vm-affekt/krakend/config"
 )
@@ -20,20 +22,32 @@ var ErrInvalidStatusCode = errors.New("Invalid status code")
 // HTTPStatusHandler defines how we tread the http response code
 type HTTPStatusHandler func(context.Context, *http.Response) (*http.Response, error)
 
-// GetHTTPStatusHandler returns a status handler. If the 'return_error_details' key is defined
-// at the extra config, it returns a DetailedHTTPStatusHandler. Otherwise, it returns a
-// DefaultHTTPStatusHandler
+// GetHTTPStatusHandler returns a status handler. If the 'success_status_codes' or the
+// 'status_code_mapping' keys are defined at the extra config, the default handler is replaced
+// by a ConfigurableHTTPStatusHandler. If the 'return_error_details' key is defined, the handler
+// is wrapped with a DetailedHTTPStatusHandler.
+//
+//	"github.com/vm-affekt/krakend/http": {
+//		"success_status_codes": [200, 201, 202, 204],
+//		"status_code_mapping": {"404": 404, "4xx": 400, "5xx": 502}
+//	}
 func GetHTTPStatusHandler(remote *config.Backend) HTTPStatusHandler {
+	sh := DefaultHTTPStatusHandler
 	if e, ok := remote.ExtraConfig[Namespace]; ok {
 		if m, ok := e.(map[string]interface{}); ok {
+			successCodes := getStatusCodes(m["success_status_codes"])
+			mapping := getStatusCodeMapping(m["status_code_mapping"])
+			if len(successCodes) > 0 || len(mapping) > 0 {
+				sh = ConfigurableHTTPStatusHandler(successCodes, mapping)
+			}
 			if v, ok := m["return_error_details"]; ok {
 				if b, ok := v.(string); ok && b != "" {
-					return DetailedHTTPStatusHandler(DefaultHTTPStatusHandler, b)
+					return DetailedHTTPStatusHandler(sh, b)
 				}
 			}
 		}
 	}
-	return DefaultHTTPStatusHandler
+	return sh
 }
 
 // DefaultHTTPStatusHandler is the default implementation of HTTPStatusHandler
@@ -45,17 +59,97 @@ func DefaultHTTPStatusHandler(ctx context.Context, resp *http.Response) (*http.R
 	return resp, nil
 }
 
+// BackendStatusHTTPStatusHandler returns a HTTPStatusHandler replacing the ErrInvalidStatusCode
+// returned by the wrapped one with an UnexpectedStatusCodeError, so the status code of the backend
+// is not lost
+func BackendStatusHTTPStatusHandler(next HTTPStatusHandler) HTTPStatusHandler {
+	return func(ctx context.Context, resp *http.Response) (*http.Response, error) {
+		r, err := next(ctx, resp)
+		if err == ErrInvalidStatusCode {
+			return r, UnexpectedStatusCodeError{Code: resp.StatusCode}
+		}
+		return r, err
+	}
+}
+
+// ConfigurableHTTPStatusHandler returns a HTTPStatusHandler accepting the received status codes
+// (or 200 and 201, if empty). The rejected status codes are translated with the mapping table into
+// the status code to return to the client. The keys of the table are status codes or classes of
+// status codes, like "4xx". When no entry matches, an UnexpectedStatusCodeError is returned.
+func ConfigurableHTTPStatusHandler(successCodes []int, mapping map[string]int) HTTPStatusHandler {
+	if len(successCodes) == 0 {
+		successCodes = []int{http.StatusOK, http.StatusCreated}
+	}
+	accepted := make(map[int]struct{}, len(successCodes))
+	for _, code := range successCodes {
+		accepted[code] = struct{}{}
+	}
+	return func(_ context.Context, resp *http.Response) (*http.Response, error) {
+		if _, ok := accepted[resp.StatusCode]; ok {
+			return resp, nil
+		}
+		status, ok := mapping[strconv.Itoa(resp.StatusCode)]
+		if !ok {
+			status, ok = mapping[strconv.Itoa(resp.StatusCode/100)+"xx"]
+		}
+		if !ok {
+			return nil, UnexpectedStatusCodeError{Code: resp.StatusCode}
+		}
+		return nil, InvalidStatusCodeError{Code: resp.StatusCode, Status: status}
+	}
+}
+
+func getStatusCodes(v interface{}) []int {
+	tmp, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+	res := make([]int, 0, len(tmp))
+	for _, c := range tmp {
+		switch code := c.(type) {
+		case float64:
+			res = append(res, int(code))
+		case int:
+			res = append(res, code)
+		}
+	}
+	return res
+}
+
+func getStatusCodeMapping(v interface{}) map[string]int {
+	tmp, ok := v.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	res := make(map[string]int, len(tmp))
+	for k, c := range tmp {
+		switch code := c.(type) {
+		case float64:
+			res[strings.ToLower(k)] = int(code)
+		case int:
+			res[strings.ToLower(k)] = code
+		}
+	}
+	return res
+}
+
 // NoOpHTTPStatusHandler is a NO-OP implementation of HTTPStatusHandler
 func NoOpHTTPStatusHandler(_ context.Context, resp *http.Response) (*http.Response, error) {
 	return resp, nil
 }
 
-// DetailedHTTPStatusHandler is a HTTPStatusHandler implementation
+// DetailedHTTPStatusHandler is a HTTPStatusHandler implementation. The status code of the error is
+// the one returned by the backend, unless the wrapped handler maps it to another one.
 func DetailedHTTPStatusHandler(next HTTPStatusHandler, name string) HTTPStatusHandler {
 	return func(ctx context.Context, resp *http.Response) (*http.Response, error) {
-		if r, err := next(ctx, resp); err == nil {
+		r, err := next(ctx, resp)
+		if err == nil {
 			return r, nil
 		}
+		code := resp.StatusCode
+		if t, ok := err.(InvalidStatusCodeError); ok {
+			code = t.StatusCode()
+		}
 
 		body, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
@@ -65,7 +159,7 @@ func DetailedHTTPStatusHandler(next HTTPStatusHandler, name string) HTTPStatusHa
 		resp.Body = ioutil.NopCloser(bytes.NewBuffer(body))
 
 		return resp, HTTPResponseError{
-			Code: resp.StatusCode,
+			Code: code,
 			Msg:  string(body),
 			name: name,
 		}
@@ -93,3 +187,47 @@ func (r HTTPResponseError) Name() string {
 func (r HTTPResponseError) StatusCode() int {
 	return r.Code
 }
+
+// InvalidStatusCodeError is the error returned by the ConfigurableHTTPStatusHandler when the
+// status code of the backend has an entry in the mapping table
+type InvalidStatusCodeError struct {
+	Code   int
+	Status int
+}
+
+// Error returns the error message
+func (r InvalidStatusCodeError) Error() string {
+	return ErrInvalidStatusCode.Error()
+}
+
+// StatusCode returns the status code to send to the client
+func (r InvalidStatusCodeError) StatusCode() int {
+	return r.Status
+}
+
+// BackendStatusCode returns the status code returned by the backend
+func (r InvalidStatusCodeError) BackendStatusCode() int {
+	return r.Code
+}
+
+// UnexpectedStatusCodeError is the error returned by the ConfigurableHTTPStatusHandler and the
+// BackendStatusHTTPStatusHandler when the status code of the backend is not accepted. It matches
+// the ErrInvalidStatusCode (see errors.Is).
+type UnexpectedStatusCodeError struct {
+	Code int
+}
+
+// Error returns the error message
+func (r UnexpectedStatusCodeError) Error() string {
+	return ErrInvalidStatusCode.Error()
+}
+
+// BackendStatusCode returns the status code returned by the backend
+func (r UnexpectedStatusCodeError) BackendStatusCode() int {
+	return r.Code
+}
+
+// Is reports whether the target is the ErrInvalidStatusCode
+func (r UnexpectedStatusCodeError) Is(target error) bool {
+	return target == ErrInvalidStatusCode
+}
@@ -3,6 +3,7 @@ package client
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"testing"
@@ -122,6 +123,117 @@ func TestDefaultHTTPStatusHandler(t *testing.T) {
 	}
 }
 
+func TestBackendStatusHTTPStatusHandler(t *testing.T) {
+	sh := BackendStatusHTTPStatusHandler(DefaultHTTPStatusHandler)
+	for _, code := range statusCodes {
+		_, err := sh(context.Background(), &http.Response{StatusCode: code})
+		if err != (UnexpectedStatusCodeError{Code: code}) {
+			t.Errorf("#%d unexpected error: %v", code, err)
+		}
+		if !errors.Is(err, ErrInvalidStatusCode) {
+			t.Errorf("#%d the error does not match the ErrInvalidStatusCode", code)
+		}
+	}
+	resp := &http.Response{StatusCode: http.StatusOK}
+	if r, err := sh(context.Background(), resp); r != resp || err != nil {
+		t.Errorf("unexpected result: %v, %v", r, err)
+	}
+}
+
+func TestGetHTTPStatusHandler_configurable(t *testing.T) {
+	cfg := &config.Backend{
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				"success_status_codes": []interface{}{200.0, 202.0, 204.0},
+				"status_code_mapping": map[string]interface{}{
+					"404": 404.0,
+					"4XX": 400.0,
+					"5xx": 502.0,
+				},
+			},
+		},
+	}
+	sh := GetHTTPStatusHandler(cfg)
+
+	for _, code := range []int{http.StatusOK, http.StatusAccepted, http.StatusNoContent} {
+		resp := &http.Response{StatusCode: code}
+		if r, err := sh(context.Background(), resp); r != resp || err != nil {
+			t.Errorf("#%d unexpected result: %v, %v", code, r, err)
+		}
+	}
+
+	for code, expected := range map[int]int{
+		http.StatusNotFound:            http.StatusNotFound,
+		http.StatusConflict:            http.StatusBadRequest,
+		http.StatusServiceUnavailable:  http.StatusBadGateway,
+		http.StatusInternalServerError: http.StatusBadGateway,
+	} {
+		r, err := sh(context.Background(), &http.Response{StatusCode: code})
+		if r != nil {
+			t.Errorf("#%d unexpected response: %v", code, r)
+		}
+		e, ok := err.(InvalidStatusCodeError)
+		if !ok {
+			t.Errorf("#%d unexpected error type %T: %v", code, err, err)
+			continue
+		}
+		if e.StatusCode() != expected || e.BackendStatusCode() != code {
+			t.Errorf("#%d unexpected status codes: %d, %d", code, e.StatusCode(), e.BackendStatusCode())
+		}
+	}
+
+	r, err := sh(context.Background(), &http.Response{StatusCode: http.StatusCreated})
+	if r != nil || err != (UnexpectedStatusCodeError{Code: http.StatusCreated}) {
+		t.Errorf("unexpected result: %v, %v", r, err)
+	}
+}
+
+func TestGetHTTPStatusHandler_detailedMapping(t *testing.T) {
+	cfg := &config.Backend{
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				"status_code_mapping":  map[string]interface{}{"5xx": 502.0},
+				"return_error_details": "some",
+			},
+		},
+	}
+	sh := GetHTTPStatusHandler(cfg)
+
+	for code, expected := range map[int]int{
+		http.StatusServiceUnavailable: http.StatusBadGateway,
+		http.StatusNotFound:           http.StatusNotFound,
+	} {
+		resp := &http.Response{
+			StatusCode: code,
+			Body:       ioutil.NopCloser(bytes.NewBufferString("boom")),
+		}
+		_, err := sh(context.Background(), resp)
+		e, ok := err.(HTTPResponseError)
+		if !ok {
+			t.Errorf("#%d unexpected error type %T: %v", code, err, err)
+			continue
+		}
+		if e.StatusCode() != expected || e.Msg != "boom" || e.Name() != "some" {
+			t.Errorf("#%d unexpected error: %+v", code, e)
+		}
+	}
+}
+
+func TestConfigurableHTTPStatusHandler_noMapping(t *testing.T) {
+	sh := ConfigurableHTTPStatusHandler(nil, nil)
+	for _, code := range []int{http.StatusOK, http.StatusCreated} {
+		resp := &http.Response{StatusCode: code}
+		if r, err := sh(context.Background(), resp); r != resp || err != nil {
+			t.Errorf("#%d unexpected result: %v, %v", code, r, err)
+		}
+	}
+	for _, code := range statusCodes {
+		if _, err := sh(context.Background(), &http.Response{StatusCode: code}); err != (UnexpectedStatusCodeError{Code: code}) {
+			t.Errorf("#%d unexpected error: %v", code, err)
+		}
+	}
+}
+
 var statusCodes = []int{
 	http.StatusBadRequest,
 	http.StatusUnauthorized,